@@ -0,0 +1,421 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"real-time-forum/models"
+)
+
+// Kinds of content attachments can belong to.
+const (
+	AttachedToPost    = "post"
+	AttachedToComment = "comment"
+	AttachedToMessage = "message"
+	AttachedToDraft   = "draft"
+)
+
+// ErrAttachmentNotFound is returned when an attachment doesn't exist, isn't
+// the user's, or is already in use elsewhere.
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// usable matches attachments that are free to attach: not used yet, or on one
+// of the uploader's drafts, which is where a published draft's images move
+// from.
+const usable = `(item_id IS NULL OR item_type = 'draft')`
+
+// Variants of an attachment's image besides the original.
+const (
+	VariantMedium = "medium"
+	VariantThumb  = "thumb"
+)
+
+// AttachmentURL is where attachment id, or its variant when that isn't empty,
+// is served. URLs go by attachment rather than by stored file, so they don't
+// give away which uploads share a file.
+func AttachmentURL(id, variant string) string {
+	if variant == "" {
+		return "/uploads/" + id
+	}
+	return "/uploads/" + id + "/" + variant
+}
+
+// setAttachmentURLs fills in where a's file and its smaller variants are
+// served.
+func setAttachmentURLs(a *models.Attachment) {
+	a.URL = AttachmentURL(a.ID, "")
+	a.MediumURL = AttachmentURL(a.ID, VariantMedium)
+	a.ThumbnailURL = AttachmentURL(a.ID, VariantThumb)
+}
+
+// AttachmentFile returns the name of the stored file attachment id's variant
+// ("" for the original) is served from. A variant that wasn't made, because
+// the image is already small, falls back to the next size up.
+func AttachmentFile(id, variant string) (string, bool) {
+	var filename, thumb, medium string
+	err := DB.QueryRow(
+		`SELECT filename, thumb_filename, medium_filename FROM attachments WHERE id = ?`, id,
+	).Scan(&filename, &thumb, &medium)
+	if err != nil {
+		return "", false
+	}
+	switch variant {
+	case "":
+		return filename, true
+	case VariantThumb:
+		if thumb != "" {
+			return thumb, true
+		}
+		fallthrough
+	case VariantMedium:
+		if medium != "" {
+			return medium, true
+		}
+		return filename, true
+	}
+	return "", false
+}
+
+// CreateAttachment records that userID just uploaded a.Hash, whose file and
+// variants are a.Filename and the rest, and fills in a's URLs. It isn't
+// attached to anything yet. newFile says the files were just stored; if an
+// identical upload recorded its own first, a is switched to share those, and
+// the new files are the caller's to remove. Otherwise the files are shared
+// with an earlier upload, and ErrUploadedFileGone is returned if they were
+// cleaned up in the meantime. It fails with
+// ErrDailyUploadLimit or ErrStorageQuota if the upload would go over either
+// limit; 0 means none.
+func CreateAttachment(userID string, a *models.Attachment, newFile bool, quotaBytes, dailyUploads int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	usage, err := uploadUsage(tx, userID)
+	if err != nil {
+		return err
+	}
+	if dailyUploads > 0 && usage.UploadsToday >= dailyUploads {
+		return ErrDailyUploadLimit
+	}
+	// The whole size counts even when the file is shared with someone
+	// else's upload, so the quota doesn't give away what others uploaded
+	if quotaBytes > 0 && usage.UsedBytes+a.Size > quotaBytes {
+		return ErrStorageQuota
+	}
+
+	inserted := false
+	if newFile {
+		res, err := tx.Exec(`
+			INSERT INTO upload_files (hash, filename, thumb_filename, medium_filename, content_type, size, width, height, refs)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1)
+			ON CONFLICT(hash) DO NOTHING`,
+			a.Hash, a.Filename, a.ThumbFilename, a.MediumFilename, a.ContentType, a.Size, a.Width, a.Height,
+		)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		inserted = n > 0
+	}
+	if !inserted {
+		// An identical upload may have stored the same files first
+		err := tx.QueryRow(`
+			UPDATE upload_files SET refs = refs + 1 WHERE hash = ?
+			RETURNING filename, thumb_filename, medium_filename, content_type, size, width, height`, a.Hash,
+		).Scan(&a.Filename, &a.ThumbFilename, &a.MediumFilename, &a.ContentType, &a.Size, &a.Width, &a.Height)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUploadedFileGone
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO attachments (id, user_id, filename, thumb_filename, medium_filename, content_type, size, width, height, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, userID, a.Filename, a.ThumbFilename, a.MediumFilename, a.ContentType, a.Size, a.Width, a.Height, a.Hash,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO upload_days (user_id, day, uploads) VALUES (?, ?, 1)
+		ON CONFLICT(user_id, day) DO UPDATE SET uploads = uploads + 1`,
+		userID, uploadDay(now()),
+	); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	setAttachmentURLs(a)
+	return nil
+}
+
+// AttachmentsUsable reports whether userID can attach every one of ids.
+func AttachmentsUsable(userID string, ids []string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	args := []any{userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	var n int
+	DB.QueryRow(
+		`SELECT COUNT(*) FROM attachments WHERE user_id = ? AND `+usable+`
+		 AND id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, args...,
+	).Scan(&n)
+	return n == len(ids)
+}
+
+// SetAttachments makes refs, in order and with their alt text, the
+// attachments of userID's post, comment, message or draft itemID. Ones it had
+// before that aren't in refs are let go, to be cleaned up with other unused
+// uploads. It fails with ErrAttachmentNotFound, changing nothing, if userID
+// can't use one of refs.
+func SetAttachments(userID, itemType, itemID string, refs []models.Attachment) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE attachments SET item_type = NULL, item_id = NULL, position = 0
+		 WHERE user_id = ? AND item_type = ? AND item_id = ?`, userID, itemType, itemID,
+	); err != nil {
+		return err
+	}
+	for i, ref := range refs {
+		res, err := tx.Exec(
+			`UPDATE attachments SET item_type = ?, item_id = ?, position = ?, alt = ?
+			 WHERE id = ? AND user_id = ? AND `+usable,
+			itemType, itemID, i, ref.Alt, ref.ID, userID,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrAttachmentNotFound
+		}
+	}
+	return tx.Commit()
+}
+
+// ReleaseAttachments lets go of the attachments of a post, comment, message or
+// draft that is being deleted, so their files are cleaned up.
+func ReleaseAttachments(itemType, itemID string) {
+	DB.Exec(`UPDATE attachments SET item_type = NULL, item_id = NULL, position = 0
+		WHERE item_type = ? AND item_id = ?`, itemType, itemID)
+}
+
+// UnusedAttachments returns the attachments uploaded before cutoff that
+// nothing uses.
+func UnusedAttachments(cutoff time.Time) ([]models.Attachment, error) {
+	rows, err := DB.Query(`
+		SELECT id, filename, thumb_filename, medium_filename, hash FROM attachments
+		WHERE item_id IS NULL AND created_at < ?`, sqlTime(cutoff.UTC()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.Attachment{}
+	for rows.Next() {
+		var a models.Attachment
+		if err := rows.Scan(&a.ID, &a.Filename, &a.ThumbFilename, &a.MediumFilename, &a.Hash); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// DeleteUnusedAttachment deletes attachment a if it still isn't used. It
+// reports whether it was deleted, and whether that was the last reference to
+// its file, which can then be removed along with its variants.
+func DeleteUnusedAttachment(a models.Attachment) (deleted, fileUnused bool, err error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM attachments WHERE id = ? AND item_id IS NULL`, a.ID)
+	if err != nil {
+		return false, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, false, nil
+	}
+	if a.Hash != "" {
+		if _, err := tx.Exec(`UPDATE upload_files SET refs = refs - 1 WHERE hash = ?`, a.Hash); err != nil {
+			return false, false, err
+		}
+		res, err := tx.Exec(`DELETE FROM upload_files WHERE hash = ? AND refs <= 0`, a.Hash)
+		if err != nil {
+			return false, false, err
+		}
+		n, _ := res.RowsAffected()
+		fileUnused = n > 0
+	} else {
+		// Uploads from before files were shared by hash aren't counted
+		var others int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM attachments WHERE filename = ?`, a.Filename).Scan(&others); err != nil {
+			return false, false, err
+		}
+		fileUnused = others == 0
+	}
+	return true, fileUnused, tx.Commit()
+}
+
+// attachmentsFor loads the attachments of each of the given posts, comments,
+// messages or drafts, in order.
+func attachmentsFor(itemType string, ids []string) map[string][]models.Attachment {
+	byID := map[string][]models.Attachment{}
+	if len(ids) == 0 {
+		return byID
+	}
+	args := []any{itemType}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := DB.Query(`
+		SELECT item_id, id, filename, thumb_filename, medium_filename, alt, content_type, size, width, height
+		FROM attachments
+		WHERE item_type = ? AND item_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+		ORDER BY position`, args...,
+	)
+	if err != nil {
+		return byID
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itemID string
+		var a models.Attachment
+		if err := rows.Scan(
+			&itemID, &a.ID, &a.Filename, &a.ThumbFilename, &a.MediumFilename,
+			&a.Alt, &a.ContentType, &a.Size, &a.Width, &a.Height,
+		); err != nil {
+			return byID
+		}
+		setAttachmentURLs(&a)
+		byID[itemID] = append(byID[itemID], a)
+	}
+	return byID
+}
+
+// attachmentsOf loads the attachments of a single post, comment, message or
+// draft.
+func attachmentsOf(itemType, id string) []models.Attachment {
+	return orEmpty(attachmentsFor(itemType, []string{id})[id])
+}
+
+func attachPostAttachments(posts []models.Post) {
+	ids := make([]string, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	byID := attachmentsFor(AttachedToPost, ids)
+	for i := range posts {
+		posts[i].Attachments = orEmpty(byID[posts[i].ID])
+	}
+}
+
+func attachCommentAttachments(comments []models.Comment) {
+	ids := make([]string, len(comments))
+	for i, c := range comments {
+		ids[i] = c.ID
+	}
+	byID := attachmentsFor(AttachedToComment, ids)
+	for i := range comments {
+		comments[i].Attachments = orEmpty(byID[comments[i].ID])
+	}
+}
+
+func attachMessageAttachments(msgs []models.Message) {
+	ids := make([]string, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	byID := attachmentsFor(AttachedToMessage, ids)
+	for i := range msgs {
+		msgs[i].Attachments = orEmpty(byID[msgs[i].ID])
+	}
+}
+
+func attachDraftAttachments(drafts []models.Draft) {
+	ids := make([]string, len(drafts))
+	for i, d := range drafts {
+		ids[i] = d.ID
+	}
+	byID := attachmentsFor(AttachedToDraft, ids)
+	for i := range drafts {
+		drafts[i].Attachments = orEmpty(byID[drafts[i].ID])
+	}
+}
+
+// migrateImageURLs turns the single image_url that posts, messages and drafts
+// had before attachments into an attachment owned by the author. Only images
+// uploaded here are kept; their size wasn't recorded, so it is left at 0.
+func migrateImageURLs() {
+	sources := []struct{ table, itemType, owner string }{
+		{"posts", AttachedToPost, "user_id"},
+		{"messages", AttachedToMessage, "sender_id"},
+		{"drafts", AttachedToDraft, "user_id"},
+	}
+	for _, src := range sources {
+		rows, err := DB.Query(`SELECT id, ` + src.owner + `, image_url FROM ` + src.table + ` WHERE image_url LIKE '/uploads/%'`)
+		if err != nil {
+			log.Fatal("failed to migrate images:", err)
+		}
+		type image struct{ itemID, userID, filename string }
+		var images []image
+		for rows.Next() {
+			var img image
+			var url string
+			if err := rows.Scan(&img.itemID, &img.userID, &url); err != nil {
+				log.Fatal("failed to migrate images:", err)
+			}
+			img.filename = path.Base(url)
+			images = append(images, img)
+		}
+		rows.Close()
+
+		for _, img := range images {
+			tx, err := DB.Begin()
+			if err != nil {
+				log.Fatal("failed to migrate images:", err)
+			}
+			_, err = tx.Exec(`
+				INSERT INTO attachments (id, user_id, filename, content_type, size, item_type, item_id)
+				VALUES (?, ?, ?, ?, 0, ?, ?)`,
+				uuid.NewString(), img.userID, img.filename, mime.TypeByExtension(path.Ext(img.filename)), src.itemType, img.itemID,
+			)
+			if err == nil {
+				_, err = tx.Exec(`UPDATE `+src.table+` SET image_url = '' WHERE id = ?`, img.itemID)
+			}
+			if err == nil {
+				err = tx.Commit()
+			}
+			if err != nil {
+				tx.Rollback()
+				log.Fatal("failed to migrate images:", err)
+			}
+		}
+		if len(images) > 0 {
+			log.Printf("moved %d %s images to attachments", len(images), src.table)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package db
+
+import "real-time-forum/models"
+
+// Kinds of things that can be bookmarked.
+const (
+	BookmarkPost    = "post"
+	BookmarkComment = "comment"
+)
+
+// BookmarkFolder is one of a user's bookmark folders and how much is in it.
+// Bookmarks outside any folder are counted under the empty name.
+type BookmarkFolder struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// SaveBookmark bookmarks a post or comment for userID, or moves an existing
+// bookmark into folder.
+func SaveBookmark(userID, targetType, targetID, folder string) error {
+	_, err := DB.Exec(`
+		INSERT INTO bookmarks (user_id, target_type, target_id, folder) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, target_type, target_id) DO UPDATE SET folder = excluded.folder`,
+		userID, targetType, targetID, folder,
+	)
+	return err
+}
+
+// DeleteBookmark removes userID's bookmark of a post or comment.
+func DeleteBookmark(userID, targetType, targetID string) error {
+	_, err := DB.Exec(
+		`DELETE FROM bookmarks WHERE user_id = ? AND target_type = ? AND target_id = ?`,
+		userID, targetType, targetID,
+	)
+	return err
+}
+
+// ListBookmarks returns userID's bookmarks, newest first, each with the post or
+// comment it points at. folder and targetType narrow the list when not empty.
+func ListBookmarks(userID, folder, targetType string) ([]models.Bookmark, error) {
+	rows, err := DB.Query(`
+		SELECT target_type, target_id, folder, created_at FROM bookmarks
+		WHERE user_id = ? AND (? = '' OR folder = ?) AND (? = '' OR target_type = ?)
+		ORDER BY created_at DESC, rowid DESC`,
+		userID, folder, folder, targetType, targetType,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookmarks := []models.Bookmark{}
+	for rows.Next() {
+		var b models.Bookmark
+		if err := rows.Scan(&b.TargetType, &b.TargetID, &b.Folder, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Only load what the list can hold
+	postsByID := map[string]*models.Post{}
+	if targetType != BookmarkComment {
+		posts, err := ListSavedPosts(userID, folder, "p.created_at DESC")
+		if err != nil {
+			return nil, err
+		}
+		for i := range posts {
+			postsByID[posts[i].ID] = &posts[i]
+		}
+	}
+	comments := map[string]*models.Comment{}
+	if targetType != BookmarkPost {
+		if comments, err = savedComments(userID, folder); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range bookmarks {
+		switch bookmarks[i].TargetType {
+		case BookmarkPost:
+			bookmarks[i].Post = postsByID[bookmarks[i].TargetID]
+		case BookmarkComment:
+			bookmarks[i].Comment = comments[bookmarks[i].TargetID]
+		}
+	}
+	return bookmarks, nil
+}
+
+// savedComments loads the comments userID bookmarked, in folder unless it is
+// empty, by ID.
+func savedComments(userID, folder string) (map[string]*models.Comment, error) {
+	rows, err := DB.Query(`
+		SELECT c.id, c.post_id, c.user_id, u.nickname, c.content, c.content_html, c.created_at
+		FROM bookmarks b
+		JOIN comments c ON c.id = b.target_id
+		JOIN users u ON u.id = c.user_id
+		WHERE b.user_id = ? AND b.target_type = 'comment' AND (? = '' OR b.folder = ?)`,
+		userID, folder, folder,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.Comment
+	for rows.Next() {
+		var c models.Comment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Nickname, &c.Content, &c.ContentHTML, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	attachCommentMentions(list)
+	attachCommentAttachments(list)
+
+	byID := make(map[string]*models.Comment, len(list))
+	for i := range list {
+		byID[list[i].ID] = &list[i]
+	}
+	return byID, nil
+}
+
+// ListBookmarkFolders returns userID's bookmark folders by name.
+func ListBookmarkFolders(userID string) ([]BookmarkFolder, error) {
+	rows, err := DB.Query(`
+		SELECT folder, COUNT(*) FROM bookmarks WHERE user_id = ?
+		GROUP BY folder ORDER BY folder COLLATE NOCASE`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []BookmarkFolder{}
+	for rows.Next() {
+		var f BookmarkFolder
+		if err := rows.Scan(&f.Name, &f.Count); err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
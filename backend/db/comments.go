@@ -1,11 +1,14 @@
 package db
 
-import "real-time-forum/models"
+import (
+	"real-time-forum/markdown"
+	"real-time-forum/models"
+)
 
 
 func ListComments(postID string) ([]models.Comment, error) {
 	rows, err := DB.Query(`
-		SELECT c.id, c.post_id, c.user_id, u.nickname, c.content, c.created_at
+		SELECT c.id, c.post_id, c.user_id, u.nickname, c.content, c.content_html, c.created_at
 		FROM comments c JOIN users u ON u.id = c.user_id
 		WHERE c.post_id = ? ORDER BY c.created_at ASC`, postID)
 	if err != nil {
@@ -16,9 +19,11 @@ func ListComments(postID string) ([]models.Comment, error) {
 	comments := []models.Comment{}
 	for rows.Next() {
 		var c models.Comment
-		rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Nickname, &c.Content, &c.CreatedAt)
+		rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Nickname, &c.Content, &c.ContentHTML, &c.CreatedAt)
 		comments = append(comments, c)
 	}
+	attachCommentMentions(comments)
+	attachCommentAttachments(comments)
 	return comments, nil
 }
 
@@ -32,8 +37,8 @@ func PostExists(postID string) bool {
 
 func CreateComment(id, postID, userID, content string) error {
 	_, err := DB.Exec(
-		`INSERT INTO comments (id, post_id, user_id, content) VALUES (?, ?, ?, ?)`,
-		id, postID, userID, content,
+		`INSERT INTO comments (id, post_id, user_id, content, content_html) VALUES (?, ?, ?, ?, ?)`,
+		id, postID, userID, content, markdown.Render(content),
 	)
 	return err
 }
@@ -42,9 +47,11 @@ func CreateComment(id, postID, userID, content string) error {
 func GetCommentByID(commentID string) (models.Comment, error) {
 	var c models.Comment
 	err := DB.QueryRow(`
-		SELECT c.id, c.post_id, c.user_id, u.nickname, c.content, c.created_at
+		SELECT c.id, c.post_id, c.user_id, u.nickname, c.content, c.content_html, c.created_at
 		FROM comments c JOIN users u ON u.id = c.user_id WHERE c.id = ?`, commentID,
-	).Scan(&c.ID, &c.PostID, &c.UserID, &c.Nickname, &c.Content, &c.CreatedAt)
+	).Scan(&c.ID, &c.PostID, &c.UserID, &c.Nickname, &c.Content, &c.ContentHTML, &c.CreatedAt)
+	c.Mentions = mentionsOf(MentionInComment, c.ID)
+	c.Attachments = attachmentsOf(AttachedToComment, c.ID)
 	return c, err
 }
 
@@ -57,6 +64,9 @@ func GetCommentOwnerID(commentID string) (string, error) {
 
 
 func DeleteComment(commentID string) error {
+	DeleteMentions(MentionInComment, commentID)
+	ReleaseAttachments(AttachedToComment, commentID)
+	DB.Exec(`DELETE FROM bookmarks WHERE target_type = 'comment' AND target_id = ?`, commentID)
 	_, err := DB.Exec(`DELETE FROM comments WHERE id = ?`, commentID)
 	return err
 }
@@ -3,6 +3,9 @@ package db
 import (
 	"database/sql"
 	"log"
+	"time"
+
+	"real-time-forum/markdown"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -20,6 +23,9 @@ func Init(path string) {
 	}
 	createTables()
 	migrate()
+	renderMissingHTML()
+	migrateImageURLs()
+	createSearchIndex()
 	log.Println("database ready")
 }
 
@@ -47,6 +53,7 @@ func createTables() {
 			user_id    TEXT NOT NULL,
 			title      TEXT NOT NULL,
 			content    TEXT NOT NULL,
+			content_html TEXT NOT NULL DEFAULT '',
 			category   TEXT NOT NULL,
 			image_url  TEXT NOT NULL DEFAULT '',
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
@@ -57,6 +64,7 @@ func createTables() {
 			post_id    TEXT NOT NULL,
 			user_id    TEXT NOT NULL,
 			content    TEXT NOT NULL,
+			content_html TEXT NOT NULL DEFAULT '',
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (post_id) REFERENCES posts(id),
 			FOREIGN KEY (user_id) REFERENCES users(id)
@@ -66,11 +74,34 @@ func createTables() {
 			sender_id   TEXT NOT NULL,
 			receiver_id TEXT NOT NULL,
 			content     TEXT NOT NULL DEFAULT '',
+			content_html TEXT NOT NULL DEFAULT '',
 			image_url   TEXT NOT NULL DEFAULT '',
 			created_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (sender_id)   REFERENCES users(id),
 			FOREIGN KEY (receiver_id) REFERENCES users(id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS message_deletions (
+			message_id TEXT NOT NULL,
+			user_id    TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (message_id, user_id),
+			FOREIGN KEY (message_id) REFERENCES messages(id),
+			FOREIGN KEY (user_id)    REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS event_seqs (
+			user_id  TEXT PRIMARY KEY,
+			last_seq INTEGER NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_events (
+			user_id    TEXT NOT NULL,
+			seq        INTEGER NOT NULL,
+			type       TEXT NOT NULL,
+			payload    TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, seq),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
 		`CREATE TABLE IF NOT EXISTS votes (
 			id      TEXT PRIMARY KEY,
 			post_id TEXT NOT NULL,
@@ -80,6 +111,149 @@ func createTables() {
 			FOREIGN KEY (post_id) REFERENCES posts(id),
 			FOREIGN KEY (user_id) REFERENCES users(id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS notifications (
+			id         TEXT PRIMARY KEY,
+			user_id    TEXT NOT NULL,
+			type       TEXT NOT NULL,
+			post_id    TEXT NOT NULL,
+			comment_id TEXT NOT NULL DEFAULT '',
+			actor_id   TEXT NOT NULL,
+			read_at    DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id)  REFERENCES users(id),
+			FOREIGN KEY (actor_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS notification_actors (
+			notification_id TEXT NOT NULL,
+			actor_id        TEXT NOT NULL,
+			PRIMARY KEY (notification_id, actor_id),
+			FOREIGN KEY (notification_id) REFERENCES notifications(id),
+			FOREIGN KEY (actor_id)        REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS mentions (
+			source_type TEXT NOT NULL,
+			source_id   TEXT NOT NULL,
+			user_id     TEXT NOT NULL,
+			start_pos   INTEGER NOT NULL,
+			end_pos     INTEGER NOT NULL,
+			PRIMARY KEY (source_type, source_id, start_pos),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS follows (
+			follower_id TEXT NOT NULL,
+			followee_id TEXT NOT NULL,
+			created_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (follower_id, followee_id),
+			FOREIGN KEY (follower_id) REFERENCES users(id),
+			FOREIGN KEY (followee_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS bookmarks (
+			user_id     TEXT NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id   TEXT NOT NULL,
+			folder      TEXT NOT NULL DEFAULT '',
+			created_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, target_type, target_id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS watches (
+			user_id    TEXT NOT NULL,
+			post_id    TEXT NOT NULL,
+			watching   INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, post_id),
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (post_id) REFERENCES posts(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS category_subscriptions (
+			user_id    TEXT NOT NULL,
+			category   TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, category),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS drafts (
+			id         TEXT PRIMARY KEY,
+			user_id    TEXT NOT NULL,
+			title      TEXT NOT NULL DEFAULT '',
+			content    TEXT NOT NULL DEFAULT '',
+			category   TEXT NOT NULL DEFAULT '',
+			image_url  TEXT NOT NULL DEFAULT '',
+			poll       TEXT NOT NULL DEFAULT '',
+			publish_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS polls (
+			post_id    TEXT PRIMARY KEY,
+			multiple   INTEGER NOT NULL DEFAULT 0,
+			anonymous  INTEGER NOT NULL DEFAULT 0,
+			closes_at  DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (post_id) REFERENCES posts(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS poll_options (
+			id       TEXT PRIMARY KEY,
+			post_id  TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			text     TEXT NOT NULL,
+			FOREIGN KEY (post_id) REFERENCES polls(post_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS poll_votes (
+			option_id  TEXT NOT NULL,
+			post_id    TEXT NOT NULL,
+			user_id    TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (option_id, user_id),
+			FOREIGN KEY (option_id) REFERENCES poll_options(id),
+			FOREIGN KEY (user_id)   REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS attachments (
+			id              TEXT PRIMARY KEY,
+			user_id         TEXT NOT NULL,
+			filename        TEXT NOT NULL,
+			content_type    TEXT NOT NULL,
+			size            INTEGER NOT NULL,
+			width           INTEGER NOT NULL DEFAULT 0,
+			height          INTEGER NOT NULL DEFAULT 0,
+			thumb_filename  TEXT NOT NULL DEFAULT '',
+			medium_filename TEXT NOT NULL DEFAULT '',
+			hash            TEXT NOT NULL DEFAULT '',
+			item_type       TEXT,
+			item_id         TEXT,
+			position        INTEGER NOT NULL DEFAULT 0,
+			alt             TEXT NOT NULL DEFAULT '',
+			created_at      DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS upload_files (
+			hash            TEXT PRIMARY KEY,
+			filename        TEXT NOT NULL,
+			thumb_filename  TEXT NOT NULL DEFAULT '',
+			medium_filename TEXT NOT NULL DEFAULT '',
+			content_type    TEXT NOT NULL,
+			size            INTEGER NOT NULL,
+			width           INTEGER NOT NULL,
+			height          INTEGER NOT NULL,
+			refs            INTEGER NOT NULL DEFAULT 0,
+			created_at      DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS upload_days (
+			user_id TEXT NOT NULL,
+			day     TEXT NOT NULL,
+			uploads INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (user_id, day),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS notification_prefs (
+			user_id TEXT NOT NULL,
+			type    TEXT NOT NULL,
+			enabled INTEGER NOT NULL,
+			PRIMARY KEY (user_id, type),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
 	}
 
 	for _, q := range queries {
@@ -94,8 +268,131 @@ func migrate() {
 		`ALTER TABLE messages ADD COLUMN image_url TEXT NOT NULL DEFAULT ''`,
 		`ALTER TABLE posts    ADD COLUMN image_url TEXT NOT NULL DEFAULT ''`,
 		`ALTER TABLE messages ADD COLUMN read INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE messages ADD COLUMN delivered_at DATETIME`,
+		`ALTER TABLE messages ADD COLUMN read_at DATETIME`,
+		`ALTER TABLE messages ADD COLUMN edited_at DATETIME`,
+		`ALTER TABLE messages ADD COLUMN unsent_at DATETIME`,
+		`ALTER TABLE messages ADD COLUMN client_id TEXT`,
+		`ALTER TABLE users ADD COLUMN status TEXT NOT NULL DEFAULT 'online'`,
+		`ALTER TABLE users ADD COLUMN status_text TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN last_seen_at DATETIME`,
+		`ALTER TABLE posts    ADD COLUMN content_html TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE comments ADD COLUMN content_html TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE messages ADD COLUMN content_html TEXT NOT NULL DEFAULT ''`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_id ON messages(sender_id, client_id) WHERE client_id IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, updated_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_follows_followee ON follows(followee_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_watches_post ON watches(post_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_category_subscriptions ON category_subscriptions(category)`,
+		`CREATE INDEX IF NOT EXISTS idx_drafts_user ON drafts(user_id, updated_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_drafts_publish_at ON drafts(publish_at) WHERE publish_at IS NOT NULL`,
+		`ALTER TABLE drafts ADD COLUMN poll TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS idx_poll_options_post ON poll_options(post_id, position)`,
+		`CREATE INDEX IF NOT EXISTS idx_poll_votes_post ON poll_votes(post_id, user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_attachments_item ON attachments(item_type, item_id, position)`,
+		`CREATE INDEX IF NOT EXISTS idx_attachments_unused ON attachments(created_at) WHERE item_id IS NULL`,
+		`ALTER TABLE attachments ADD COLUMN width  INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE attachments ADD COLUMN height INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE attachments ADD COLUMN thumb_filename  TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE attachments ADD COLUMN medium_filename TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE attachments ADD COLUMN hash TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS idx_attachments_user ON attachments(user_id)`,
+		// Authors and commenters from before watches existed watch their threads
+		`INSERT OR IGNORE INTO watches (user_id, post_id, watching) SELECT user_id, id, 1 FROM posts`,
+		`INSERT OR IGNORE INTO watches (user_id, post_id, watching) SELECT DISTINCT user_id, post_id, 1 FROM comments`,
 	}
 	for _, q := range migrations {
 		DB.Exec(q)
 	}
 }
+
+// renderMissingHTML fills in content_html for rows written before it was
+// cached, so reads never have to render Markdown. Clearing the column makes
+// the next start re-render it, e.g. after the renderer changes.
+func renderMissingHTML() {
+	for _, table := range []string{"posts", "comments", "messages"} {
+		rows, err := DB.Query(`SELECT id, content FROM ` + table + ` WHERE content_html = '' AND content <> ''`)
+		if err != nil {
+			log.Fatal("failed to render content:", err)
+		}
+		rendered := map[string]string{}
+		for rows.Next() {
+			var id, content string
+			if err := rows.Scan(&id, &content); err != nil {
+				log.Fatal("failed to render content:", err)
+			}
+			rendered[id] = markdown.Render(content)
+		}
+		rows.Close()
+
+		for id, html := range rendered {
+			DB.Exec(`UPDATE `+table+` SET content_html = ? WHERE id = ?`, html, id)
+		}
+		if len(rendered) > 0 {
+			log.Printf("rendered %d %s to HTML", len(rendered), table)
+		}
+	}
+}
+
+// createSearchIndex sets up the FTS4 index over message text and the triggers
+// that keep it in sync. FTS4 is compiled into go-sqlite3 by default; FTS5 needs
+// a build tag. The index is backfilled the first time it is created.
+func createSearchIndex() {
+	var exists int
+	DB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'messages_fts'`).Scan(&exists)
+
+	queries := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts4(content="messages", content, tokenize=unicode61)`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_ai AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts(docid, content) VALUES (new.rowid, new.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_bu BEFORE UPDATE OF content ON messages BEGIN
+			DELETE FROM messages_fts WHERE docid = old.rowid;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_au AFTER UPDATE OF content ON messages BEGIN
+			INSERT INTO messages_fts(docid, content) VALUES (new.rowid, new.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_bd BEFORE DELETE ON messages BEGIN
+			DELETE FROM messages_fts WHERE docid = old.rowid;
+		END`,
+	}
+	for _, q := range queries {
+		if _, err := DB.Exec(q); err != nil {
+			log.Fatal("failed to create search index:", err)
+		}
+	}
+	if exists == 0 {
+		if _, err := DB.Exec(`INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')`); err != nil {
+			log.Fatal("failed to build search index:", err)
+		}
+	}
+}
+
+// timeLayout matches the format SQLite uses for CURRENT_TIMESTAMP, so values
+// written from Go compare and sort the same as column defaults.
+const timeLayout = "2006-01-02 15:04:05"
+
+// now returns the current time at the column's one-second precision.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// sqlTime formats t for storage in a DATETIME column.
+func sqlTime(t time.Time) string {
+	return t.Format(timeLayout)
+}
+
+// apiTime formats t the way the driver returns DATETIME columns, so timestamps
+// in event payloads match the ones in REST responses.
+func apiTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
+// orEmpty keeps a list such as "mentions" or "attachments" a JSON array even
+// when there is nothing in it.
+func orEmpty[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"real-time-forum/models"
+)
+
+const draftSelectBase = `
+	SELECT id, user_id, title, content, category, poll, publish_at, created_at, updated_at
+	FROM drafts`
+
+func scanDraft(row interface{ Scan(...any) error }, d *models.Draft) error {
+	var poll string
+	var publishAt sql.NullString
+	err := row.Scan(&d.ID, &d.UserID, &d.Title, &d.Content, &d.Category,
+		&poll, &publishAt, &d.CreatedAt, &d.UpdatedAt)
+	d.PublishAt = publishAt.String
+	if err == nil && poll != "" {
+		err = json.Unmarshal([]byte(poll), &d.Poll)
+	}
+	return err
+}
+
+func queryDrafts(query string, args ...any) ([]models.Draft, error) {
+	rows, err := DB.Query(draftSelectBase+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := []models.Draft{}
+	for rows.Next() {
+		var d models.Draft
+		if err := scanDraft(rows, &d); err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	attachDraftAttachments(drafts)
+	return drafts, nil
+}
+
+// CreateDraft starts a new draft d.
+func CreateDraft(d models.Draft) error {
+	_, err := DB.Exec(
+		`INSERT INTO drafts (id, user_id, title, content, category, poll) VALUES (?, ?, ?, ?, ?, ?)`,
+		d.ID, d.UserID, d.Title, d.Content, d.Category, draftPoll(d),
+	)
+	return err
+}
+
+// SaveDraft overwrites the text, categories and poll of d.UserID's draft
+// d.ID. It leaves any schedule and attachments alone, and reports whether the
+// draft exists.
+func SaveDraft(d models.Draft) (bool, error) {
+	return updateDraft(`
+		UPDATE drafts SET title = ?, content = ?, category = ?, poll = ?, updated_at = ?
+		WHERE id = ? AND user_id = ?`,
+		d.Title, d.Content, d.Category, draftPoll(d), sqlTime(now()), d.ID, d.UserID,
+	)
+}
+
+func draftPoll(d models.Draft) string {
+	if d.Poll == nil {
+		return ""
+	}
+	poll, _ := json.Marshal(d.Poll)
+	return string(poll)
+}
+
+// GetDraft returns userID's draft id, or sql.ErrNoRows.
+func GetDraft(userID, id string) (models.Draft, error) {
+	var d models.Draft
+	err := scanDraft(DB.QueryRow(draftSelectBase+` WHERE id = ? AND user_id = ?`, id, userID), &d)
+	d.Attachments = attachmentsOf(AttachedToDraft, d.ID)
+	return d, err
+}
+
+// ListDrafts returns all of userID's drafts, scheduled or not, most recently
+// edited first.
+func ListDrafts(userID string) ([]models.Draft, error) {
+	return queryDrafts(` WHERE user_id = ? ORDER BY updated_at DESC, rowid DESC`, userID)
+}
+
+// ListScheduledDrafts returns userID's scheduled drafts, soonest first.
+func ListScheduledDrafts(userID string) ([]models.Draft, error) {
+	return queryDrafts(` WHERE user_id = ? AND publish_at IS NOT NULL ORDER BY publish_at`, userID)
+}
+
+// ScheduleDraft sets when userID's draft id is published. It reports whether
+// the draft exists.
+func ScheduleDraft(userID, id string, publishAt time.Time) (bool, error) {
+	return updateDraft(`UPDATE drafts SET publish_at = ? WHERE id = ? AND user_id = ?`,
+		sqlTime(publishAt.UTC()), id, userID)
+}
+
+// UnscheduleDraft turns userID's scheduled draft id back into a plain draft.
+// It reports whether there was such a scheduled draft.
+func UnscheduleDraft(userID, id string) (bool, error) {
+	return updateDraft(`UPDATE drafts SET publish_at = NULL WHERE id = ? AND user_id = ? AND publish_at IS NOT NULL`,
+		id, userID)
+}
+
+// DeleteDraft deletes userID's draft id and lets go of the attachments it
+// still has. It reports whether there was one.
+func DeleteDraft(userID, id string) (bool, error) {
+	found, err := updateDraft(`DELETE FROM drafts WHERE id = ? AND user_id = ?`, id, userID)
+	if found {
+		ReleaseAttachments(AttachedToDraft, id)
+	}
+	return found, err
+}
+
+func updateDraft(query string, args ...any) (bool, error) {
+	res, err := DB.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// DueDrafts returns every scheduled draft whose time has come, oldest first.
+func DueDrafts() ([]models.Draft, error) {
+	return queryDrafts(` WHERE publish_at <= ? ORDER BY publish_at`, sqlTime(now()))
+}
+
+// NextPublishAt returns when the next scheduled draft is due, and false when
+// nothing is scheduled.
+func NextPublishAt() (time.Time, bool) {
+	var next sql.NullString
+	DB.QueryRow(`SELECT MIN(publish_at) FROM drafts`).Scan(&next)
+	if !next.Valid {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(timeLayout, next.String)
+	return t, err == nil
+}
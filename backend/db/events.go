@@ -0,0 +1,89 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"real-time-forum/models"
+)
+
+// EventLogSize is how many events are kept per user for resuming dropped connections.
+const EventLogSize = 1000
+
+// AppendEvent stores an event in userID's log under the next sequence number and
+// returns that number. Sequence numbers never repeat, even after old events are pruned.
+func AppendEvent(userID, eventType string, payload []byte) (int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var seq int64
+	err = tx.QueryRow(`
+		INSERT INTO event_seqs (user_id, last_seq) VALUES (?, 1)
+		ON CONFLICT(user_id) DO UPDATE SET last_seq = last_seq + 1
+		RETURNING last_seq`, userID,
+	).Scan(&seq)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO user_events (user_id, seq, type, payload) VALUES (?, ?, ?, ?)`,
+		userID, seq, eventType, string(payload),
+	); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(
+		`DELETE FROM user_events WHERE user_id = ? AND seq <= ?`, userID, seq-EventLogSize,
+	); err != nil {
+		return 0, err
+	}
+	return seq, tx.Commit()
+}
+
+// LastEventSeq returns the sequence number of the newest event for userID, or 0.
+func LastEventSeq(userID string) int64 {
+	var seq int64
+	DB.QueryRow(`SELECT last_seq FROM event_seqs WHERE user_id = ?`, userID).Scan(&seq)
+	return seq
+}
+
+// EventsSince returns userID's events after seq, oldest first. complete is false
+// when some of those events have already been pruned from the log.
+func EventsSince(userID string, seq int64) (events []models.Event, complete bool, err error) {
+	var oldest sql.NullInt64
+	err = DB.QueryRow(`SELECT MIN(seq) FROM user_events WHERE user_id = ?`, userID).Scan(&oldest)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+	last := LastEventSeq(userID)
+	switch {
+	case seq >= last:
+		complete = true
+	case oldest.Valid:
+		complete = oldest.Int64 <= seq+1
+	}
+
+	rows, err := DB.Query(
+		`SELECT seq, type, payload FROM user_events WHERE user_id = ? AND seq > ? ORDER BY seq ASC`,
+		userID, seq,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	events = []models.Event{}
+	for rows.Next() {
+		var e models.Event
+		var payload string
+		if err := rows.Scan(&e.Seq, &e.Type, &payload); err != nil {
+			return nil, false, err
+		}
+		e.Payload = []byte(payload)
+		events = append(events, e)
+	}
+	return events, complete, rows.Err()
+}
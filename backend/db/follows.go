@@ -0,0 +1,81 @@
+package db
+
+// Profile is a user as shown on their profile, from viewerID's point of view.
+type Profile struct {
+	ID          string `json:"id"`
+	Nickname    string `json:"nickname"`
+	Followers   int    `json:"followers"`
+	Following   int    `json:"following"`
+	IsFollowing bool   `json:"is_following"` // the viewer follows them
+	FollowsYou  bool   `json:"follows_you"`
+}
+
+// Follow makes followerID follow followeeID. Following someone twice is a no-op.
+func Follow(followerID, followeeID string) error {
+	_, err := DB.Exec(
+		`INSERT OR IGNORE INTO follows (follower_id, followee_id) VALUES (?, ?)`, followerID, followeeID,
+	)
+	return err
+}
+
+// Unfollow stops followerID following followeeID.
+func Unfollow(followerID, followeeID string) error {
+	_, err := DB.Exec(`DELETE FROM follows WHERE follower_id = ? AND followee_id = ?`, followerID, followeeID)
+	return err
+}
+
+// GetProfile returns userID's profile with their follower counts as seen by
+// viewerID. It fails with sql.ErrNoRows for an unknown user.
+func GetProfile(viewerID, userID string) (Profile, error) {
+	var p Profile
+	err := DB.QueryRow(`
+		SELECT u.id, u.nickname,
+		       (SELECT COUNT(*) FROM follows WHERE followee_id = u.id),
+		       (SELECT COUNT(*) FROM follows WHERE follower_id = u.id),
+		       EXISTS (SELECT 1 FROM follows WHERE follower_id = ? AND followee_id = u.id),
+		       EXISTS (SELECT 1 FROM follows WHERE follower_id = u.id AND followee_id = ?)
+		FROM users u WHERE u.id = ?`, viewerID, viewerID, userID,
+	).Scan(&p.ID, &p.Nickname, &p.Followers, &p.Following, &p.IsFollowing, &p.FollowsYou)
+	return p, err
+}
+
+// ListFollowing returns the users followerID follows, by nickname.
+func ListFollowing(followerID string) ([]UserRef, error) {
+	rows, err := DB.Query(`
+		SELECT u.id, u.nickname FROM follows f JOIN users u ON u.id = f.followee_id
+		WHERE f.follower_id = ? ORDER BY u.nickname COLLATE NOCASE`, followerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []UserRef{}
+	for rows.Next() {
+		var u UserRef
+		if err := rows.Scan(&u.ID, &u.Nickname); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// ListFollowerIDs returns everyone who follows userID.
+func ListFollowerIDs(userID string) ([]string, error) {
+	rows, err := DB.Query(`SELECT follower_id FROM follows WHERE followee_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
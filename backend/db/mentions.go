@@ -0,0 +1,169 @@
+package db
+
+import (
+	"strings"
+
+	"real-time-forum/models"
+)
+
+// Kinds of content a mention can appear in.
+const (
+	MentionInPost    = "post"
+	MentionInComment = "comment"
+	MentionInMessage = "message"
+)
+
+// UsersByNicknames maps each of the given nicknames, lowercased, to the user who
+// has it. Nicknames are matched case-insensitively; unknown ones are left out.
+func UsersByNicknames(nicknames []string) (map[string]UserRef, error) {
+	users := map[string]UserRef{}
+	if len(nicknames) == 0 {
+		return users, nil
+	}
+	args := make([]any, len(nicknames))
+	for i, n := range nicknames {
+		args[i] = n
+	}
+	rows, err := DB.Query(
+		`SELECT id, nickname FROM users WHERE nickname COLLATE NOCASE IN (?`+strings.Repeat(", ?", len(nicknames)-1)+`)`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u UserRef
+		if err := rows.Scan(&u.ID, &u.Nickname); err != nil {
+			return nil, err
+		}
+		users[strings.ToLower(u.Nickname)] = u
+	}
+	return users, rows.Err()
+}
+
+// SearchNicknames returns up to limit users whose nickname starts with prefix,
+// ignoring case, leaving out excludeID.
+func SearchNicknames(prefix, excludeID string, limit int) ([]UserRef, error) {
+	// Escape LIKE wildcards so they match literally
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	rows, err := DB.Query(`
+		SELECT id, nickname FROM users
+		WHERE nickname LIKE ? ESCAPE '\' AND id != ?
+		ORDER BY LENGTH(nickname), nickname COLLATE NOCASE
+		LIMIT ?`, escaped+"%", excludeID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []UserRef{}
+	for rows.Next() {
+		var m UserRef
+		if err := rows.Scan(&m.ID, &m.Nickname); err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// SaveMentions replaces the mentions stored for a post, comment or message.
+func SaveMentions(kind, sourceID string, mentions []models.Mention) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM mentions WHERE source_type = ? AND source_id = ?`, kind, sourceID); err != nil {
+		return err
+	}
+	for _, m := range mentions {
+		if _, err := tx.Exec(`
+			INSERT INTO mentions (source_type, source_id, user_id, start_pos, end_pos)
+			VALUES (?, ?, ?, ?, ?)`, kind, sourceID, m.UserID, m.Start, m.End,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteMentions drops the mentions stored for a post, comment or message.
+func DeleteMentions(kind, sourceID string) error {
+	_, err := DB.Exec(`DELETE FROM mentions WHERE source_type = ? AND source_id = ?`, kind, sourceID)
+	return err
+}
+
+// mentionsFor loads the mentions in each of the given posts, comments or
+// messages, in the order they appear.
+func mentionsFor(kind string, ids []string) map[string][]models.Mention {
+	byID := map[string][]models.Mention{}
+	if len(ids) == 0 {
+		return byID
+	}
+	args := []any{kind}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := DB.Query(`
+		SELECT m.source_id, m.user_id, u.nickname, m.start_pos, m.end_pos
+		FROM mentions m JOIN users u ON u.id = m.user_id
+		WHERE m.source_type = ? AND m.source_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+		ORDER BY m.start_pos`, args...,
+	)
+	if err != nil {
+		return byID
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var m models.Mention
+		if err := rows.Scan(&id, &m.UserID, &m.Nickname, &m.Start, &m.End); err != nil {
+			return byID
+		}
+		byID[id] = append(byID[id], m)
+	}
+	return byID
+}
+
+// mentionsOf loads the mentions in a single post, comment or message.
+func mentionsOf(kind, id string) []models.Mention {
+	return orEmpty(mentionsFor(kind, []string{id})[id])
+}
+
+func attachPostMentions(posts []models.Post) {
+	ids := make([]string, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	byID := mentionsFor(MentionInPost, ids)
+	for i := range posts {
+		posts[i].Mentions = orEmpty(byID[posts[i].ID])
+	}
+}
+
+func attachCommentMentions(comments []models.Comment) {
+	ids := make([]string, len(comments))
+	for i, c := range comments {
+		ids[i] = c.ID
+	}
+	byID := mentionsFor(MentionInComment, ids)
+	for i := range comments {
+		comments[i].Mentions = orEmpty(byID[comments[i].ID])
+	}
+}
+
+func attachMessageMentions(msgs []models.Message) {
+	ids := make([]string, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	byID := mentionsFor(MentionInMessage, ids)
+	for i := range msgs {
+		msgs[i].Mentions = orEmpty(byID[msgs[i].ID])
+	}
+}
@@ -1,86 +1,219 @@
 package db
 
-import "real-time-forum/models"
-
-// GetMessages returns up to limit messages between two users (newest-first from DB,
-// reversed to chronological order before returning).
-func GetMessages(myID, withID string, limit, offset int) ([]models.Message, error) {
-	rows, err := DB.Query(`
-		SELECT m.id, m.sender_id, m.receiver_id, u.nickname, m.content, m.image_url, m.created_at
-		FROM messages m
-		JOIN users u ON u.id = m.sender_id
-		WHERE (m.sender_id = ? AND m.receiver_id = ?)
-		   OR (m.sender_id = ? AND m.receiver_id = ?)
-		ORDER BY m.created_at DESC
-		LIMIT ? OFFSET ?`,
-		myID, withID, withID, myID, limit, offset,
-	)
-	if err != nil {
-		return nil, err
+import (
+	"database/sql"
+
+	"real-time-forum/markdown"
+	"real-time-forum/models"
+)
+
+const messageSelectBase = `
+	SELECT m.id, m.sender_id, m.receiver_id, u.nickname, m.content, m.content_html, m.created_at,
+	       m.delivered_at, m.read_at, m.edited_at, m.unsent_at IS NOT NULL
+	FROM messages m
+	JOIN users u ON u.id = m.sender_id`
+
+func scanMessage(row interface{ Scan(...any) error }, m *models.Message) error {
+	var deliveredAt, readAt, editedAt sql.NullString
+	err := row.Scan(&m.ID, &m.SenderID, &m.ReceiverID, &m.SenderName, &m.Content, &m.ContentHTML, &m.CreatedAt,
+		&deliveredAt, &readAt, &editedAt, &m.Unsent)
+	m.DeliveredAt = deliveredAt.String
+	m.ReadAt = readAt.String
+	m.EditedAt = editedAt.String
+	m.Edited = editedAt.Valid
+	return err
+}
+
+// GetMessages returns up to limit messages between two users in chronological
+// order. With no cursor it returns the newest page; before/after are message IDs
+// and return the page immediately older/newer than that message. Messages are
+// ordered by created_at with rowid as a tie-breaker, so rows sharing the same
+// second always come back in the same order. hasMore reports whether another
+// page exists in the same direction. Messages myID deleted for themselves are
+// left out. An unknown cursor fails with sql.ErrNoRows.
+func GetMessages(myID, withID, before, after string, limit int) (msgs []models.Message, hasMore bool, err error) {
+	cursor := before
+	if after != "" {
+		cursor = after
+	}
+	if cursor != "" {
+		m, err := scanVisibleMessage(myID, cursor)
+		if err != nil {
+			return nil, false, err
+		}
+		if m.SenderID != withID && m.ReceiverID != withID {
+			return nil, false, sql.ErrNoRows
+		}
 	}
-	defer rows.Close()
 
-	msgs := []models.Message{}
-	for rows.Next() {
-		var m models.Message
-		rows.Scan(&m.ID, &m.SenderID, &m.ReceiverID, &m.SenderName, &m.Content, &m.ImageURL, &m.CreatedAt)
-		msgs = append(msgs, m)
+	query := messageSelectBase + `
+		WHERE ((m.sender_id = ? AND m.receiver_id = ?)
+		    OR (m.sender_id = ? AND m.receiver_id = ?))
+		  AND NOT EXISTS (SELECT 1 FROM message_deletions d WHERE d.message_id = m.id AND d.user_id = ?)`
+	args := []any{myID, withID, withID, myID, myID}
+
+	switch {
+	case after != "":
+		query += ` AND (m.created_at, m.rowid) > (SELECT created_at, rowid FROM messages WHERE id = ?)
+		ORDER BY m.created_at ASC, m.rowid ASC`
+		args = append(args, after)
+	case before != "":
+		query += ` AND (m.created_at, m.rowid) < (SELECT created_at, rowid FROM messages WHERE id = ?)
+		ORDER BY m.created_at DESC, m.rowid DESC`
+		args = append(args, before)
+	default:
+		query += ` ORDER BY m.created_at DESC, m.rowid DESC`
 	}
 
-	// reverse to chronological order
-	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
-		msgs[i], msgs[j] = msgs[j], msgs[i]
+	// Fetch one extra row to learn whether there is another page
+	msgs, err = queryMessages(query+` LIMIT ?`, append(args, limit+1)...)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(msgs) > limit {
+		msgs, hasMore = msgs[:limit], true
 	}
-	return msgs, nil
+
+	if after == "" {
+		// reverse to chronological order
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	}
+	return msgs, hasMore, nil
 }
 
-// CreateMessage inserts a new private message.
-func CreateMessage(id, senderID, receiverID, content, imageURL string) error {
+// CreateMessage inserts a new private message. clientID is the sender's
+// idempotency key; pass "" when the client didn't supply one.
+func CreateMessage(id, senderID, receiverID, content, clientID string) error {
 	_, err := DB.Exec(
-		`INSERT INTO messages (id, sender_id, receiver_id, content, image_url) VALUES (?, ?, ?, ?, ?)`,
-		id, senderID, receiverID, content, imageURL,
+		`INSERT INTO messages (id, sender_id, receiver_id, content, content_html, client_id) VALUES (?, ?, ?, ?, ?, NULLIF(?, ''))`,
+		id, senderID, receiverID, content, markdown.Render(content), clientID,
 	)
 	return err
 }
 
+// GetMessageByClientID finds the message senderID already stored under clientID,
+// so a retried send can be answered without inserting a duplicate.
+func GetMessageByClientID(senderID, clientID string) (models.Message, error) {
+	var m models.Message
+	err := scanMessage(DB.QueryRow(messageSelectBase+` WHERE m.sender_id = ? AND m.client_id = ?`, senderID, clientID), &m)
+	m.Mentions = mentionsOf(MentionInMessage, m.ID)
+	m.Attachments = attachmentsOf(AttachedToMessage, m.ID)
+	return m, err
+}
+
 // GetMessageByID fetches a single message with its sender's nickname.
 func GetMessageByID(msgID string) (models.Message, error) {
 	var m models.Message
-	err := DB.QueryRow(`
-		SELECT m.id, m.sender_id, m.receiver_id, u.nickname, m.content, m.image_url, m.created_at
-		FROM messages m JOIN users u ON u.id = m.sender_id
-		WHERE m.id = ?`, msgID,
-	).Scan(&m.ID, &m.SenderID, &m.ReceiverID, &m.SenderName, &m.Content, &m.ImageURL, &m.CreatedAt)
+	err := scanMessage(DB.QueryRow(messageSelectBase+` WHERE m.id = ?`, msgID), &m)
+	m.Mentions = mentionsOf(MentionInMessage, m.ID)
+	m.Attachments = attachmentsOf(AttachedToMessage, m.ID)
 	return m, err
 }
 
-// GetUnreadCount returns the number of unread messages sent by senderID to receiverID.
-func GetUnreadCount(receiverID, senderID string) int {
-	var count int
-	DB.QueryRow(
-		`SELECT COUNT(*) FROM messages WHERE receiver_id = ? AND sender_id = ? AND read = 0`,
-		receiverID, senderID,
-	).Scan(&count)
-	return count
+// UpdateMessageContent replaces the text of a message and stamps edited_at.
+func UpdateMessageContent(msgID, content string) error {
+	_, err := DB.Exec(
+		`UPDATE messages SET content = ?, content_html = ?, edited_at = ? WHERE id = ? AND unsent_at IS NULL`,
+		content, markdown.Render(content), sqlTime(now()), msgID,
+	)
+	return err
+}
+
+// DeleteMessage removes a message that was never delivered, when sending it
+// failed halfway.
+func DeleteMessage(msgID string) error {
+	DeleteMentions(MentionInMessage, msgID)
+	_, err := DB.Exec(`DELETE FROM messages WHERE id = ?`, msgID)
+	return err
+}
+
+// UnsendMessage blanks a message for both participants, leaving a tombstone row
+// so the conversation still shows where it was.
+func UnsendMessage(msgID string) error {
+	DeleteMentions(MentionInMessage, msgID)
+	ReleaseAttachments(AttachedToMessage, msgID)
+	_, err := DB.Exec(
+		`UPDATE messages SET content = '', content_html = '', unsent_at = ? WHERE id = ?`,
+		sqlTime(now()), msgID,
+	)
+	return err
+}
+
+// DeleteMessageForUser hides a message from userID's view of the conversation only.
+func DeleteMessageForUser(msgID, userID string) error {
+	_, err := DB.Exec(
+		`INSERT OR IGNORE INTO message_deletions (message_id, user_id) VALUES (?, ?)`,
+		msgID, userID,
+	)
+	return err
 }
 
-// MarkMessagesRead marks all messages from senderID to receiverID as read.
-func MarkMessagesRead(receiverID, senderID string) {
-	DB.Exec(
-		`UPDATE messages SET read = 1 WHERE receiver_id = ? AND sender_id = ? AND read = 0`,
-		receiverID, senderID,
+// MarkMessagesRead marks all messages from senderID to receiverID as read and
+// returns the IDs that changed together with the read timestamp.
+func MarkMessagesRead(receiverID, senderID string) ([]string, string, error) {
+	readAt := now()
+	ids, err := updateReturningIDs(
+		`UPDATE messages SET read = 1, read_at = ?,
+		        delivered_at = COALESCE(delivered_at, ?)
+		 WHERE receiver_id = ? AND sender_id = ? AND read = 0
+		 RETURNING id`,
+		sqlTime(readAt), sqlTime(readAt), receiverID, senderID,
 	)
+	return ids, apiTime(readAt), err
 }
 
-// GetLastMessageTimeBetween returns the created_at of the most recent message between
-// two users. Returns "" if no messages exist.
-func GetLastMessageTimeBetween(userID1, userID2 string) string {
-	var lastMsg string
-	DB.QueryRow(`
-		SELECT created_at FROM messages
-		WHERE (sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)
-		ORDER BY created_at DESC LIMIT 1`,
-		userID1, userID2, userID2, userID1,
-	).Scan(&lastMsg)
-	return lastMsg
+// MarkMessageDelivered stamps delivered_at on a single message if it isn't set yet.
+func MarkMessageDelivered(msgID string) error {
+	_, err := DB.Exec(
+		`UPDATE messages SET delivered_at = ? WHERE id = ? AND delivered_at IS NULL`,
+		sqlTime(now()), msgID,
+	)
+	return err
+}
+
+// MarkPendingDelivered stamps delivered_at on every message waiting for receiverID
+// and returns the affected message IDs grouped by sender.
+func MarkPendingDelivered(receiverID string) (map[string][]string, string, error) {
+	deliveredAt := now()
+	rows, err := DB.Query(
+		`UPDATE messages SET delivered_at = ?
+		 WHERE receiver_id = ? AND delivered_at IS NULL
+		 RETURNING id, sender_id`,
+		sqlTime(deliveredAt), receiverID,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	bySender := map[string][]string{}
+	for rows.Next() {
+		var id, senderID string
+		if err := rows.Scan(&id, &senderID); err != nil {
+			return nil, "", err
+		}
+		bySender[senderID] = append(bySender[senderID], id)
+	}
+	return bySender, apiTime(deliveredAt), rows.Err()
+}
+
+// updateReturningIDs runs an UPDATE ... RETURNING id statement and collects the IDs.
+func updateReturningIDs(query string, args ...any) ([]string, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
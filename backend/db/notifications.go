@@ -0,0 +1,193 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"real-time-forum/models"
+)
+
+const notificationSelectBase = `
+	SELECT n.id, n.type, n.post_id, COALESCE(p.title, ''), n.comment_id, n.actor_id, u.nickname,
+	       (SELECT COUNT(*) FROM notification_actors a WHERE a.notification_id = n.id),
+	       n.read_at IS NOT NULL, n.created_at, n.updated_at
+	FROM notifications n
+	JOIN users u ON u.id = n.actor_id
+	LEFT JOIN posts p ON p.id = n.post_id`
+
+func scanNotification(row interface{ Scan(...any) error }, n *models.Notification) error {
+	return row.Scan(&n.ID, &n.Type, &n.PostID, &n.PostTitle, &n.CommentID, &n.ActorID, &n.ActorName,
+		&n.ActorCount, &n.Read, &n.CreatedAt, &n.UpdatedAt)
+}
+
+// AddNotification records that actorID did something of type typ on postID for
+// userID. It is folded into userID's unread notification of the same type on
+// the same post when there is one, and stored under id otherwise. It returns
+// the notification's ID and whether anything changed: an actor who was already
+// counted acting again without a new comment (voting twice) changes nothing.
+func AddNotification(id, userID, typ, postID, commentID, actorID string) (string, bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	at := sqlTime(now())
+	var existing string
+	err = tx.QueryRow(`
+		SELECT id FROM notifications
+		WHERE user_id = ? AND type = ? AND post_id = ? AND read_at IS NULL
+		ORDER BY updated_at DESC LIMIT 1`, userID, typ, postID,
+	).Scan(&existing)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if _, err := tx.Exec(`
+			INSERT INTO notifications (id, user_id, type, post_id, comment_id, actor_id, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, userID, typ, postID, commentID, actorID, at, at,
+		); err != nil {
+			return "", false, err
+		}
+	case err != nil:
+		return "", false, err
+	default:
+		id = existing
+		var counted int
+		tx.QueryRow(
+			`SELECT COUNT(*) FROM notification_actors WHERE notification_id = ? AND actor_id = ?`, id, actorID,
+		).Scan(&counted)
+		if counted > 0 && commentID == "" {
+			return id, false, nil
+		}
+		if _, err := tx.Exec(
+			`UPDATE notifications SET comment_id = ?, actor_id = ?, updated_at = ? WHERE id = ?`,
+			commentID, actorID, at, id,
+		); err != nil {
+			return "", false, err
+		}
+	}
+
+	if _, err := tx.Exec(
+		`INSERT OR IGNORE INTO notification_actors (notification_id, actor_id) VALUES (?, ?)`, id, actorID,
+	); err != nil {
+		return "", false, err
+	}
+	return id, true, tx.Commit()
+}
+
+// GetNotification returns one of userID's notifications.
+func GetNotification(userID, id string) (models.Notification, error) {
+	var n models.Notification
+	err := scanNotification(DB.QueryRow(notificationSelectBase+` WHERE n.id = ? AND n.user_id = ?`, id, userID), &n)
+	return n, err
+}
+
+// ListNotifications returns up to limit of userID's notifications, most recently
+// updated first. before is a notification ID and returns the page after it;
+// hasMore reports whether there is another. An unknown cursor fails with
+// sql.ErrNoRows.
+func ListNotifications(userID, before string, limit int) (list []models.Notification, hasMore bool, err error) {
+	query := notificationSelectBase + ` WHERE n.user_id = ?`
+	args := []any{userID}
+	if before != "" {
+		if _, err := GetNotification(userID, before); err != nil {
+			return nil, false, err
+		}
+		query += ` AND (n.updated_at, n.rowid) < (SELECT updated_at, rowid FROM notifications WHERE id = ?)`
+		args = append(args, before)
+	}
+	query += ` ORDER BY n.updated_at DESC, n.rowid DESC LIMIT ?`
+
+	rows, err := DB.Query(query, append(args, limit+1)...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	list = []models.Notification{}
+	for rows.Next() {
+		var n models.Notification
+		if err := scanNotification(rows, &n); err != nil {
+			return nil, false, err
+		}
+		list = append(list, n)
+	}
+	if len(list) > limit {
+		list, hasMore = list[:limit], true
+	}
+	return list, hasMore, rows.Err()
+}
+
+// CountUnreadNotifications returns how many of userID's notifications are unread.
+func CountUnreadNotifications(userID string) int {
+	var n int
+	DB.QueryRow(`SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL`, userID).Scan(&n)
+	return n
+}
+
+// MarkNotificationsRead marks the given notifications of userID's as read.
+// IDs that aren't theirs are ignored.
+func MarkNotificationsRead(userID string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []any{sqlTime(now()), userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := DB.Exec(`
+		UPDATE notifications SET read_at = ?
+		WHERE user_id = ? AND read_at IS NULL
+		  AND id IN (?`+strings.Repeat(`, ?`, len(ids)-1)+`)`, args...)
+	return err
+}
+
+// MarkAllNotificationsRead marks every notification of userID's as read.
+func MarkAllNotificationsRead(userID string) error {
+	_, err := DB.Exec(
+		`UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL`,
+		sqlTime(now()), userID,
+	)
+	return err
+}
+
+// NotificationPrefs returns the notification types userID has switched on or
+// off. Types they never touched are missing and default to on.
+func NotificationPrefs(userID string) (map[string]bool, error) {
+	rows, err := DB.Query(`SELECT type, enabled FROM notification_prefs WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prefs := map[string]bool{}
+	for rows.Next() {
+		var typ string
+		var enabled bool
+		if err := rows.Scan(&typ, &enabled); err != nil {
+			return nil, err
+		}
+		prefs[typ] = enabled
+	}
+	return prefs, rows.Err()
+}
+
+// NotificationEnabled reports whether userID wants notifications of type typ.
+func NotificationEnabled(userID, typ string) bool {
+	enabled := true
+	DB.QueryRow(
+		`SELECT enabled FROM notification_prefs WHERE user_id = ? AND type = ?`, userID, typ,
+	).Scan(&enabled)
+	return enabled
+}
+
+// SetNotificationPref switches notifications of type typ on or off for userID.
+func SetNotificationPref(userID, typ string, enabled bool) error {
+	_, err := DB.Exec(`
+		INSERT INTO notification_prefs (user_id, type, enabled) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, type) DO UPDATE SET enabled = excluded.enabled`,
+		userID, typ, enabled,
+	)
+	return err
+}
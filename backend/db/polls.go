@@ -0,0 +1,185 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"real-time-forum/models"
+)
+
+// CreatePoll attaches a poll with options, in order, to postID. A zero
+// closesAt leaves the poll open for good.
+func CreatePoll(postID string, options []models.PollOption, multiple, anonymous bool, closesAt time.Time) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var closes any
+	if !closesAt.IsZero() {
+		closes = sqlTime(closesAt.UTC())
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO polls (post_id, multiple, anonymous, closes_at) VALUES (?, ?, ?, ?)`,
+		postID, multiple, anonymous, closes,
+	); err != nil {
+		return err
+	}
+	for i, o := range options {
+		if _, err := tx.Exec(
+			`INSERT INTO poll_options (id, post_id, position, text) VALUES (?, ?, ?, ?)`,
+			o.ID, postID, i, o.Text,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetPoll returns the poll on postID as viewerID sees it, or sql.ErrNoRows.
+func GetPoll(viewerID, postID string) (models.Poll, error) {
+	poll, ok := pollsFor(viewerID, []string{postID})[postID]
+	if !ok {
+		return models.Poll{}, sql.ErrNoRows
+	}
+	return *poll, nil
+}
+
+// SetPollVotes replaces userID's votes on postID's poll with optionIDs, which
+// may be empty to take the vote back. The options must belong to the poll.
+func SetPollVotes(postID, userID string, optionIDs []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM poll_votes WHERE post_id = ? AND user_id = ?`, postID, userID); err != nil {
+		return err
+	}
+	for _, id := range optionIDs {
+		if _, err := tx.Exec(
+			`INSERT INTO poll_votes (option_id, post_id, user_id) VALUES (?, ?, ?)`, id, postID, userID,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deletePoll removes postID's poll and every vote on it.
+func deletePoll(postID string) {
+	DB.Exec(`DELETE FROM poll_votes   WHERE post_id = ?`, postID)
+	DB.Exec(`DELETE FROM poll_options WHERE post_id = ?`, postID)
+	DB.Exec(`DELETE FROM polls        WHERE post_id = ?`, postID)
+}
+
+func attachPolls(viewerID string, posts []models.Post) {
+	ids := make([]string, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	byID := pollsFor(viewerID, ids)
+	for i := range posts {
+		posts[i].Poll = byID[posts[i].ID]
+	}
+}
+
+// pollsFor loads the polls on postIDs with their results, keyed by post.
+// Posts without a poll are missing from the map.
+func pollsFor(viewerID string, postIDs []string) map[string]*models.Poll {
+	byID := map[string]*models.Poll{}
+	if len(postIDs) == 0 {
+		return byID
+	}
+	in := `(?` + strings.Repeat(", ?", len(postIDs)-1) + `)`
+	args := make([]any, len(postIDs))
+	for i, id := range postIDs {
+		args[i] = id
+	}
+
+	rows, err := DB.Query(`
+		SELECT post_id, multiple, anonymous, closes_at, closes_at IS NOT NULL AND closes_at <= ?,
+		       (SELECT COUNT(DISTINCT user_id) FROM poll_votes v WHERE v.post_id = polls.post_id)
+		FROM polls WHERE post_id IN `+in, append([]any{sqlTime(now())}, args...)...,
+	)
+	if err != nil {
+		return byID
+	}
+	for rows.Next() {
+		p := &models.Poll{Options: []models.PollOption{}}
+		var closesAt sql.NullString
+		if err := rows.Scan(&p.PostID, &p.Multiple, &p.Anonymous, &closesAt, &p.Closed, &p.TotalVoters); err != nil {
+			rows.Close()
+			return byID
+		}
+		p.ClosesAt = closesAt.String
+		byID[p.PostID] = p
+	}
+	rows.Close()
+	if len(byID) == 0 {
+		return byID
+	}
+
+	// Options in order, with their vote counts
+	optionIndex := map[string]*models.PollOption{}
+	rows, err = DB.Query(`
+		SELECT o.post_id, o.id, o.text, COUNT(v.user_id)
+		FROM poll_options o LEFT JOIN poll_votes v ON v.option_id = o.id
+		WHERE o.post_id IN `+in+`
+		GROUP BY o.id ORDER BY o.post_id, o.position`, args...,
+	)
+	if err != nil {
+		return byID
+	}
+	for rows.Next() {
+		var postID string
+		var o models.PollOption
+		if err := rows.Scan(&postID, &o.ID, &o.Text, &o.Votes); err != nil {
+			break
+		}
+		if p := byID[postID]; p != nil {
+			p.Options = append(p.Options, o)
+		}
+	}
+	rows.Close()
+	for _, p := range byID {
+		for i := range p.Options {
+			optionIndex[p.Options[i].ID] = &p.Options[i]
+		}
+	}
+
+	// The viewer's own votes, and who voted for what on public polls
+	rows, err = DB.Query(`
+		SELECT v.post_id, v.option_id, v.user_id, u.nickname
+		FROM poll_votes v
+		JOIN polls p ON p.post_id = v.post_id
+		JOIN users u ON u.id = v.user_id
+		WHERE v.post_id IN `+in+` AND (p.anonymous = 0 OR v.user_id = ?)
+		ORDER BY v.created_at, u.nickname`, append(args, viewerID)...,
+	)
+	if err != nil {
+		return byID
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postID, optionID string
+		var voter models.PollVoter
+		if err := rows.Scan(&postID, &optionID, &voter.ID, &voter.Nickname); err != nil {
+			break
+		}
+		p, o := byID[postID], optionIndex[optionID]
+		if p == nil || o == nil {
+			continue
+		}
+		if voter.ID == viewerID {
+			p.MyVotes = append(p.MyVotes, optionID)
+		}
+		if !p.Anonymous {
+			o.Voters = append(o.Voters, voter)
+		}
+	}
+	return byID
+}
@@ -4,28 +4,39 @@ import (
 	"database/sql"
 	"strings"
 
+	"real-time-forum/markdown"
 	"real-time-forum/models"
 )
 
+// postSelectBase takes the viewer's ID as its first argument, for the
+// per-viewer columns.
 const postSelectBase = `
+	WITH viewer(id) AS (SELECT ?)
 	SELECT
-		p.id, p.user_id, u.nickname, p.title, p.content, p.category, p.image_url, p.created_at,
+		p.id, p.user_id, u.nickname, p.title, p.content, p.content_html, p.category, p.created_at,
 		COALESCE(SUM(CASE WHEN v.value =  1 THEN 1 ELSE 0 END), 0) AS upvotes,
 		COALESCE(SUM(CASE WHEN v.value = -1 THEN 1 ELSE 0 END), 0) AS downvotes,
-		COALESCE(SUM(CASE WHEN v.user_id = ? THEN v.value ELSE 0 END), 0) AS user_vote
+		COALESCE(SUM(CASE WHEN v.user_id = (SELECT id FROM viewer) THEN v.value ELSE 0 END), 0) AS user_vote,
+		EXISTS (SELECT 1 FROM bookmarks b
+		        WHERE b.user_id = (SELECT id FROM viewer) AND b.target_type = 'post' AND b.target_id = p.id) AS is_bookmarked,
+		EXISTS (SELECT 1 FROM watches w
+		        WHERE w.user_id = (SELECT id FROM viewer) AND w.post_id = p.id AND w.watching = 1) AS is_watching
 	FROM posts p
 	JOIN users u ON u.id = p.user_id
 	LEFT JOIN votes v ON v.post_id = p.id`
 
-func scanPosts(rows *sql.Rows) ([]models.Post, error) {
+func scanPosts(viewerID string, rows *sql.Rows) ([]models.Post, error) {
 	defer rows.Close()
 	posts := []models.Post{}
 	for rows.Next() {
 		var p models.Post
-		rows.Scan(&p.ID, &p.UserID, &p.Nickname, &p.Title, &p.Content,
-			&p.Category, &p.ImageURL, &p.CreatedAt, &p.Upvotes, &p.Downvotes, &p.UserVote)
+		rows.Scan(&p.ID, &p.UserID, &p.Nickname, &p.Title, &p.Content, &p.ContentHTML,
+			&p.Category, &p.CreatedAt, &p.Upvotes, &p.Downvotes, &p.UserVote, &p.IsBookmarked, &p.IsWatching)
 		posts = append(posts, p)
 	}
+	attachPostMentions(posts)
+	attachPostAttachments(posts)
+	attachPolls(viewerID, posts)
 	return posts, nil
 }
 
@@ -34,7 +45,7 @@ func ListAllPosts(viewerID, orderBy string) ([]models.Post, error) {
 	if err != nil {
 		return nil, err
 	}
-	return scanPosts(rows)
+	return scanPosts(viewerID, rows)
 }
 
 func ListMinePosts(viewerID, orderBy string) ([]models.Post, error) {
@@ -45,7 +56,7 @@ func ListMinePosts(viewerID, orderBy string) ([]models.Post, error) {
 	if err != nil {
 		return nil, err
 	}
-	return scanPosts(rows)
+	return scanPosts(viewerID, rows)
 }
 
 func ListLikedPosts(viewerID, orderBy string) ([]models.Post, error) {
@@ -56,7 +67,35 @@ func ListLikedPosts(viewerID, orderBy string) ([]models.Post, error) {
 	if err != nil {
 		return nil, err
 	}
-	return scanPosts(rows)
+	return scanPosts(viewerID, rows)
+}
+
+// ListSavedPosts returns the posts viewerID bookmarked, only those in folder
+// when it isn't empty.
+func ListSavedPosts(viewerID, folder, orderBy string) ([]models.Post, error) {
+	rows, err := DB.Query(
+		postSelectBase+` WHERE EXISTS (
+			SELECT 1 FROM bookmarks sb
+			WHERE sb.user_id = ? AND sb.target_type = 'post' AND sb.target_id = p.id AND (? = '' OR sb.folder = ?)
+		) GROUP BY p.id ORDER BY `+orderBy,
+		viewerID, viewerID, folder, folder,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanPosts(viewerID, rows)
+}
+
+// ListFollowingPosts returns posts by the users viewerID follows.
+func ListFollowingPosts(viewerID, orderBy string) ([]models.Post, error) {
+	rows, err := DB.Query(
+		postSelectBase+` WHERE p.user_id IN (SELECT followee_id FROM follows WHERE follower_id = ?) GROUP BY p.id ORDER BY `+orderBy,
+		viewerID, viewerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanPosts(viewerID, rows)
 }
 
 func ListPostsByCategories(viewerID string, cats []string, orderBy string) ([]models.Post, error) {
@@ -79,13 +118,13 @@ func ListPostsByCategories(viewerID string, cats []string, orderBy string) ([]mo
 	if err != nil {
 		return nil, err
 	}
-	return scanPosts(rows)
+	return scanPosts(viewerID, rows)
 }
 
-func CreatePost(id, userID, title, content, category, imageURL string) error {
+func CreatePost(id, userID, title, content, category string) error {
 	_, err := DB.Exec(
-		`INSERT INTO posts (id, user_id, title, content, category, image_url) VALUES (?, ?, ?, ?, ?, ?)`,
-		id, userID, title, content, category, imageURL,
+		`INSERT INTO posts (id, user_id, title, content, content_html, category) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, userID, title, content, markdown.Render(content), category,
 	)
 	return err
 }
@@ -93,11 +132,14 @@ func CreatePost(id, userID, title, content, category, imageURL string) error {
 func GetPostByID(postID string) (models.Post, error) {
 	var p models.Post
 	err := DB.QueryRow(`
-		SELECT p.id, p.user_id, u.nickname, p.title, p.content, p.category, p.image_url, p.created_at,
-		       0, 0, 0
+		SELECT p.id, p.user_id, u.nickname, p.title, p.content, p.content_html, p.category, p.created_at,
+		       0, 0, 0, 0, 0
 		FROM posts p JOIN users u ON u.id = p.user_id WHERE p.id = ?`, postID,
-	).Scan(&p.ID, &p.UserID, &p.Nickname, &p.Title, &p.Content,
-		&p.Category, &p.ImageURL, &p.CreatedAt, &p.Upvotes, &p.Downvotes, &p.UserVote)
+	).Scan(&p.ID, &p.UserID, &p.Nickname, &p.Title, &p.Content, &p.ContentHTML,
+		&p.Category, &p.CreatedAt, &p.Upvotes, &p.Downvotes, &p.UserVote, &p.IsBookmarked, &p.IsWatching)
+	p.Mentions = mentionsOf(MentionInPost, p.ID)
+	p.Attachments = attachmentsOf(AttachedToPost, p.ID)
+	p.Poll = pollsFor("", []string{p.ID})[p.ID]
 	return p, err
 }
 
@@ -109,7 +151,18 @@ func GetPostOwnerID(postID string) (string, error) {
 
 func DeletePostCascade(postID string) error {
 	DB.Exec(`DELETE FROM votes    WHERE post_id = ?`, postID)
+	DB.Exec(`DELETE FROM mentions WHERE source_type = 'comment' AND source_id IN (SELECT id FROM comments WHERE post_id = ?)`, postID)
+	DB.Exec(`DELETE FROM mentions WHERE source_type = 'post' AND source_id = ?`, postID)
+	DB.Exec(`DELETE FROM bookmarks WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE post_id = ?)`, postID)
+	DB.Exec(`DELETE FROM bookmarks WHERE target_type = 'post' AND target_id = ?`, postID)
+	DB.Exec(`DELETE FROM watches WHERE post_id = ?`, postID)
+	deletePoll(postID)
+	DB.Exec(`UPDATE attachments SET item_type = NULL, item_id = NULL, position = 0
+		WHERE item_type = 'comment' AND item_id IN (SELECT id FROM comments WHERE post_id = ?)`, postID)
+	ReleaseAttachments(AttachedToPost, postID)
 	DB.Exec(`DELETE FROM comments WHERE post_id = ?`, postID)
+	DB.Exec(`DELETE FROM notification_actors WHERE notification_id IN (SELECT id FROM notifications WHERE post_id = ?)`, postID)
+	DB.Exec(`DELETE FROM notifications WHERE post_id = ?`, postID)
 	_, err := DB.Exec(`DELETE FROM posts WHERE id = ?`, postID)
 	return err
 }
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"strings"
+
+	"real-time-forum/models"
+)
+
+// MessageHit is a search result: the matching message plus the other participant.
+type MessageHit struct {
+	Message         models.Message `json:"message"`
+	PartnerID       string         `json:"partner_id"`
+	PartnerNickname string         `json:"partner_nickname"`
+}
+
+// visibleTo restricts a messageSelectBase query to messages userID took part in
+// and hasn't deleted for themselves. It expects userID three times.
+const visibleTo = `
+	(m.sender_id = ? OR m.receiver_id = ?)
+	AND NOT EXISTS (SELECT 1 FROM message_deletions d WHERE d.message_id = m.id AND d.user_id = ?)`
+
+// SearchMessages runs a full-text search over userID's private messages, newest
+// first. If withID is set only that conversation is searched.
+func SearchMessages(userID, withID, query string, limit, offset int) ([]MessageHit, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return []MessageHit{}, nil
+	}
+
+	where := ` WHERE m.rowid IN (SELECT docid FROM messages_fts WHERE messages_fts MATCH ?) AND` + visibleTo
+	args := []any{match, userID, userID, userID}
+	if withID != "" {
+		where += ` AND (m.sender_id = ? OR m.receiver_id = ?)`
+		args = append(args, withID, withID)
+	}
+	args = append(args, limit, offset)
+
+	rows, err := DB.Query(messageSelectBase+where+`
+		ORDER BY m.created_at DESC, m.rowid DESC
+		LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []MessageHit{}
+	partnerIDs := []string{}
+	for rows.Next() {
+		var h MessageHit
+		if err := scanMessage(rows, &h.Message); err != nil {
+			return nil, err
+		}
+		h.PartnerID = h.Message.ReceiverID
+		if h.PartnerID == userID {
+			h.PartnerID = h.Message.SenderID
+		}
+		hits = append(hits, h)
+		partnerIDs = append(partnerIDs, h.PartnerID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	names, err := nicknamesByID(partnerIDs)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]models.Message, len(hits))
+	for i := range hits {
+		msgs[i] = hits[i].Message
+	}
+	attachMessageMentions(msgs)
+	attachMessageAttachments(msgs)
+	for i := range hits {
+		hits[i].PartnerNickname = names[hits[i].PartnerID]
+		hits[i].Message.Mentions = msgs[i].Mentions
+		hits[i].Message.Attachments = msgs[i].Attachments
+	}
+	return hits, nil
+}
+
+// GetMessageContext returns the message msgID together with up to n messages on
+// either side of it in the same conversation, in chronological order, and
+// whether more messages exist beyond each end. It fails with sql.ErrNoRows if
+// userID can't see the message.
+func GetMessageContext(userID, msgID string, n int) (msgs []models.Message, moreBefore, moreAfter bool, err error) {
+	target, err := scanVisibleMessage(userID, msgID)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	withID := target.ReceiverID
+	if withID == userID {
+		withID = target.SenderID
+	}
+	before, moreBefore, err := GetMessages(userID, withID, msgID, "", n)
+	if err != nil {
+		return nil, false, false, err
+	}
+	after, moreAfter, err := GetMessages(userID, withID, "", msgID, n)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	msgs = make([]models.Message, 0, len(before)+1+len(after))
+	msgs = append(msgs, before...)
+	msgs = append(msgs, target)
+	return append(msgs, after...), moreBefore, moreAfter, nil
+}
+
+func scanVisibleMessage(userID, msgID string) (models.Message, error) {
+	var m models.Message
+	err := scanMessage(DB.QueryRow(messageSelectBase+` WHERE m.id = ? AND`+visibleTo,
+		msgID, userID, userID, userID), &m)
+	m.Mentions = mentionsOf(MentionInMessage, m.ID)
+	m.Attachments = attachmentsOf(AttachedToMessage, m.ID)
+	return m, err
+}
+
+func queryMessages(query string, args ...any) ([]models.Message, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := []models.Message{}
+	for rows.Next() {
+		var m models.Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	attachMessageMentions(msgs)
+	attachMessageAttachments(msgs)
+	return msgs, nil
+}
+
+func nicknamesByID(ids []string) (map[string]string, error) {
+	names := map[string]string{}
+	if len(ids) == 0 {
+		return names, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := DB.Query(
+		`SELECT id, nickname FROM users WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, nickname string
+		if err := rows.Scan(&id, &nickname); err != nil {
+			return nil, err
+		}
+		names[id] = nickname
+	}
+	return names, rows.Err()
+}
+
+// ftsQuery turns free text into a MATCH expression of quoted terms so user
+// input can't inject FTS operators. The last term is a prefix match so results
+// appear while the user is still typing.
+func ftsQuery(q string) string {
+	terms := strings.FieldsFunc(q, func(r rune) bool {
+		return !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 127)
+	})
+	if len(terms) == 0 {
+		return ""
+	}
+	if len(terms) > 8 {
+		terms = terms[:8]
+	}
+	last := len(terms) - 1
+	terms[last] += "*"
+	for i, t := range terms {
+		terms[i] = `"` + t + `"`
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,142 @@
+package db
+
+import "strings"
+
+// WatchedPost is a post on a user's watch list.
+type WatchedPost struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// WatchPost makes userID watch postID for new comments.
+func WatchPost(userID, postID string) error {
+	return setWatching(userID, postID, true)
+}
+
+// UnwatchPost stops userID watching postID. The choice is remembered, so
+// commenting on the post again doesn't start watching it.
+func UnwatchPost(userID, postID string) error {
+	return setWatching(userID, postID, false)
+}
+
+func setWatching(userID, postID string, watching bool) error {
+	_, err := DB.Exec(`
+		INSERT INTO watches (user_id, post_id, watching) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, post_id) DO UPDATE SET watching = excluded.watching`,
+		userID, postID, watching,
+	)
+	return err
+}
+
+// AutoWatchPost makes userID watch postID unless they already decided whether
+// to watch it.
+func AutoWatchPost(userID, postID string) error {
+	_, err := DB.Exec(
+		`INSERT OR IGNORE INTO watches (user_id, post_id, watching) VALUES (?, ?, 1)`, userID, postID,
+	)
+	return err
+}
+
+// ListWatcherIDs returns everyone watching postID.
+func ListWatcherIDs(postID string) ([]string, error) {
+	return queryIDs(`SELECT user_id FROM watches WHERE post_id = ? AND watching = 1`, postID)
+}
+
+// SubscribeCategory makes userID hear about new posts in category.
+func SubscribeCategory(userID, category string) error {
+	_, err := DB.Exec(
+		`INSERT OR IGNORE INTO category_subscriptions (user_id, category) VALUES (?, ?)`, userID, category,
+	)
+	return err
+}
+
+// UnsubscribeCategory undoes SubscribeCategory.
+func UnsubscribeCategory(userID, category string) error {
+	_, err := DB.Exec(`DELETE FROM category_subscriptions WHERE user_id = ? AND category = ?`, userID, category)
+	return err
+}
+
+// ListCategorySubscriberIDs returns everyone subscribed to any of categories.
+func ListCategorySubscriberIDs(categories []string) ([]string, error) {
+	if len(categories) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(categories))
+	for i, c := range categories {
+		args[i] = c
+	}
+	return queryIDs(`
+		SELECT DISTINCT user_id FROM category_subscriptions
+		WHERE category IN (?`+strings.Repeat(", ?", len(categories)-1)+`)`, args...)
+}
+
+// ListSubscriptions returns the posts userID watches, newest first, and the
+// categories they are subscribed to.
+func ListSubscriptions(userID string) (watched []WatchedPost, categories []string, err error) {
+	rows, err := DB.Query(`
+		SELECT p.id, p.title FROM watches w JOIN posts p ON p.id = w.post_id
+		WHERE w.user_id = ? AND w.watching = 1
+		ORDER BY p.created_at DESC`, userID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	watched = []WatchedPost{}
+	for rows.Next() {
+		var p WatchedPost
+		if err := rows.Scan(&p.ID, &p.Title); err != nil {
+			return nil, nil, err
+		}
+		watched = append(watched, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	categories, err = queryIDs(
+		`SELECT category FROM category_subscriptions WHERE user_id = ? ORDER BY category`, userID,
+	)
+	if categories == nil {
+		categories = []string{}
+	}
+	return watched, categories, err
+}
+
+// UnsubscribeAll stops userID watching every post and drops all their category
+// subscriptions.
+func UnsubscribeAll(userID string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE watches SET watching = 0 WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM category_subscriptions WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryIDs runs a query selecting a single text column and returns its values.
+func queryIDs(query string, args ...any) ([]string, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
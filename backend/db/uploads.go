@@ -0,0 +1,66 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"real-time-forum/models"
+)
+
+// Errors from CreateAttachment.
+var (
+	ErrStorageQuota     = errors.New("storage quota exceeded")
+	ErrDailyUploadLimit = errors.New("daily upload limit reached")
+	ErrUploadedFileGone = errors.New("uploaded file was deleted")
+)
+
+// UploadedFile returns the stored file, with its variants, for uploads whose
+// bytes hash to hash, so an identical upload can share it.
+func UploadedFile(hash string) (models.Attachment, bool) {
+	a := models.Attachment{Hash: hash}
+	err := DB.QueryRow(`
+		SELECT filename, thumb_filename, medium_filename, content_type, size, width, height
+		FROM upload_files WHERE hash = ?`, hash,
+	).Scan(&a.Filename, &a.ThumbFilename, &a.MediumFilename, &a.ContentType, &a.Size, &a.Width, &a.Height)
+	return a, err == nil
+}
+
+// UploadUsage returns how much userID has uploaded: the total size of the
+// images they keep, and how many they uploaded today. Limits are left for the
+// caller to fill in.
+func UploadUsage(userID string) (models.UploadUsage, error) {
+	return uploadUsage(DB, userID)
+}
+
+// queryer is what uploadUsage needs of *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func uploadUsage(q queryer, userID string) (models.UploadUsage, error) {
+	t := now()
+	u := models.UploadUsage{ResetsAt: apiTime(dayStart(t).AddDate(0, 0, 1))}
+	err := q.QueryRow(`
+		SELECT
+			(SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = ?),
+			COALESCE((SELECT uploads FROM upload_days WHERE user_id = ? AND day = ?), 0)`,
+		userID, userID, uploadDay(t),
+	).Scan(&u.UsedBytes, &u.UploadsToday)
+	return u, err
+}
+
+// DeleteOldUploadDays forgets upload counts from days before t, which no
+// longer count towards any limit.
+func DeleteOldUploadDays(t time.Time) {
+	DB.Exec(`DELETE FROM upload_days WHERE day < ?`, uploadDay(t))
+}
+
+// Daily upload limits reset at midnight UTC.
+func dayStart(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func uploadDay(t time.Time) string {
+	return dayStart(t).Format("2006-01-02")
+}
@@ -1,6 +1,12 @@
 package db
 
-import "real-time-forum/models"
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"real-time-forum/models"
+)
 
 // CreateUser inserts a new user record.
 func CreateUser(u models.User) error {
@@ -23,6 +29,13 @@ func GetUserByIdentifier(identifier string) (models.User, error) {
 	return u, err
 }
 
+// UserExists reports whether a user with the given ID exists.
+func UserExists(userID string) bool {
+	var count int
+	DB.QueryRow(`SELECT COUNT(*) FROM users WHERE id = ?`, userID).Scan(&count)
+	return count > 0
+}
+
 // GetAllUsersExcept returns id + nickname for every user except myID.
 func GetAllUsersExcept(myID string) ([]models.User, error) {
 	rows, err := DB.Query(
@@ -41,3 +54,106 @@ func GetAllUsersExcept(myID string) ([]models.User, error) {
 	}
 	return users, nil
 }
+
+// UserRef is the public part of a user, for lists like mention suggestions.
+type UserRef struct {
+	ID       string `json:"id"`
+	Nickname string `json:"nickname"`
+}
+
+// ConversationSummary is another user as seen from one user's chat sidebar.
+type ConversationSummary struct {
+	ID          string
+	Nickname    string
+	Status      string
+	StatusText  string
+	LastSeenAt  string
+	LastMsg     string
+	UnreadCount int
+}
+
+// GetConversationSummaries returns every user except myID with the time of the
+// latest message exchanged with myID and how many of their messages myID hasn't
+// read, in a single query.
+func GetConversationSummaries(myID string) ([]ConversationSummary, error) {
+	rows, err := DB.Query(`
+		WITH conv AS (
+			SELECT CASE WHEN m.sender_id = ? THEN m.receiver_id ELSE m.sender_id END AS partner_id,
+			       MAX(m.created_at) AS last_msg,
+			       SUM(CASE WHEN m.receiver_id = ? AND m.read = 0
+			                 AND NOT EXISTS (SELECT 1 FROM message_deletions d WHERE d.message_id = m.id AND d.user_id = ?)
+			                THEN 1 ELSE 0 END) AS unread
+			FROM messages m
+			WHERE m.sender_id = ? OR m.receiver_id = ?
+			GROUP BY partner_id
+		)
+		SELECT u.id, u.nickname, u.status, u.status_text, u.last_seen_at,
+		       COALESCE(c.last_msg, ''), COALESCE(c.unread, 0)
+		FROM users u LEFT JOIN conv c ON c.partner_id = u.id
+		WHERE u.id != ?
+		ORDER BY u.nickname ASC`,
+		myID, myID, myID, myID, myID, myID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []ConversationSummary{}
+	for rows.Next() {
+		var s ConversationSummary
+		var lastSeen sql.NullString
+		if err := rows.Scan(&s.ID, &s.Nickname, &s.Status, &s.StatusText, &lastSeen,
+			&s.LastMsg, &s.UnreadCount); err != nil {
+			return nil, err
+		}
+		s.LastSeenAt = lastSeen.String
+		// MAX() loses the column type, so the driver hands back the raw text
+		if t, err := time.Parse(timeLayout, s.LastMsg); err == nil {
+			s.LastMsg = apiTime(t)
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// GetNicknames maps each of the given user IDs to its nickname.
+func GetNicknames(ids []string) (map[string]string, error) {
+	return nicknamesByID(ids)
+}
+
+// GetUserStatus returns the presence status and custom status text userID picked.
+func GetUserStatus(userID string) (status, text string) {
+	DB.QueryRow(`SELECT status, status_text FROM users WHERE id = ?`, userID).Scan(&status, &text)
+	return
+}
+
+// SetUserStatus saves userID's chosen presence status and custom status text.
+func SetUserStatus(userID, status, text string) error {
+	_, err := DB.Exec(`UPDATE users SET status = ?, status_text = ? WHERE id = ?`, status, text, userID)
+	return err
+}
+
+// GetLastSeen returns when userID was last seen online, or "".
+func GetLastSeen(userID string) string {
+	var lastSeen sql.NullString
+	DB.QueryRow(`SELECT last_seen_at FROM users WHERE id = ?`, userID).Scan(&lastSeen)
+	return lastSeen.String
+}
+
+// TouchLastSeen sets last_seen_at to now for the given users and returns the
+// timestamp that was written.
+func TouchLastSeen(userIDs []string) (string, error) {
+	t := now()
+	if len(userIDs) == 0 {
+		return apiTime(t), nil
+	}
+	args := []any{sqlTime(t)}
+	for _, id := range userIDs {
+		args = append(args, id)
+	}
+	_, err := DB.Exec(
+		`UPDATE users SET last_seen_at = ? WHERE id IN (?`+strings.Repeat(", ?", len(userIDs)-1)+`)`, args...,
+	)
+	return apiTime(t), err
+}
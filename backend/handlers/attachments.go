@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+)
+
+const (
+	maxAttachments = 10
+	maxAltLen      = 300
+
+	defaultAttachmentGrace = 24 * time.Hour
+)
+
+// prepareAttachments checks the attachments a post, comment, message or draft
+// is being saved with, as [{"id", "alt"}], and returns them with their alt
+// text trimmed. msg says what is wrong when userID can't use them.
+func prepareAttachments(userID string, refs []models.Attachment) (attachments []models.Attachment, msg string) {
+	if len(refs) > maxAttachments {
+		return nil, "at most 10 images can be attached"
+	}
+	ids := make([]string, 0, len(refs))
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		if ref.ID == "" || seen[ref.ID] {
+			return nil, "attachments must be different uploads"
+		}
+		seen[ref.ID] = true
+		ref.Alt = strings.TrimSpace(ref.Alt)
+		if utf8.RuneCountInString(ref.Alt) > maxAltLen {
+			return nil, "alt text can be at most 300 characters"
+		}
+		attachments = append(attachments, models.Attachment{ID: ref.ID, Alt: ref.Alt})
+		ids = append(ids, ref.ID)
+	}
+	if !db.AttachmentsUsable(userID, ids) {
+		return nil, "attachment not found"
+	}
+	return attachments, ""
+}
+
+// attachmentError responds to SetAttachments failing.
+func attachmentError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrAttachmentNotFound) {
+		jsonError(w, "attachment not found", http.StatusBadRequest)
+		return
+	}
+	jsonError(w, "internal server error", http.StatusInternalServerError)
+}
+
+// StartAttachmentCleanup deletes uploads nothing uses once they are older
+// than grace, which gives the uploader time to finish what they are
+// attaching them to. Attachments let go by deleted posts, comments, messages
+// and drafts go the same way. Upload counts from past days are dropped too.
+func StartAttachmentCleanup(grace time.Duration) {
+	if grace <= 0 {
+		grace = defaultAttachmentGrace
+	}
+	go func() {
+		for {
+			removeUnusedAttachments(time.Now().Add(-grace))
+			db.DeleteOldUploadDays(time.Now())
+			time.Sleep(min(grace, time.Hour))
+		}
+	}()
+}
+
+func removeUnusedAttachments(cutoff time.Time) {
+	unused, err := db.UnusedAttachments(cutoff)
+	if err != nil {
+		log.Println("unused attachments error:", err)
+		return
+	}
+	removed := 0
+	for _, a := range unused {
+		// It may have been attached since it was listed
+		deleted, fileUnused, err := db.DeleteUnusedAttachment(a)
+		if err != nil {
+			log.Println("delete attachment error:", err)
+			continue
+		}
+		if fileUnused {
+			removeUploads([]string{a.Filename, a.ThumbFilename, a.MediumFilename})
+		}
+		if deleted {
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("removed %d unused attachments", removed)
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+)
+
+type postResponse struct {
+	models.Post
+	Error string `json:"error"`
+}
+
+// createPostWith publishes a post by token's user with refs attached.
+func createPostWith(t *testing.T, token string, refs []models.Attachment, draftID string) (int, postResponse) {
+	t.Helper()
+	body := map[string]any{"title": "photos", "categories": []string{"go"}, "attachments": refs, "draft_id": draftID}
+	var post postResponse
+	rec := apiRequest(t, Posts, http.MethodPost, "/api/posts", token, body, &post)
+	return rec.Code, post
+}
+
+func attachmentIDs(list []models.Attachment) []string {
+	ids := make([]string, len(list))
+	for i, a := range list {
+		ids[i] = a.ID
+	}
+	return ids
+}
+
+func TestAttachmentOrder(t *testing.T) {
+	useTestStorage(t, nil)
+	aliceID, alice := newTestUser(t)
+	a := uploadImage(t, alice, testPNG(t, 10, 10))
+	b := uploadImage(t, alice, testPNG(t, 10, 10))
+	c := uploadImage(t, alice, testPNG(t, 10, 10))
+
+	refs := []models.Attachment{{ID: c.ID, Alt: " third "}, {ID: a.ID, Alt: "first"}, {ID: b.ID}}
+	code, post := createPostWith(t, alice, refs, "")
+	if code != http.StatusCreated {
+		t.Fatalf("%d %s", code, post.Error)
+	}
+	want := []string{c.ID, a.ID, b.ID}
+	if got := attachmentIDs(post.Attachments); !slices.Equal(got, want) {
+		t.Errorf("created with %v, want %v", got, want)
+	}
+	stored, _ := db.GetPostByID(post.ID)
+	if got := attachmentIDs(stored.Attachments); !slices.Equal(got, want) {
+		t.Errorf("loaded with %v, want %v", got, want)
+	}
+	if alt := stored.Attachments[0].Alt; alt != "third" {
+		t.Errorf("alt text %q, want it trimmed", alt)
+	}
+
+	// Setting them again reorders them and lets go of the one left out
+	if err := db.SetAttachments(aliceID, db.AttachedToPost, post.ID, []models.Attachment{{ID: b.ID}, {ID: c.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = db.GetPostByID(post.ID)
+	if got := attachmentIDs(stored.Attachments); !slices.Equal(got, []string{b.ID, c.ID}) {
+		t.Errorf("reordered to %v, want %v", got, []string{b.ID, c.ID})
+	}
+	unused, _ := db.UnusedAttachments(time.Now().Add(time.Hour))
+	if got := attachmentIDs(unused); !slices.Contains(got, a.ID) || slices.Contains(got, b.ID) {
+		t.Errorf("unused uploads are %v; want the one left out, not the others", got)
+	}
+}
+
+func TestAttachmentOwnership(t *testing.T) {
+	useTestStorage(t, nil)
+	aliceID, alice := newTestUser(t)
+	_, bob := newTestUser(t)
+	mine := uploadImage(t, alice, testPNG(t, 10, 10))
+	theirs := uploadImage(t, bob, testPNG(t, 10, 10))
+
+	_, first := createPostWith(t, alice, []models.Attachment{{ID: mine.ID}}, "")
+	tests := []struct {
+		name string
+		refs []models.Attachment
+		want string
+	}{
+		{"someone else's", []models.Attachment{{ID: theirs.ID}}, "attachment not found"},
+		{"already on a post", []models.Attachment{{ID: mine.ID}}, "attachment not found"},
+		{"twice", []models.Attachment{{ID: theirs.ID}, {ID: theirs.ID}}, "attachments must be different uploads"},
+	}
+	for _, tt := range tests {
+		if code, post := createPostWith(t, alice, tt.refs, ""); code != http.StatusBadRequest || post.Error != tt.want {
+			t.Errorf("%s: %d %q, want 400 %q", tt.name, code, post.Error, tt.want)
+		}
+	}
+
+	// Refused by the database as well, leaving the post as it was
+	fresh := uploadImage(t, alice, testPNG(t, 10, 10))
+	err := db.SetAttachments(aliceID, db.AttachedToPost, first.ID, []models.Attachment{{ID: fresh.ID}, {ID: theirs.ID}})
+	if !errors.Is(err, db.ErrAttachmentNotFound) {
+		t.Errorf("got %v, want ErrAttachmentNotFound", err)
+	}
+	stored, _ := db.GetPostByID(first.ID)
+	if got := attachmentIDs(stored.Attachments); !slices.Equal(got, []string{mine.ID}) {
+		t.Errorf("after the failed change the post has %v", got)
+	}
+
+	// A draft's images move to the post it is published as
+	var draft models.Draft
+	apiRequest(t, Drafts, http.MethodPost, "/api/drafts", alice, map[string]any{"title": "later", "attachments": []models.Attachment{{ID: fresh.ID}}}, &draft)
+	code, post := createPostWith(t, alice, []models.Attachment{{ID: fresh.ID}}, draft.ID)
+	if code != http.StatusCreated || !slices.Equal(attachmentIDs(post.Attachments), []string{fresh.ID}) {
+		t.Errorf("publishing a draft's image: %d %s %v", code, post.Error, attachmentIDs(post.Attachments))
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"real-time-forum/db"
+)
+
+const maxFolderNameLen = 50
+
+type bookmarkRequest struct {
+	TargetType string `json:"target_type"` // "post" or "comment"
+	TargetID   string `json:"target_id"`
+	Folder     string `json:"folder"`
+}
+
+// Bookmarks lists the caller's bookmarks (GET, optionally ?folder= and ?type=)
+// or saves one (POST). Saving something already bookmarked moves it to the
+// given folder.
+func Bookmarks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listBookmarks(w, r)
+	case http.MethodPost:
+		saveBookmark(w, r)
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listBookmarks(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	folder := strings.TrimSpace(r.URL.Query().Get("folder"))
+	targetType := r.URL.Query().Get("type")
+	if targetType != "" && targetType != db.BookmarkPost && targetType != db.BookmarkComment {
+		jsonError(w, "type must be post or comment", http.StatusBadRequest)
+		return
+	}
+
+	bookmarks, err := db.ListBookmarks(userID, folder, targetType)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, bookmarks)
+}
+
+func saveBookmark(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req bookmarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TargetID == "" {
+		jsonError(w, "target_type and target_id are required", http.StatusBadRequest)
+		return
+	}
+	req.Folder = strings.TrimSpace(req.Folder)
+	if utf8.RuneCountInString(req.Folder) > maxFolderNameLen {
+		jsonError(w, "folder name is too long", http.StatusBadRequest)
+		return
+	}
+
+	switch req.TargetType {
+	case db.BookmarkPost:
+		if !db.PostExists(req.TargetID) {
+			jsonError(w, "post not found", http.StatusNotFound)
+			return
+		}
+	case db.BookmarkComment:
+		if _, err := db.GetCommentByID(req.TargetID); err != nil {
+			jsonError(w, "comment not found", http.StatusNotFound)
+			return
+		}
+	default:
+		jsonError(w, "target_type must be post or comment", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.SaveBookmark(userID, req.TargetType, req.TargetID, req.Folder); err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, map[string]any{
+		"target_type":   req.TargetType,
+		"target_id":     req.TargetID,
+		"folder":        req.Folder,
+		"is_bookmarked": true,
+	})
+}
+
+// DeleteBookmark removes a bookmark: {"target_type", "target_id"}.
+func DeleteBookmark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req bookmarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TargetType == "" || req.TargetID == "" {
+		jsonError(w, "target_type and target_id are required", http.StatusBadRequest)
+		return
+	}
+	if req.TargetType != db.BookmarkPost && req.TargetType != db.BookmarkComment {
+		jsonError(w, "target_type must be post or comment", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteBookmark(userID, req.TargetType, req.TargetID); err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, map[string]any{
+		"target_type":   req.TargetType,
+		"target_id":     req.TargetID,
+		"is_bookmarked": false,
+	})
+}
+
+// BookmarkFolders lists the caller's bookmark folders with how many bookmarks
+// each holds.
+func BookmarkFolders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	folders, err := db.ListBookmarkFolders(userID)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, folders)
+}
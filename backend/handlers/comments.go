@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"real-time-forum/db"
+	"real-time-forum/models"
 
 	"github.com/google/uuid"
 )
@@ -41,17 +42,24 @@ func DeleteComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ownerID, err := db.GetCommentOwnerID(req.CommentID)
-	if err != nil || ownerID == "" {
+	comment, err := db.GetCommentByID(req.CommentID)
+	if err != nil {
 		jsonError(w, "comment not found", http.StatusNotFound)
 		return
 	}
-	if ownerID != userID {
+	if comment.UserID != userID {
 		jsonError(w, "forbidden", http.StatusForbidden)
 		return
 	}
 
-	db.DeleteComment(req.CommentID)
+	if err := db.DeleteComment(req.CommentID); err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	publish([]string{postTopic(comment.PostID)}, "comment_deleted", commentDeletedEvent{
+		CommentID: comment.ID,
+		PostID:    comment.PostID,
+	})
 	jsonOK(w, http.StatusOK, map[string]string{"message": "comment deleted"})
 }
 
@@ -79,8 +87,9 @@ func createComment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		PostID  string `json:"post_id"`
-		Content string `json:"content"`
+		PostID      string              `json:"post_id"`
+		Content     string              `json:"content"`
+		Attachments []models.Attachment `json:"attachments"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -90,8 +99,13 @@ func createComment(w http.ResponseWriter, r *http.Request) {
 	req.PostID = strings.TrimSpace(req.PostID)
 	req.Content = strings.TrimSpace(req.Content)
 
-	if req.PostID == "" || req.Content == "" {
-		jsonError(w, "post_id and content are required", http.StatusBadRequest)
+	if req.PostID == "" || (req.Content == "" && len(req.Attachments) == 0) {
+		jsonError(w, "post_id and content or an image are required", http.StatusBadRequest)
+		return
+	}
+	attachments, msg := prepareAttachments(userID, req.Attachments)
+	if msg != "" {
+		jsonError(w, msg, http.StatusBadRequest)
 		return
 	}
 
@@ -105,6 +119,13 @@ func createComment(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	if err := db.SetAttachments(userID, db.AttachedToComment, id, attachments); err != nil {
+		db.DeleteComment(id)
+		attachmentError(w, err)
+		return
+	}
+	saveMentions(db.MentionInComment, id, req.Content)
+	autoWatch(userID, req.PostID)
 
 	comment, err := db.GetCommentByID(id)
 	if err != nil {
@@ -112,5 +133,37 @@ func createComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	publish([]string{postTopic(comment.PostID)}, "new_comment", comment)
+	// A busy post can have a lot of watchers; don't hold up the response
+	go notifyCommenters(comment)
 	jsonOK(w, http.StatusCreated, comment)
 }
+
+// notifyCommenters tells the users mentioned in a new comment and everyone
+// watching the post about it. The post's author hears it as a comment on their
+// post; someone who was mentioned only gets the mention.
+func notifyCommenters(c models.Comment) {
+	mentioned := map[string]bool{}
+	for _, id := range mentionedUsers(c.Mentions) {
+		mentioned[id] = true
+		notify(id, notifyMention, c.PostID, c.ID, c.UserID)
+	}
+
+	authorID, err := db.GetPostOwnerID(c.PostID)
+	if err != nil {
+		return
+	}
+	watchers, err := db.ListWatcherIDs(c.PostID)
+	if err != nil {
+		return
+	}
+	for _, id := range watchers {
+		switch {
+		case mentioned[id]:
+		case id == authorID:
+			notify(id, notifyComment, c.PostID, c.ID, c.UserID)
+		default:
+			notify(id, notifyReply, c.PostID, c.ID, c.UserID)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+
+	"github.com/google/uuid"
+)
+
+// Drafts lists the caller's drafts (GET) or autosaves one (POST).
+func Drafts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listDrafts(w, r)
+	case http.MethodPost:
+		saveDraft(w, r)
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listDrafts(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	drafts, err := db.ListDrafts(userID)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, drafts)
+}
+
+// saveDraft takes the post form as it is: {"id", "title", "content",
+// "categories", "attachments", "poll"}. Without an id it starts a new draft; the
+// response carries the id to send with later saves. Nothing is validated
+// beyond the draft not being empty, since it is still being written.
+func saveDraft(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ID          string              `json:"id"`
+		Title       string              `json:"title"`
+		Content     string              `json:"content"`
+		Categories  []string            `json:"categories"`
+		Attachments []models.Attachment `json:"attachments"`
+		Poll        *models.PollSpec    `json:"poll"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	attachments, msg := prepareAttachments(userID, req.Attachments)
+	if msg != "" {
+		jsonError(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	d := models.Draft{
+		ID:          req.ID,
+		UserID:      userID,
+		Title:       req.Title,
+		Content:     req.Content,
+		Category:    strings.Join(cleanCategories(req.Categories), ","),
+		Attachments: attachments,
+		Poll:        req.Poll,
+	}
+	status := http.StatusOK
+	isNew := d.ID == ""
+	if isNew {
+		if d.Title == "" && d.Content == "" && len(d.Attachments) == 0 {
+			jsonError(w, "draft is empty", http.StatusBadRequest)
+			return
+		}
+		d.ID = uuid.NewString()
+		status = http.StatusCreated
+	}
+
+	if !storeDraft(w, d, isNew) {
+		return
+	}
+	respondDraft(w, userID, d.ID, status)
+}
+
+// DeleteDraft takes {"id": "..."} and throws the caller's draft away, which
+// also cancels it if it was scheduled.
+func DeleteDraft(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := draftRequest(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := db.DeleteDraft(userID, id)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		jsonError(w, "draft not found", http.StatusNotFound)
+		return
+	}
+	wakeScheduler()
+	jsonOK(w, http.StatusOK, map[string]string{"message": "draft deleted"})
+}
+
+// ScheduledPosts lists the caller's posts waiting to be published, soonest
+// first.
+func ScheduledPosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	drafts, err := db.ListScheduledDrafts(userID)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, drafts)
+}
+
+// CancelScheduledPost takes {"id": "..."} and stops a scheduled post from
+// being published. It is kept as a draft.
+func CancelScheduledPost(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := draftRequest(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := db.UnscheduleDraft(userID, id)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		jsonError(w, "scheduled post not found", http.StatusNotFound)
+		return
+	}
+	wakeScheduler()
+	respondDraft(w, userID, id, http.StatusOK)
+}
+
+// draftRequest checks a POST naming one of the caller's drafts by {"id"} and
+// returns the caller and the id. It has already responded when ok is false.
+func draftRequest(w http.ResponseWriter, r *http.Request) (userID, id string, ok bool) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", "", false
+	}
+
+	userID = userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return "", "", false
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		jsonError(w, "id is required", http.StatusBadRequest)
+		return "", "", false
+	}
+	return userID, req.ID, true
+}
+
+// schedulePost saves d, creating it if it has no ID, and schedules it for
+// publishAt.
+func schedulePost(w http.ResponseWriter, d models.Draft, publishAt time.Time) {
+	isNew := d.ID == ""
+	if isNew {
+		d.ID = uuid.NewString()
+	}
+	if !storeDraft(w, d, isNew) {
+		return
+	}
+	found, err := db.ScheduleDraft(d.UserID, d.ID, publishAt)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		jsonError(w, "draft not found", http.StatusNotFound)
+		return
+	}
+	wakeScheduler()
+	respondDraft(w, d.UserID, d.ID, http.StatusAccepted)
+}
+
+// storeDraft saves d with its attachments, creating it if isNew. Only the
+// server picks draft IDs, since a published draft's becomes its post's; an
+// ID the caller has no draft under is not found. It has already responded
+// when it returns false.
+func storeDraft(w http.ResponseWriter, d models.Draft, isNew bool) bool {
+	found := true
+	var err error
+	if isNew {
+		err = db.CreateDraft(d)
+	} else {
+		found, err = db.SaveDraft(d)
+	}
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if !found {
+		jsonError(w, "draft not found", http.StatusNotFound)
+		return false
+	}
+	if err := db.SetAttachments(d.UserID, db.AttachedToDraft, d.ID, d.Attachments); err != nil {
+		attachmentError(w, err)
+		return false
+	}
+	return true
+}
+
+func respondDraft(w http.ResponseWriter, userID, id string, status int) {
+	d, err := db.GetDraft(userID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "draft not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, status, d)
+}
+
+// schedulerWake interrupts the scheduler's wait when a schedule changes, so
+// a post due sooner than whatever it was waiting for isn't late.
+var schedulerWake = make(chan struct{}, 1)
+
+func wakeScheduler() {
+	select {
+	case schedulerWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartScheduler publishes scheduled posts when they fall due. Besides
+// waking for the next one it knows about, it looks again every interval so
+// posts scheduled through another instance aren't missed.
+func StartScheduler(interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		for {
+			publishDueDrafts()
+
+			wait := interval
+			if next, ok := db.NextPublishAt(); ok {
+				wait = min(wait, time.Until(next))
+			}
+			// Don't spin on a draft that keeps failing to publish
+			timer := time.NewTimer(max(wait, time.Second))
+			select {
+			case <-timer.C:
+			case <-schedulerWake:
+				timer.Stop()
+			}
+		}
+	}()
+}
+
+// publishDueDrafts publishes every scheduled draft whose time has come. The
+// post keeps the draft's ID, so if two instances race to publish the same
+// draft the second insert fails and it is only published once. A draft that
+// can't be published is taken off the schedule rather than retried.
+func publishDueDrafts() {
+	drafts, err := db.DueDrafts()
+	if err != nil {
+		log.Println("due drafts error:", err)
+		return
+	}
+	for _, d := range drafts {
+		d.Title = strings.TrimSpace(d.Title)
+		d.Content = strings.TrimSpace(d.Content)
+		// The draft may have been edited into something unpublishable since
+		// it was scheduled; leave it as a draft for its author to fix
+		msg := validatePost(d.Title, d.Content, d.Category, len(d.Attachments) > 0)
+		if msg == "" && d.Poll != nil {
+			_, _, msg = preparePoll(d.Poll, time.Now())
+		}
+		if msg != "" {
+			log.Printf("scheduled post %s not published: %s", d.ID, msg)
+			unscheduleFailedDraft(d, msg)
+			continue
+		}
+		if _, err := publishPost(d.ID, d.UserID, d.Title, d.Content, d.Category, d.Attachments, d.Poll); err != nil && !db.PostExists(d.ID) {
+			log.Println("publish scheduled post error:", err)
+			unscheduleFailedDraft(d, "something went wrong publishing it")
+			continue
+		}
+		db.DeleteDraft(d.UserID, d.ID)
+	}
+}
+
+// unscheduleFailedDraft turns a scheduled draft that couldn't be published back
+// into a plain draft and tells its author why.
+func unscheduleFailedDraft(d models.Draft, reason string) {
+	if _, err := db.UnscheduleDraft(d.UserID, d.ID); err != nil {
+		log.Println("unschedule draft error:", err)
+	}
+	sendToUser(d.UserID, "draft_unscheduled", draftUnscheduledEvent{
+		DraftID: d.ID,
+		Title:   d.Title,
+		Reason:  reason,
+	})
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+
+	"github.com/google/uuid"
+)
+
+// scheduledDraft saves a draft for userID that was due a minute ago.
+func scheduledDraft(t *testing.T, userID, title, category string) string {
+	t.Helper()
+	id := uuid.NewString()
+	err := db.CreateDraft(models.Draft{ID: id, UserID: userID, Title: title, Content: "content", Category: category})
+	if err != nil {
+		t.Fatal("create draft:", err)
+	}
+	if _, err := db.ScheduleDraft(userID, id, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal("schedule draft:", err)
+	}
+	return id
+}
+
+// failPostsTitled makes creating a post titled title fail until the test ends.
+func failPostsTitled(t *testing.T, title string) {
+	t.Helper()
+	_, err := db.DB.Exec(`CREATE TRIGGER fail_posts BEFORE INSERT ON posts WHEN NEW.title = '` + title + `'
+		BEGIN SELECT RAISE(ABORT, 'failing on purpose'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Exec(`DROP TRIGGER fail_posts`) })
+}
+
+// unscheduledEvents returns the draft_unscheduled events queued for c.
+func unscheduledEvents(t *testing.T, c *Client) []draftUnscheduledEvent {
+	t.Helper()
+	var events []draftUnscheduledEvent
+	for len(c.send) > 0 {
+		if msg := nextEvent(t, c); msg.Type == "draft_unscheduled" {
+			var e draftUnscheduledEvent
+			json.Unmarshal(msg.Payload, &e)
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func TestPublishDueDraftsFailure(t *testing.T) {
+	tests := []struct {
+		name, title, category, reason string
+	}{
+		{"invalid", "no category", "", "title and at least one category are required"},
+		{"publish error", "doomed", "go", "something went wrong publishing it"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failPostsTitled(t, "doomed")
+			userID, _ := newTestUser(t)
+			c := newLocalClient(t, userID)
+			id := scheduledDraft(t, userID, tt.title, tt.category)
+
+			publishDueDrafts()
+			d, err := db.GetDraft(userID, id)
+			if err != nil {
+				t.Fatal("the draft is gone:", err)
+			}
+			if d.PublishAt != "" {
+				t.Error("the draft is still scheduled")
+			}
+			if db.PostExists(id) {
+				t.Error("the post was published")
+			}
+			want := draftUnscheduledEvent{DraftID: id, Title: tt.title, Reason: tt.reason}
+			if got := unscheduledEvents(t, c); len(got) != 1 || got[0] != want {
+				t.Errorf("author was told %+v, want %+v", got, want)
+			}
+
+			// Not retried
+			publishDueDrafts()
+			if got := unscheduledEvents(t, c); len(got) != 0 {
+				t.Errorf("retried and told the author again: %+v", got)
+			}
+		})
+	}
+}
+
+func TestPublishDueDrafts(t *testing.T) {
+	userID, _ := newTestUser(t)
+	c := newLocalClient(t, userID)
+	id := scheduledDraft(t, userID, "on time", "go")
+
+	publishDueDrafts()
+	if !db.PostExists(id) {
+		t.Fatal("the post wasn't published")
+	}
+	if _, err := db.GetDraft(userID, id); err == nil {
+		t.Error("the draft is still there")
+	}
+	if got := unscheduledEvents(t, c); len(got) != 0 {
+		t.Errorf("author was told %+v", got)
+	}
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"real-time-forum/db"
+)
+
+const (
+	// pollWait is how long a long-poll request is held open waiting for events.
+	pollWait = 25 * time.Second
+
+	// pollGrace is how long a long-poll client stays connected between polls.
+	pollGrace = 30 * time.Second
+
+	maxPollEvents = 100
+)
+
+// Events streams the user's events as Server-Sent Events, for clients that
+// can't keep a WebSocket open. Each event's data is the same envelope the
+// WebSocket sends; logged events carry their seq as the SSE id, so a
+// reconnecting EventSource resumes from Last-Event-ID on its own.
+func Events(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	lastSeq, resume, err := resumePoint(r)
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		lastSeq, err = strconv.ParseInt(id, 10, 64)
+		resume = true
+	}
+	if err != nil || lastSeq < 0 {
+		jsonError(w, "invalid last event id", http.StatusBadRequest)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	// Attach alongside the loop below, which has to be draining the buffer
+	// while a long replay goes out
+	client := newClient(userID, nil)
+	attached := make(chan struct{})
+	go func() {
+		defer close(attached)
+		attach(client, lastSeq, resume)
+	}()
+	defer func() {
+		<-attached
+		detach(client)
+	}()
+
+	// Comments keep proxies from timing out an idle stream and notice dead clients
+	ticker := time.NewTicker(client.cfg.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		var frame string
+		select {
+		case data, ok := <-client.send:
+			if !ok {
+				return
+			}
+			frame = sseFrame(data)
+		case <-ticker.C:
+			frame = ": ping\n\n"
+		case <-r.Context().Done():
+			return
+		}
+
+		rc.SetWriteDeadline(time.Now().Add(client.cfg.WriteWait))
+		if _, err := fmt.Fprint(w, frame); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// sseFrame wraps an envelope as one SSE event.
+func sseFrame(envelope []byte) string {
+	if seq := envelopeSeq(envelope); seq > 0 {
+		return fmt.Sprintf("id: %d\ndata: %s\n\n", seq, envelope)
+	}
+	return fmt.Sprintf("data: %s\n\n", envelope)
+}
+
+// envelopeSeq returns the seq of a logged event's envelope, or 0.
+func envelopeSeq(envelope []byte) int64 {
+	var msg struct {
+		Seq int64 `json:"seq"`
+	}
+	json.Unmarshal(envelope, &msg)
+	return msg.Seq
+}
+
+// pollClient is a Client on the long-poll transport. It stays attached for
+// pollGrace after each poll, buffering events until the next one arrives.
+type pollClient struct {
+	*Client
+	polling sync.Mutex
+	expiry  *time.Timer
+	once    sync.Once
+
+	// handedOut is the highest seq sent in a poll response. Guarded by polling.
+	handedOut int64
+}
+
+var pollClients = struct {
+	sync.Mutex
+	byUser map[string]*pollClient
+}{byUser: make(map[string]*pollClient)}
+
+// PollEvents is the long-poll fallback. It waits up to pollWait for events and
+// returns {"events": [...]} holding the same envelopes as the WebSocket. Each
+// poll may pass ?last_seq=N, the last seq the client received: what it missed,
+// whether while it had no client attached or because a response was lost on
+// the way, is replayed.
+func PollEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	lastSeq, resume, err := resumePoint(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pc := pollClientFor(userID, lastSeq, resume)
+	if !pc.polling.TryLock() {
+		jsonError(w, "a poll is already in progress", http.StatusConflict)
+		return
+	}
+	defer pc.polling.Unlock()
+	pc.expiry.Stop()
+	closed := false
+	defer func() {
+		if closed {
+			pc.detach()
+		} else {
+			pc.expiry.Reset(pollGrace)
+		}
+	}()
+	if resume && lastSeq < pc.handedOut {
+		pc.rewind(lastSeq)
+	}
+
+	// Nothing is taken off the queue for a client that has gone away; it is
+	// still there for the next poll
+	if r.Context().Err() != nil {
+		return
+	}
+	events := []json.RawMessage{}
+	select {
+	case data, ok := <-pc.send:
+		if ok {
+			events = append(events, data)
+		}
+		closed = !ok
+	case <-time.After(pollWait):
+	case <-r.Context().Done():
+		return
+	}
+	// Hand over whatever else is already queued
+	for !closed && len(events) < maxPollEvents && len(pc.send) > 0 {
+		data, ok := <-pc.send
+		if ok {
+			events = append(events, data)
+		}
+		closed = !ok
+	}
+	for _, data := range events {
+		pc.handedOut = max(pc.handedOut, envelopeSeq(data))
+	}
+	jsonOK(w, http.StatusOK, map[string]any{"events": events})
+}
+
+// pollClientFor returns userID's attached long-poll client, attaching a new
+// one if there isn't a live one.
+func pollClientFor(userID string, lastSeq int64, resume bool) *pollClient {
+	pollClients.Lock()
+	defer pollClients.Unlock()
+
+	if pc, ok := pollClients.byUser[userID]; ok {
+		hub.mu.RLock()
+		current := hub.clients[userID] == pc.Client
+		hub.mu.RUnlock()
+		if current {
+			return pc
+		}
+	}
+
+	// Nothing reads the buffer until the next poll, so it has to hold a whole
+	// replay on top of the live events
+	pc := &pollClient{Client: newClient(userID, nil)}
+	pc.send = make(chan []byte, db.EventLogSize+cap(pc.send))
+	pc.expiry = time.AfterFunc(pollGrace, pc.detach)
+	pollClients.byUser[userID] = pc
+	attach(pc.Client, lastSeq, resume)
+	return pc
+}
+
+// rewind replays the events after lastSeq again, for a client that never got
+// the responses holding them. The logged events still queued are dropped, as
+// the replay repeats them; the others are queued again after it.
+// Must be called with pc.polling held.
+func (pc *pollClient) rewind(lastSeq int64) {
+	mu := eventLock(pc.userID)
+	mu.Lock()
+	defer mu.Unlock()
+
+	var unlogged [][]byte
+	for len(pc.send) > 0 {
+		data, ok := <-pc.send
+		if !ok {
+			return
+		}
+		if envelopeSeq(data) == 0 {
+			unlogged = append(unlogged, data)
+		}
+	}
+	pc.replay(lastSeq, true)
+	for _, data := range unlogged {
+		pc.enqueue(data)
+	}
+	pc.handedOut = lastSeq
+}
+
+func (pc *pollClient) detach() {
+	pc.once.Do(func() {
+		pollClients.Lock()
+		if pollClients.byUser[pc.userID] == pc {
+			delete(pollClients.byUser, pc.userID)
+		}
+		pollClients.Unlock()
+		detach(pc.Client)
+	})
+}
+
+// SendEvent accepts a request envelope ({"type", "payload"}) from an SSE or
+// long-poll client and handles it as if it had come over the WebSocket. The
+// ack or error is delivered on the event stream.
+func SendEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var msg WSMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, wsConfig.MaxMessageSize)).Decode(&msg); err != nil || msg.Type == "" {
+		jsonError(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	hub.mu.RLock()
+	c, connected := hub.clients[userID]
+	hub.mu.RUnlock()
+	if !connected {
+		jsonError(w, "no event stream open", http.StatusConflict)
+		return
+	}
+
+	c.dispatch(msg)
+	jsonOK(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/db"
+)
+
+// readSSEUntil reads events from an SSE stream up to and including one of
+// type last.
+func readSSEUntil(t *testing.T, r *bufio.Reader, last string) []WSMessage {
+	t.Helper()
+	var events []WSMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("after %d events: %v", len(events), err)
+		}
+		data, ok := strings.CutPrefix(strings.TrimSuffix(line, "\n"), "data: ")
+		if !ok {
+			continue
+		}
+		var msg WSMessage
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			t.Fatalf("bad event %q: %v", data, err)
+		}
+		events = append(events, msg)
+		if msg.Type == last {
+			return events
+		}
+	}
+}
+
+func TestSSEResumeLongGap(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(Events))
+	t.Cleanup(srv.Close)
+	userID, token := newTestUser(t)
+
+	const missed = 600
+	lastSeq := logTestEvents(t, userID, missed)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/events?token="+token, nil)
+	req.Header.Set("Last-Event-ID", fmt.Sprint(lastSeq))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	done := time.AfterFunc(5*time.Second, func() { resp.Body.Close() })
+	defer done.Stop()
+
+	checkReplay(t, readSSEUntil(t, bufio.NewReader(resp.Body), "own_status"), lastSeq, missed)
+	if localClient(userID) == nil {
+		t.Error("dropped after the replay")
+	}
+}
+
+// poll makes one long-poll request as token's user and returns the events,
+// or nil if nothing was written.
+func poll(t *testing.T, ctx context.Context, token, query string) []WSMessage {
+	t.Helper()
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/api/events/poll?token="+token+"&"+query, nil)
+	rec := httptest.NewRecorder()
+	PollEvents(rec, req)
+	if rec.Body.Len() == 0 {
+		return nil
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("poll: %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Events []WSMessage `json:"events"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Events
+}
+
+// startPolling attaches a long-poll client for token's user, takes the
+// initial state and pending presence updates off its queue and detaches it
+// when the test ends.
+func startPolling(t *testing.T, userID, token string) {
+	t.Helper()
+	poll(t, t.Context(), token, "")
+	flushPresence()
+	if events := poll(t, t.Context(), token, ""); len(events) == 0 || events[0].Type != "presence_update" {
+		t.Fatalf("second poll got %v, want the presence update", events)
+	}
+	t.Cleanup(func() {
+		pollClients.Lock()
+		pc := pollClients.byUser[userID]
+		pollClients.Unlock()
+		if pc != nil {
+			pc.detach()
+		}
+	})
+}
+
+// seqs returns the seqs of the logged events among events. Others, such as
+// presence updates, come and go with timing.
+func seqs(events []WSMessage) []int64 {
+	var seqs []int64
+	for _, msg := range events {
+		if msg.Seq > 0 {
+			seqs = append(seqs, msg.Seq)
+		}
+	}
+	return seqs
+}
+
+func TestPollCanceled(t *testing.T) {
+	userID, token := newTestUser(t)
+	startPolling(t, userID, token)
+
+	// A poll waiting for events returns as soon as the client gives up
+	ctx, cancel := context.WithCancel(t.Context())
+	returned := make(chan []WSMessage)
+	go func() { returned <- poll(t, ctx, token, "") }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case events := <-returned:
+		if events != nil {
+			t.Errorf("a canceled poll answered %v", events)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a canceled poll kept waiting")
+	}
+
+	// Events queued for a client that has gone away are kept for the next poll
+	sendToUser(userID, "test_event", 1)
+	if events := poll(t, ctx, token, ""); events != nil {
+		t.Errorf("a canceled poll answered %v", events)
+	}
+	if got := seqs(poll(t, t.Context(), token, "")); len(got) != 1 {
+		t.Errorf("next poll got seqs %v, want the event", got)
+	}
+}
+
+func TestPollResumeLostResponse(t *testing.T) {
+	userID, token := newTestUser(t)
+	startPolling(t, userID, token)
+	lastSeq := db.LastEventSeq(userID)
+
+	for i := range 3 {
+		sendToUser(userID, "test_event", i)
+	}
+	if got := seqs(poll(t, t.Context(), token, fmt.Sprintf("last_seq=%d", lastSeq))); len(got) != 3 {
+		t.Fatalf("got seqs %v, want 3 events", got)
+	}
+
+	// That response never arrived, so the client asks again from where it was
+	sendToUser(userID, "test_event", 3)
+	events := poll(t, t.Context(), token, fmt.Sprintf("last_seq=%d", lastSeq))
+	want := []int64{lastSeq + 1, lastSeq + 2, lastSeq + 3, lastSeq + 4}
+	if got := seqs(events); !slices.Equal(got, want) {
+		t.Errorf("got seqs %v, want %v", got, want)
+	}
+	if !slices.ContainsFunc(events, func(msg WSMessage) bool { return msg.Type == "resumed" }) {
+		t.Error("the replay wasn't marked resumed")
+	}
+
+	// Up to date, nothing is replayed again
+	sendToUser(userID, "test_event", 4)
+	if got := seqs(poll(t, t.Context(), token, fmt.Sprintf("last_seq=%d", lastSeq+4))); !slices.Equal(got, []int64{lastSeq + 5}) {
+		t.Errorf("got seqs %v, want only %d", got, lastSeq+5)
+	}
+}
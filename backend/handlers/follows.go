@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"real-time-forum/db"
+)
+
+// Follow and Unfollow take {"user_id": "..."} and return the followed user's
+// updated profile.
+func Follow(w http.ResponseWriter, r *http.Request) {
+	changeFollow(w, r, db.Follow)
+}
+
+func Unfollow(w http.ResponseWriter, r *http.Request) {
+	changeFollow(w, r, db.Unfollow)
+}
+
+func changeFollow(w http.ResponseWriter, r *http.Request, change func(followerID, followeeID string) error) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		jsonError(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == userID {
+		jsonError(w, "you can't follow yourself", http.StatusBadRequest)
+		return
+	}
+	if !db.UserExists(req.UserID) {
+		jsonError(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	if err := change(userID, req.UserID); err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	profile, err := db.GetProfile(userID, req.UserID)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, profile)
+}
+
+// Profile returns ?id=<user>'s profile with follower and following counts.
+func Profile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		id = userID
+	}
+	profile, err := db.GetProfile(userID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, profile)
+}
+
+// Following lists the users the caller follows.
+func Following(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	users, err := db.ListFollowing(userID)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, users)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// instanceID tells this server process apart from the others sharing a backend.
+var instanceID = uuid.NewString()
+
+// hubMessage is what the hub sends between instances.
+type hubMessage struct {
+	UserID   string          `json:"user_id,omitempty"`   // recipient; empty means everyone
+	Topics   []string        `json:"topics,omitempty"`    // or the subscribers of these topics
+	NoTopics bool            `json:"no_topics,omitempty"` // and clients that don't use topics
+	Data     json.RawMessage `json:"data,omitempty"`      // marshalled WSMessage
+
+	// Kick names the instance that has to drop UserID's connection because
+	// they logged in again somewhere else.
+	Kick string `json:"kick,omitempty"`
+}
+
+// presenceEntry is a connected user's entry in the shared presence directory.
+type presenceEntry struct {
+	Instance   string `json:"instance"`
+	Status     string `json:"status"`
+	StatusText string `json:"status_text"`
+	Idle       bool   `json:"idle"`
+	Seen       int64  `json:"seen"` // unix seconds, refreshed by the presence sweep
+}
+
+// HubBackend is what the hub needs to share between server instances: fan-out
+// of events and a directory of who is connected where. Local connections stay
+// in hub.clients either way.
+type HubBackend interface {
+	// Publish hands m to every instance, this one included.
+	Publish(m hubMessage) error
+
+	// SetPresence stores userID's entry and returns the one it replaced.
+	SetPresence(userID string, e presenceEntry) (prev presenceEntry, existed bool, err error)
+	// ReleasePresence removes userID's entry if it still belongs to instance,
+	// so a disconnect never clobbers a newer connection on another server.
+	ReleasePresence(userID, instance string) error
+	LookupPresence(userID string) (presenceEntry, bool)
+	AllPresence() map[string]presenceEntry
+
+	// SwapAnnounced records the presence last broadcast for userID and returns
+	// the previous one, so exactly one instance announces each change.
+	SwapAnnounced(userID string, s presenceState) (presenceState, error)
+}
+
+// backend defaults to a single process; see UseHubBackend.
+var backend HubBackend = newMemoryBackend(receiveHubMessage)
+
+// UseHubBackend shares the hub through b, e.g. one from NewRedisHub, so
+// several server instances can share users. Call it before serving.
+func UseHubBackend(b HubBackend) {
+	backend = b
+}
+
+// receiveHubMessage handles a hubMessage published by any instance.
+func receiveHubMessage(m hubMessage) {
+	hub.mu.RLock()
+	c, local := hub.clients[m.UserID]
+	hub.mu.RUnlock()
+
+	switch {
+	case m.Kick != "":
+		if m.Kick != instanceID || !local {
+			return
+		}
+		// Forget the connection right away so nothing here writes to it or
+		// refreshes its presence entry over the new one
+		hub.mu.Lock()
+		if hub.clients[m.UserID] == c {
+			delete(hub.clients, m.UserID)
+		}
+		hub.mu.Unlock()
+		kickClient(c)
+	case len(m.Topics) > 0:
+		deliverTopicsLocal(m.Topics, m.NoTopics, m.Data)
+	case m.UserID == "":
+		broadcastLocal(m.Data)
+	case local:
+		c.enqueue(m.Data)
+	}
+}
+
+// memoryBackend keeps everything in process, for running a single instance.
+type memoryBackend struct {
+	handle func(hubMessage)
+
+	mu        sync.Mutex
+	presence  map[string]presenceEntry
+	announced map[string]presenceState
+}
+
+func newMemoryBackend(handle func(hubMessage)) *memoryBackend {
+	return &memoryBackend{
+		handle:    handle,
+		presence:  make(map[string]presenceEntry),
+		announced: make(map[string]presenceState),
+	}
+}
+
+func (b *memoryBackend) Publish(m hubMessage) error {
+	b.handle(m)
+	return nil
+}
+
+func (b *memoryBackend) SetPresence(userID string, e presenceEntry) (presenceEntry, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev, existed := b.presence[userID]
+	b.presence[userID] = e
+	return prev, existed, nil
+}
+
+func (b *memoryBackend) ReleasePresence(userID, instance string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.presence[userID]; ok && e.Instance == instance {
+		delete(b.presence, userID)
+	}
+	return nil
+}
+
+func (b *memoryBackend) LookupPresence(userID string) (presenceEntry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.presence[userID]
+	return e, ok
+}
+
+func (b *memoryBackend) AllPresence() map[string]presenceEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	all := make(map[string]presenceEntry, len(b.presence))
+	for id, e := range b.presence {
+		all[id] = e
+	}
+	return all
+}
+
+func (b *memoryBackend) SwapAnnounced(userID string, s presenceState) (presenceState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev, ok := b.announced[userID]
+	if !ok {
+		prev = presenceState{Status: statusOffline}
+	}
+	if s.Status == statusOffline {
+		delete(b.announced, userID)
+	} else {
+		b.announced[userID] = s
+	}
+	return prev, nil
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newInstanceFunc starts another server instance on a shared hub backend,
+// handing it the hub messages published by any instance.
+type newInstanceFunc func(handle func(hubMessage)) HubBackend
+
+func TestMemoryHub(t *testing.T) {
+	testHub(t, func(t *testing.T) newInstanceFunc {
+		// Instances in one process share the backend, which hands each message
+		// to all of them
+		var mu sync.Mutex
+		var handlers []func(hubMessage)
+		shared := newMemoryBackend(func(m hubMessage) {
+			mu.Lock()
+			hs := append([]func(hubMessage){}, handlers...)
+			mu.Unlock()
+			for _, h := range hs {
+				h(m)
+			}
+		})
+		return func(handle func(hubMessage)) HubBackend {
+			mu.Lock()
+			handlers = append(handlers, handle)
+			mu.Unlock()
+			return shared
+		}
+	})
+}
+
+// TestRedisHub runs against the Redis server at REDIS_ADDR (host:port). Point
+// it at a scratch server: the tests publish on the forum's hub channel.
+func TestRedisHub(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+	testHub(t, func(t *testing.T) newInstanceFunc {
+		return func(handle func(hubMessage)) HubBackend {
+			b, err := newRedisBackend(&redis.Options{Addr: addr}, handle)
+			if err != nil {
+				t.Fatal("connect:", err)
+			}
+			t.Cleanup(func() {
+				b.sub.Close()
+				b.rdb.Close()
+			})
+			return b
+		}
+	})
+}
+
+// testHub checks that a hub backend lets instances share users. This process's
+// hub stands in for one instance; the others only see hub messages.
+func testHub(t *testing.T, newHub func(t *testing.T) newInstanceFunc) {
+	t.Run("direct delivery", func(t *testing.T) {
+		newInstance := newHub(t)
+		userID, _ := newTestUser(t)
+		remote, got := recorder(userID)
+		other := newInstance(remote)
+		useBackend(t, newInstance(ignore))
+
+		// Offline, nothing is sent anywhere
+		if sendToUser(userID, "test_event", "hello") {
+			t.Error("delivered to a user who isn't connected")
+		}
+		other.SetPresence(userID, presenceEntry{Instance: "other", Status: statusOnline, Seen: time.Now().Unix()})
+		if !sendToUser(userID, "test_event", "hello") {
+			t.Fatal("not delivered to a user connected to another instance")
+		}
+		m := nextHubMessage(t, got)
+		var msg WSMessage
+		json.Unmarshal(m.Data, &msg)
+		if msg.Type != "test_event" || string(msg.Payload) != `"hello"` || msg.Seq == 0 {
+			t.Errorf("the other instance got %s", m.Data)
+		}
+	})
+
+	t.Run("broadcast", func(t *testing.T) {
+		newInstance := newHub(t)
+		newInstance(receiveHubMessage)
+		useBackend(t, newInstance(ignore))
+		alice, _ := newTestUser(t)
+		bob, _ := newTestUser(t)
+		clients := []*Client{newLocalClient(t, alice), newLocalClient(t, bob)}
+
+		BroadcastAll("test_broadcast", "everyone")
+		for _, c := range clients {
+			if msg := nextEvent(t, c); msg.Type != "test_broadcast" {
+				t.Errorf("%s got %s, want the broadcast", c.userID, msg.Type)
+			}
+		}
+	})
+
+	t.Run("presence directory", func(t *testing.T) {
+		newInstance := newHub(t)
+		a, b := newInstance(ignore), newInstance(ignore)
+		userID, _ := newTestUser(t)
+		now := time.Now().Unix()
+
+		a.SetPresence(userID, presenceEntry{Instance: "a", Status: statusDND, StatusText: "busy", Seen: now})
+		if e, ok := b.LookupPresence(userID); !ok || e.Instance != "a" || e.Status != statusDND || e.StatusText != "busy" {
+			t.Errorf("the other instance sees %+v, %v", e, ok)
+		}
+		if _, ok := b.AllPresence()[userID]; !ok {
+			t.Error("missing from the other instance's list")
+		}
+		prev, existed, err := b.SetPresence(userID, presenceEntry{Instance: "b", Status: statusOnline, Seen: now})
+		if err != nil || !existed || prev.Instance != "a" {
+			t.Errorf("replaced %+v, %v, %v; want a's entry", prev, existed, err)
+		}
+
+		// A disconnect on a mustn't remove the newer connection on b
+		a.ReleasePresence(userID, "a")
+		if e, ok := a.LookupPresence(userID); !ok || e.Instance != "b" {
+			t.Errorf("after a released: %+v, %v; want b's entry", e, ok)
+		}
+		b.ReleasePresence(userID, "b")
+		if _, ok := a.LookupPresence(userID); ok {
+			t.Error("still listed after b released")
+		}
+
+		// One instance announces each change
+		away := presenceState{Status: statusAway, Text: "lunch"}
+		if prev, _ := a.SwapAnnounced(userID, away); prev.Status != statusOffline {
+			t.Errorf("first announcement replaced %+v", prev)
+		}
+		if prev, _ := b.SwapAnnounced(userID, away); prev != away {
+			t.Errorf("the other instance saw %+v announced, want %+v", prev, away)
+		}
+		b.SwapAnnounced(userID, presenceState{Status: statusOffline})
+		if prev, _ := a.SwapAnnounced(userID, away); prev.Status != statusOffline {
+			t.Errorf("after going offline, %+v was still announced", prev)
+		}
+		a.SwapAnnounced(userID, presenceState{Status: statusOffline})
+	})
+
+	t.Run("presence expiry", func(t *testing.T) {
+		newInstance := newHub(t)
+		crashed := newInstance(ignore)
+		useBackend(t, newInstance(ignore))
+		userID, _ := newTestUser(t)
+
+		// An instance that died without releasing its users stops refreshing them
+		old := time.Now().Add(-presenceStaleAfter - time.Minute).Unix()
+		crashed.SetPresence(userID, presenceEntry{Instance: "crashed", Status: statusOnline, Seen: old})
+		if _, ok := lookupPresence(userID); ok {
+			t.Error("a stale entry counts as connected")
+		}
+		if s := visiblePresence(userID); s.Status != statusOffline {
+			t.Errorf("a stale entry shows as %s", s.Status)
+		}
+		if sendToUser(userID, "test_event", "hello") {
+			t.Error("delivered to a stale entry")
+		}
+
+		// Connecting again replaces it without kicking the dead instance
+		c := newLocalClient(t, userID)
+		if _, existed := c.publishPresence(); existed {
+			t.Error("a stale entry was treated as another connection")
+		}
+		flushPresence()
+		if e, ok := lookupPresence(userID); !ok || e.Instance != instanceID {
+			t.Errorf("after reconnecting: %+v, %v", e, ok)
+		}
+	})
+
+	t.Run("kick", func(t *testing.T) {
+		newInstance := newHub(t)
+		useBackend(t, newInstance(receiveHubMessage))
+		other := newInstance(ignore)
+		userID, _ := newTestUser(t)
+		c := newLocalClient(t, userID)
+		backend.SetPresence(userID, c.presenceEntry())
+
+		// A kick for some other instance leaves this one's connection alone. The
+		// direct message after it shows it has been handled.
+		other.Publish(hubMessage{UserID: userID, Kick: "elsewhere"})
+		data, _ := json.Marshal(WSMessage{Type: "test_event"})
+		other.Publish(hubMessage{UserID: userID, Data: data})
+		if msg := nextEvent(t, c); msg.Type != "test_event" {
+			t.Fatalf("got %s, want the direct message", msg.Type)
+		}
+
+		// Logging in on the other instance, the way attach does
+		prev, existed, _ := other.SetPresence(userID, presenceEntry{Instance: "other", Status: statusOnline, Seen: time.Now().Unix()})
+		if !existed || prev.Instance != instanceID {
+			t.Fatalf("replaced %+v, %v; want this instance's entry", prev, existed)
+		}
+		other.Publish(hubMessage{UserID: userID, Kick: prev.Instance})
+		if msg := nextEvent(t, c); msg.Type != "force_logout" {
+			t.Errorf("got %s, want force_logout", msg.Type)
+		}
+		waitFor(t, 2*time.Second, "the connection is dropped", func() bool { return localClient(userID) == nil })
+		if _, open := <-c.send; open {
+			t.Error("the kicked connection is still open")
+		}
+
+		// The old connection going away keeps the new one listed
+		detach(c)
+		if e, ok := lookupPresence(userID); !ok || e.Instance != "other" {
+			t.Errorf("after the kicked connection ended: %+v, %v", e, ok)
+		}
+		flushPresence()
+	})
+}
+
+// useBackend makes b this instance's hub backend for the rest of the test.
+func useBackend(t *testing.T, b HubBackend) {
+	prev := backend
+	UseHubBackend(b)
+	t.Cleanup(func() { UseHubBackend(prev) })
+}
+
+func ignore(hubMessage) {}
+
+// recorder returns a hub message handler that passes on the messages for
+// userID.
+func recorder(userID string) (func(hubMessage), <-chan hubMessage) {
+	got := make(chan hubMessage, 16)
+	return func(m hubMessage) {
+		if m.UserID == userID {
+			got <- m
+		}
+	}, got
+}
+
+func nextHubMessage(t *testing.T, got <-chan hubMessage) hubMessage {
+	t.Helper()
+	select {
+	case m := <-got:
+		return m
+	case <-time.After(2 * time.Second):
+		t.Fatal("no hub message")
+		return hubMessage{}
+	}
+}
+
+// newLocalClient connects userID to this instance without a network
+// connection; the test reads its events from c.send.
+func newLocalClient(t *testing.T, userID string) *Client {
+	c := newClient(userID, nil)
+	hub.mu.Lock()
+	hub.clients[userID] = c
+	hub.mu.Unlock()
+	t.Cleanup(func() {
+		hub.mu.Lock()
+		if hub.clients[userID] == c {
+			delete(hub.clients, userID)
+		}
+		hub.mu.Unlock()
+		c.shutdown()
+		backend.ReleasePresence(userID, instanceID)
+	})
+	return c
+}
+
+func nextEvent(t *testing.T, c *Client) WSMessage {
+	t.Helper()
+	select {
+	case data, ok := <-c.send:
+		if !ok {
+			t.Fatal("connection closed")
+		}
+		var msg WSMessage
+		json.Unmarshal(data, &msg)
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+		return WSMessage{}
+	}
+}
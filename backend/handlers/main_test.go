@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+
+	"github.com/google/uuid"
+)
+
+// testDBPath is the database the tests run against.
+var testDBPath string
+
+// TestMain gives the tests a fresh database of their own.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "handlers-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	testDBPath = filepath.Join(dir, "forum.db")
+	db.Init(testDBPath)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestUser creates a user and a session for them, returning their ID and
+// session token.
+func newTestUser(t testing.TB) (userID, token string) {
+	t.Helper()
+	userID = uuid.NewString()
+	nickname := "user" + userID[:8]
+	err := db.CreateUser(models.User{
+		ID:        userID,
+		Nickname:  nickname,
+		FirstName: "Test",
+		LastName:  "User",
+		Email:     nickname + "@example.com",
+		Age:       30,
+		Gender:    "other",
+		Password:  "x",
+	})
+	if err != nil {
+		t.Fatal("create user:", err)
+	}
+	token = uuid.NewString()
+	if err := db.CreateSession(token, userID); err != nil {
+		t.Fatal("create session:", err)
+	}
+	return userID, token
+}
+
+// apiRequest calls handler as token's user, sending body as JSON unless it is
+// nil, and decodes a JSON response into out unless it is nil.
+func apiRequest(t *testing.T, handler http.HandlerFunc, method, path, token string, body, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, r)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Session-Token", token)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %d %s", method, path, rec.Code, rec.Body)
+		}
+	}
+	return rec
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+)
+
+// maxMentions caps how many @mentions in one text are resolved, so a comment
+// can't be used to notify the whole forum.
+const maxMentions = 20
+
+// mentionPattern matches "@nickname" at the start of the text or after
+// something other than a letter or digit, so e-mail addresses don't count.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_][\p{L}\p{N}_.\-]*)`)
+
+// findMentions returns the @nicknames in content that belong to a user. A
+// trailing "." or "-" is treated as punctuation unless it is part of the
+// nickname.
+func findMentions(content string) []models.Mention {
+	matches := mentionPattern.FindAllStringSubmatchIndex(content, maxMentions)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	var candidates []string
+	for _, m := range matches {
+		nick := content[m[2]:m[3]]
+		candidates = append(candidates, nick, strings.TrimRight(nick, ".-"))
+	}
+	users, err := db.UsersByNicknames(candidates)
+	if err != nil {
+		log.Println("resolve mentions error:", err)
+		return nil
+	}
+
+	var mentions []models.Mention
+	for _, m := range matches {
+		nick := content[m[2]:m[3]]
+		u, ok := users[strings.ToLower(nick)]
+		if !ok {
+			nick = strings.TrimRight(nick, ".-")
+			if u, ok = users[strings.ToLower(nick)]; !ok {
+				continue
+			}
+		}
+		start := utf8.RuneCountInString(content[:m[2]-1]) // include the "@"
+		mentions = append(mentions, models.Mention{
+			UserID:   u.ID,
+			Nickname: u.Nickname,
+			Start:    start,
+			End:      start + 1 + utf8.RuneCountInString(nick),
+		})
+	}
+	return mentions
+}
+
+// saveMentions stores the mentions in a post, comment or message's content and
+// returns them.
+func saveMentions(kind, sourceID, content string) []models.Mention {
+	mentions := findMentions(content)
+	if err := db.SaveMentions(kind, sourceID, mentions); err != nil {
+		log.Println("save mentions error:", err)
+	}
+	return mentions
+}
+
+// mentionedUsers returns each user mentioned once.
+func mentionedUsers(mentions []models.Mention) []string {
+	seen := map[string]bool{}
+	var ids []string
+	for _, m := range mentions {
+		if !seen[m.UserID] {
+			seen[m.UserID] = true
+			ids = append(ids, m.UserID)
+		}
+	}
+	return ids
+}
+
+// MentionSuggestions autocompletes nicknames for "@" mentions: ?q=prefix
+// returns up to limit users whose nickname starts with it.
+func MentionSuggestions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	prefix := strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("q")), "@")
+	if prefix == "" {
+		jsonOK(w, http.StatusOK, []db.UserRef{})
+		return
+	}
+	limit := boundedInt(r.URL.Query().Get("limit"), 8, 1, 20)
+
+	matches, err := db.SearchNicknames(prefix, userID, limit)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, matches)
+}
@@ -1,10 +1,28 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"real-time-forum/db"
+	"real-time-forum/models"
+)
+
+// messageEditWindow is how long after sending a message its author may edit it.
+const messageEditWindow = 15 * time.Minute
+
+var (
+	errMessageNotFound  = errors.New("message not found")
+	errMessageForbidden = errors.New("forbidden")
+	errEditWindowClosed = fmt.Errorf("messages can only be edited within %d minutes", int(messageEditWindow.Minutes()))
+	errMessageUnsent    = errors.New("message was unsent")
+	errMessageEmpty     = errors.New("message must have content or an image")
 )
 
 func Messages(w http.ResponseWriter, r *http.Request) {
@@ -25,18 +43,283 @@ func Messages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := r.URL.Query().Get("before")
+	after := r.URL.Query().Get("after")
+	if before != "" && after != "" {
+		jsonError(w, "use either before or after, not both", http.StatusBadRequest)
+		return
+	}
+	limit := boundedInt(r.URL.Query().Get("limit"), 20, 1, 100)
+
+	msgs, hasMore, err := db.GetMessages(myID, withID, before, after, limit)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "cursor message not found", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonOK(w, http.StatusOK, map[string]any{
+		"messages": msgs,
+		"has_more": hasMore,
+	})
+}
+
+// SearchMessages runs a full-text search over the caller's private messages.
+// ?q= is required; ?with= restricts it to one conversation.
+func SearchMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	myID := userIDFromSession(r)
+	if myID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		jsonError(w, "q parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := boundedInt(r.URL.Query().Get("limit"), 20, 1, 50)
 	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
 	if offset < 0 {
 		offset = 0
 	}
 
-	msgs, err := db.GetMessages(myID, withID, 10, offset)
+	hits, err := db.SearchMessages(myID, r.URL.Query().Get("with"), q, limit, offset)
 	if err != nil {
 		jsonError(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	jsonOK(w, http.StatusOK, msgs)
+	jsonOK(w, http.StatusOK, hits)
+}
+
+// MessageContext returns a search hit (?id=) with ?n= messages before and after
+// it, so the chat can jump to the message inside its conversation.
+func MessageContext(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	myID := userIDFromSession(r)
+	if myID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	msgID := r.URL.Query().Get("id")
+	if msgID == "" {
+		jsonError(w, "id parameter is required", http.StatusBadRequest)
+		return
+	}
+	n := boundedInt(r.URL.Query().Get("n"), 10, 0, 50)
+
+	msgs, moreBefore, moreAfter, err := db.GetMessageContext(myID, msgID, n)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonOK(w, http.StatusOK, map[string]any{
+		"target_id":       msgID,
+		"messages":        msgs,
+		"has_more_before": moreBefore,
+		"has_more_after":  moreAfter,
+	})
+}
+
+// boundedInt parses a query parameter, falling back to def when it is missing
+// or invalid and clamping the result to [lo, hi].
+func boundedInt(raw string, def, lo, hi int) int {
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return def
+	}
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
+
+func EditMessage(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := decodeMessageAction(w, r)
+	if !ok {
+		return
+	}
+	msg, err := editMessage(userID, req.MessageID, req.Content)
+	if err != nil {
+		messageActionError(w, err)
+		return
+	}
+	jsonOK(w, http.StatusOK, msg)
+}
+
+func UnsendMessage(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := decodeMessageAction(w, r)
+	if !ok {
+		return
+	}
+	if err := unsendMessage(userID, req.MessageID); err != nil {
+		messageActionError(w, err)
+		return
+	}
+	jsonOK(w, http.StatusOK, map[string]string{"message": "message unsent"})
+}
+
+func DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := decodeMessageAction(w, r)
+	if !ok {
+		return
+	}
+	if err := deleteMessageForMe(userID, req.MessageID); err != nil {
+		messageActionError(w, err)
+		return
+	}
+	jsonOK(w, http.StatusOK, map[string]string{"message": "message deleted"})
+}
+
+type messageActionRequest struct {
+	MessageID string `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+// decodeMessageAction handles the method, session and body checks shared by the
+// edit/unsend/delete endpoints. It writes the error response itself when !ok.
+func decodeMessageAction(w http.ResponseWriter, r *http.Request) (string, messageActionRequest, bool) {
+	var req messageActionRequest
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", req, false
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return "", req, false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == "" {
+		jsonError(w, "message_id is required", http.StatusBadRequest)
+		return "", req, false
+	}
+	return userID, req, true
+}
+
+func messageActionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errMessageNotFound):
+		jsonError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errMessageForbidden):
+		jsonError(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, errEditWindowClosed), errors.Is(err, errMessageUnsent), errors.Is(err, errMessageEmpty):
+		jsonError(w, err.Error(), http.StatusBadRequest)
+	default:
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// loadOwnMessage fetches a message that userID sent.
+func loadOwnMessage(userID, msgID string) (models.Message, error) {
+	msg, err := db.GetMessageByID(msgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return msg, errMessageNotFound
+	}
+	if err != nil {
+		return msg, err
+	}
+	if msg.SenderID != userID {
+		return msg, errMessageForbidden
+	}
+	if msg.Unsent {
+		return msg, errMessageUnsent
+	}
+	return msg, nil
+}
+
+// editMessage replaces the text of one of userID's messages and pushes
+// message_updated to both participants.
+func editMessage(userID, msgID, content string) (models.Message, error) {
+	msg, err := loadOwnMessage(userID, msgID)
+	if err != nil {
+		return msg, err
+	}
+
+	sentAt, err := time.Parse(time.RFC3339, msg.CreatedAt)
+	if err != nil || time.Since(sentAt) > messageEditWindow {
+		return msg, errEditWindowClosed
+	}
+
+	content = strings.TrimSpace(content)
+	if content == "" && len(msg.Attachments) == 0 {
+		return msg, errMessageEmpty
+	}
+
+	if err := db.UpdateMessageContent(msgID, content); err != nil {
+		return msg, err
+	}
+	saveMentions(db.MentionInMessage, msgID, content)
+	msg, err = db.GetMessageByID(msgID)
+	if err != nil {
+		return msg, err
+	}
+
+	sendToUser(msg.SenderID, "message_updated", msg)
+	sendToUser(msg.ReceiverID, "message_updated", msg)
+	return msg, nil
+}
+
+// unsendMessage removes one of userID's messages for both participants.
+func unsendMessage(userID, msgID string) error {
+	msg, err := loadOwnMessage(userID, msgID)
+	if err != nil {
+		return err
+	}
+	if err := db.UnsendMessage(msgID); err != nil {
+		return err
+	}
+
+	payload := messageDeletedEvent{MessageID: msgID, Scope: "everyone"}
+	sendToUser(msg.SenderID, "message_deleted", payload)
+	sendToUser(msg.ReceiverID, "message_deleted", payload)
+	return nil
+}
+
+// deleteMessageForMe hides a message from userID's side of the conversation.
+// Either participant may do this; the other side is unaffected.
+func deleteMessageForMe(userID, msgID string) error {
+	msg, err := db.GetMessageByID(msgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if msg.SenderID != userID && msg.ReceiverID != userID {
+		return errMessageNotFound
+	}
+	if err := db.DeleteMessageForUser(msgID, userID); err != nil {
+		return err
+	}
+
+	// Only the caller's own client needs to drop it
+	sendToUser(userID, "message_deleted", messageDeletedEvent{MessageID: msgID, Scope: "me"})
+	return nil
 }
 
 func Users(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+
+	"github.com/google/uuid"
+)
+
+// sendTestMessages stores n messages from senderID to receiverID, alternating
+// direction when back is set, and returns their IDs oldest first. They go in
+// faster than created_at's one-second precision, so most share a timestamp.
+func sendTestMessages(t *testing.T, senderID, receiverID string, n int, back bool) []string {
+	t.Helper()
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = uuid.NewString()
+		from, to := senderID, receiverID
+		if back && i%2 == 1 {
+			from, to = to, from
+		}
+		if err := db.CreateMessage(ids[i], from, to, fmt.Sprint("message ", i), ""); err != nil {
+			t.Fatal("create message:", err)
+		}
+	}
+	return ids
+}
+
+type messagePage struct {
+	Messages []models.Message `json:"messages"`
+	HasMore  bool             `json:"has_more"`
+	Error    string           `json:"error"`
+}
+
+func getMessages(t *testing.T, token, withID string, params url.Values) (int, messagePage) {
+	t.Helper()
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("with", withID)
+	var page messagePage
+	rec := apiRequest(t, Messages, http.MethodGet, "/api/messages?"+params.Encode(), token, nil, &page)
+	return rec.Code, page
+}
+
+func messageIDs(msgs []models.Message) []string {
+	ids := make([]string, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+func TestMessagesKeysetPaging(t *testing.T) {
+	aliceID, alice := newTestUser(t)
+	bobID, _ := newTestUser(t)
+	ids := sendTestMessages(t, aliceID, bobID, 25, true)
+
+	tests := []struct {
+		name    string
+		params  url.Values
+		want    []string
+		hasMore bool
+	}{
+		{"newest", url.Values{"limit": {"10"}}, ids[15:], true},
+		{"before", url.Values{"limit": {"10"}, "before": {ids[15]}}, ids[5:15], true},
+		{"before, last page", url.Values{"limit": {"10"}, "before": {ids[5]}}, ids[:5], false},
+		{"before, exactly a page left", url.Values{"limit": {"5"}, "before": {ids[5]}}, ids[:5], false},
+		{"before the oldest", url.Values{"before": {ids[0]}}, nil, false},
+		{"after", url.Values{"limit": {"10"}, "after": {ids[4]}}, ids[5:15], true},
+		{"after, exactly a page left", url.Values{"limit": {"10"}, "after": {ids[14]}}, ids[15:], false},
+		{"after the newest", url.Values{"after": {ids[24]}}, nil, false},
+		{"limit below 1", url.Values{"limit": {"0"}}, ids[24:], true},
+		{"limit not a number", url.Values{"limit": {"lots"}}, ids[5:], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, page := getMessages(t, alice, bobID, tt.params)
+			if code != http.StatusOK {
+				t.Fatalf("%d %s", code, page.Error)
+			}
+			if got := messageIDs(page.Messages); !slices.Equal(got, tt.want) {
+				t.Errorf("got %d messages %v, want %d %v", len(got), got, len(tt.want), tt.want)
+			}
+			if page.HasMore != tt.hasMore {
+				t.Errorf("has_more %v, want %v", page.HasMore, tt.hasMore)
+			}
+		})
+	}
+
+	t.Run("limit above 100", func(t *testing.T) {
+		more := sendTestMessages(t, aliceID, bobID, 100, false)
+		_, page := getMessages(t, alice, bobID, url.Values{"limit": {"1000"}})
+		if got := messageIDs(page.Messages); !slices.Equal(got, more) || !page.HasMore {
+			t.Errorf("got %d messages, has_more %v; want the newest 100 and more", len(got), page.HasMore)
+		}
+	})
+}
+
+func TestMessagesWalkBothWays(t *testing.T) {
+	aliceID, alice := newTestUser(t)
+	bobID, _ := newTestUser(t)
+	ids := sendTestMessages(t, aliceID, bobID, 23, true)
+
+	// Paging back from the newest and forward from the oldest each see every
+	// message exactly once, even where timestamps tie
+	var back []string
+	params := url.Values{"limit": {"4"}}
+	for {
+		_, page := getMessages(t, alice, bobID, params)
+		back = append(messageIDs(page.Messages), back...)
+		if !page.HasMore {
+			break
+		}
+		params.Set("before", page.Messages[0].ID)
+	}
+	if !slices.Equal(back, ids) {
+		t.Errorf("paging back got %v, want %v", back, ids)
+	}
+
+	forward := []string{ids[0]}
+	params = url.Values{"limit": {"4"}, "after": {ids[0]}}
+	for {
+		_, page := getMessages(t, alice, bobID, params)
+		forward = append(forward, messageIDs(page.Messages)...)
+		if !page.HasMore {
+			break
+		}
+		params.Set("after", page.Messages[len(page.Messages)-1].ID)
+	}
+	if !slices.Equal(forward, ids) {
+		t.Errorf("paging forward got %v, want %v", forward, ids)
+	}
+}
+
+func TestMessagesBadCursor(t *testing.T) {
+	aliceID, alice := newTestUser(t)
+	bobID, _ := newTestUser(t)
+	carolID, _ := newTestUser(t)
+	ids := sendTestMessages(t, aliceID, bobID, 3, false)
+	other := sendTestMessages(t, aliceID, carolID, 1, false)
+	db.DeleteMessageForUser(ids[1], aliceID)
+
+	for _, tt := range []struct {
+		name   string
+		params url.Values
+	}{
+		{"unknown", url.Values{"before": {uuid.NewString()}}},
+		{"other conversation", url.Values{"after": {other[0]}}},
+		{"deleted for me", url.Values{"before": {ids[1]}}},
+		{"both directions", url.Values{"before": {ids[2]}, "after": {ids[0]}}},
+	} {
+		if code, _ := getMessages(t, alice, bobID, tt.params); code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", tt.name, code)
+		}
+	}
+
+	// A message deleted for me is skipped, not a boundary
+	_, page := getMessages(t, alice, bobID, nil)
+	if got := messageIDs(page.Messages); !slices.Equal(got, []string{ids[0], ids[2]}) {
+		t.Errorf("got %v, want the messages not deleted", got)
+	}
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+
+	"github.com/google/uuid"
+)
+
+// Notification types. Each can be switched off in the user's preferences.
+const (
+	notifyComment  = "comment"  // someone commented on your post
+	notifyReply    = "reply"    // someone commented on a post you watch
+	notifyMention  = "mention"  // someone mentioned you
+	notifyVote     = "vote"     // someone upvoted your post
+	notifyPost     = "post"     // someone you follow posted
+	notifyCategory = "category" // someone posted in a category you subscribed to
+)
+
+var notificationTypes = []string{notifyComment, notifyReply, notifyMention, notifyVote, notifyPost, notifyCategory}
+
+// notify tells userID that actorID did something of type typ on postID, unless
+// they did it themselves or have that type switched off. Users in do not
+// disturb only get it stored; their client loads what came in once they
+// leave do not disturb.
+func notify(userID, typ, postID, commentID, actorID string) {
+	if userID == actorID || !db.NotificationEnabled(userID, typ) {
+		return
+	}
+	id, changed, err := db.AddNotification(uuid.NewString(), userID, typ, postID, commentID, actorID)
+	if err != nil {
+		log.Println("add notification error:", err)
+		return
+	}
+	if !changed || isDND(userID) {
+		return
+	}
+	n, err := db.GetNotification(userID, id)
+	if err != nil {
+		log.Println("get notification error:", err)
+		return
+	}
+	n.Text = notificationText(n)
+	sendToUser(userID, "notification", notificationEvent{
+		Notification: n,
+		Unread:       db.CountUnreadNotifications(userID),
+	})
+}
+
+// notificationText describes a notification, counting people once several
+// have done the same thing: "5 people upvoted your post".
+func notificationText(n models.Notification) string {
+	who := n.ActorName
+	if n.ActorCount > 1 {
+		who = fmt.Sprintf("%d people", n.ActorCount)
+	}
+	switch n.Type {
+	case notifyComment:
+		return who + " commented on your post"
+	case notifyReply:
+		return who + " commented on a post you're watching"
+	case notifyMention:
+		return who + " mentioned you"
+	case notifyVote:
+		return who + " upvoted your post"
+	case notifyPost:
+		return who + " posted"
+	case notifyCategory:
+		return who + " posted in a category you follow"
+	}
+	return who + " did something"
+}
+
+func Notifications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := boundedInt(r.URL.Query().Get("limit"), 20, 1, 100)
+	list, hasMore, err := db.ListNotifications(userID, r.URL.Query().Get("before"), limit)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "cursor notification not found", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	for i := range list {
+		list[i].Text = notificationText(list[i])
+	}
+
+	jsonOK(w, http.StatusOK, map[string]any{
+		"notifications": list,
+		"unread":        db.CountUnreadNotifications(userID),
+		"has_more":      hasMore,
+	})
+}
+
+// MarkNotificationsRead marks the notifications in {"ids": [...]} as read.
+func MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.IDs) == 0 {
+		jsonError(w, "ids are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) > 100 {
+		jsonError(w, "too many ids", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.MarkNotificationsRead(userID, req.IDs); err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, map[string]int{"unread": db.CountUnreadNotifications(userID)})
+}
+
+func MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := db.MarkAllNotificationsRead(userID); err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, map[string]int{"unread": 0})
+}
+
+// NotificationPreferences returns which notification types are on. POSTing a
+// partial map such as {"vote": false} changes those types.
+func NotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req map[string]bool
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		for typ := range req {
+			if !slices.Contains(notificationTypes, typ) {
+				jsonError(w, "unknown notification type "+typ, http.StatusBadRequest)
+				return
+			}
+		}
+		for typ, enabled := range req {
+			if err := db.SetNotificationPref(userID, typ, enabled); err != nil {
+				jsonError(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stored, err := db.NotificationPrefs(userID)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	prefs := make(map[string]bool, len(notificationTypes))
+	for _, typ := range notificationTypes {
+		enabled, set := stored[typ]
+		prefs[typ] = enabled || !set
+	}
+	jsonOK(w, http.StatusOK, prefs)
+}
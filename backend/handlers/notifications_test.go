@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+
+	"github.com/google/uuid"
+)
+
+func createTestPost(t *testing.T, userID string) string {
+	t.Helper()
+	id := uuid.NewString()
+	if err := db.CreatePost(id, userID, "a post", "content", "go"); err != nil {
+		t.Fatal("create post:", err)
+	}
+	return id
+}
+
+// notificationEvents returns the notification events queued for c.
+func notificationEvents(t *testing.T, c *Client) []notificationEvent {
+	t.Helper()
+	var events []notificationEvent
+	for len(c.send) > 0 {
+		if msg := nextEvent(t, c); msg.Type == "notification" {
+			var e notificationEvent
+			json.Unmarshal(msg.Payload, &e)
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+type notificationPage struct {
+	Notifications []models.Notification `json:"notifications"`
+	Unread        int                   `json:"unread"`
+}
+
+func listNotifications(t *testing.T, token string) notificationPage {
+	t.Helper()
+	var page notificationPage
+	if rec := apiRequest(t, Notifications, http.MethodGet, "/api/notifications", token, nil, &page); rec.Code != http.StatusOK {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	return page
+}
+
+func TestNotificationAggregation(t *testing.T) {
+	authorID, author := newTestUser(t)
+	c := newLocalClient(t, authorID)
+	postID := createTestPost(t, authorID)
+	voters := make([]string, 3)
+	for i := range voters {
+		voters[i], _ = newTestUser(t)
+	}
+
+	// Votes on one post fold into one notification naming the latest voter
+	for _, voterID := range voters {
+		notify(authorID, notifyVote, postID, "", voterID)
+	}
+	events := notificationEvents(t, c)
+	if len(events) != len(voters) {
+		t.Fatalf("got %d events, want one per vote", len(events))
+	}
+	last := events[len(events)-1]
+	if last.Notification.ID != events[0].Notification.ID {
+		t.Error("a later vote made a new notification")
+	}
+	if n := last.Notification; n.ActorCount != 3 || n.ActorID != voters[2] || n.Text != "3 people upvoted your post" {
+		t.Errorf("got %d actors, latest %s, %q", n.ActorCount, n.ActorID, n.Text)
+	}
+	if last.Unread != 1 {
+		t.Errorf("%d unread, want 1", last.Unread)
+	}
+
+	// Voting again changes nothing and isn't announced
+	notify(authorID, notifyVote, postID, "", voters[0])
+	if events := notificationEvents(t, c); len(events) != 0 {
+		t.Errorf("a repeated vote sent %d events", len(events))
+	}
+
+	// Someone counted already commenting again is news, but not another person
+	commenterID, _ := newTestUser(t)
+	for range 2 {
+		notify(authorID, notifyComment, postID, uuid.NewString(), commenterID)
+	}
+	events = notificationEvents(t, c)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want one per comment", len(events))
+	}
+	comment := events[1].Notification
+	if comment.ID == last.Notification.ID {
+		t.Error("a comment was folded into the votes")
+	}
+	if comment.ActorCount != 1 || comment.Text != "user"+commenterID[:8]+" commented on your post" {
+		t.Errorf("got %d actors, %q", comment.ActorCount, comment.Text)
+	}
+
+	// Another post has its own
+	otherPostID := createTestPost(t, authorID)
+	notify(authorID, notifyVote, otherPostID, "", voters[0])
+	if events := notificationEvents(t, c); len(events) != 1 || events[0].Notification.ActorCount != 1 {
+		t.Errorf("a vote on another post sent %+v", events)
+	}
+	page := listNotifications(t, author)
+	if len(page.Notifications) != 3 || page.Unread != 3 {
+		t.Fatalf("got %d notifications, %d unread; want 3 and 3", len(page.Notifications), page.Unread)
+	}
+	if n := page.Notifications[2]; n.ID != last.Notification.ID || n.Text != "3 people upvoted your post" {
+		t.Errorf("the list has %+v for the votes", n)
+	}
+
+	// Once read, the next vote starts a new notification
+	if err := db.MarkNotificationsRead(authorID, []string{last.Notification.ID}); err != nil {
+		t.Fatal(err)
+	}
+	notify(authorID, notifyVote, postID, "", voters[1])
+	events = notificationEvents(t, c)
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want one", len(events))
+	}
+	if n := events[0].Notification; n.ID == last.Notification.ID || n.ActorCount != 1 || n.Read {
+		t.Errorf("after reading, a vote gave %+v", n)
+	}
+}
+
+func TestNotifySelf(t *testing.T) {
+	authorID, author := newTestUser(t)
+	postID := createTestPost(t, authorID)
+
+	notify(authorID, notifyComment, postID, uuid.NewString(), authorID)
+	if page := listNotifications(t, author); len(page.Notifications) != 0 {
+		t.Errorf("notified about their own comment: %+v", page.Notifications)
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxPollOptions   = 10
+	maxPollOptionLen = 100
+)
+
+// preparePoll checks a poll a post is being published with at opensAt and
+// returns its options with fresh IDs and when it closes (zero for never). msg
+// says what is wrong with it when it can't be created.
+func preparePoll(spec *models.PollSpec, opensAt time.Time) (options []models.PollOption, closesAt time.Time, msg string) {
+	seen := map[string]bool{}
+	for _, text := range spec.Options {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if utf8.RuneCountInString(text) > maxPollOptionLen {
+			return nil, time.Time{}, "poll options can be at most 100 characters"
+		}
+		key := strings.ToLower(text)
+		if seen[key] {
+			return nil, time.Time{}, "poll options must all be different"
+		}
+		seen[key] = true
+		options = append(options, models.PollOption{ID: uuid.NewString(), Text: text})
+	}
+	if len(options) < 2 || len(options) > maxPollOptions {
+		return nil, time.Time{}, "a poll needs between 2 and 10 options"
+	}
+
+	if spec.ClosesAt != "" {
+		t, err := time.Parse(time.RFC3339, spec.ClosesAt)
+		if err != nil {
+			return nil, time.Time{}, "poll closes_at must be an RFC 3339 time"
+		}
+		if !t.After(opensAt) {
+			return nil, time.Time{}, "poll closes_at must be after the post is published"
+		}
+		closesAt = t
+	}
+	return options, closesAt, ""
+}
+
+// createPoll attaches spec, already checked with preparePoll, to postID.
+func createPoll(postID string, spec *models.PollSpec) error {
+	options, closesAt, msg := preparePoll(spec, time.Now())
+	if msg != "" {
+		return errors.New(msg)
+	}
+	return db.CreatePoll(postID, options, spec.Multiple, spec.Anonymous, closesAt)
+}
+
+// Poll returns the results of the poll on ?post_id=, with the caller's own
+// votes.
+func Poll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	poll, err := db.GetPoll(userID, r.URL.Query().Get("post_id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "poll not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, poll)
+}
+
+// PollVote takes {"post_id": "...", "option_ids": [...]} and replaces the
+// caller's votes on the post's poll; an empty list takes the vote back.
+// Everyone looking at the post gets the new results as a poll_update.
+func PollVote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		PostID    string   `json:"post_id"`
+		OptionIDs []string `json:"option_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PostID == "" {
+		jsonError(w, "post_id is required", http.StatusBadRequest)
+		return
+	}
+
+	poll, err := db.GetPoll(userID, req.PostID)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "poll not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if poll.Closed {
+		jsonError(w, "poll is closed", http.StatusConflict)
+		return
+	}
+
+	slices.Sort(req.OptionIDs)
+	choices := slices.Compact(req.OptionIDs)
+	for _, id := range choices {
+		if !slices.ContainsFunc(poll.Options, func(o models.PollOption) bool { return o.ID == id }) {
+			jsonError(w, "option not in this poll", http.StatusBadRequest)
+			return
+		}
+	}
+	if !poll.Multiple && len(choices) > 1 {
+		jsonError(w, "this poll allows a single choice", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.SetPollVotes(req.PostID, userID, choices); err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	poll, err = db.GetPoll(userID, req.PostID)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	results := poll
+	results.MyVotes = nil
+	publish([]string{postTopic(req.PostID)}, "poll_update", results)
+	jsonOK(w, http.StatusOK, poll)
+}
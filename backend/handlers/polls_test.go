@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+)
+
+func TestPreparePoll(t *testing.T) {
+	opensAt := time.Now()
+	eleven := strings.Split("a b c d e f g h i j k", " ")
+	tests := []struct {
+		name string
+		spec models.PollSpec
+		want string
+	}{
+		{"ok", models.PollSpec{Options: []string{"yes", "no"}, ClosesAt: opensAt.Add(time.Hour).Format(time.RFC3339)}, ""},
+		{"blank options dropped", models.PollSpec{Options: []string{"yes", "  ", "no"}}, ""},
+		{"one option", models.PollSpec{Options: []string{"yes", ""}}, "a poll needs between 2 and 10 options"},
+		{"too many options", models.PollSpec{Options: eleven}, "a poll needs between 2 and 10 options"},
+		{"duplicates", models.PollSpec{Options: []string{"Yes", " yes "}}, "poll options must all be different"},
+		{"option too long", models.PollSpec{Options: []string{"yes", strings.Repeat("é", 101)}}, "poll options can be at most 100 characters"},
+		{"bad closes_at", models.PollSpec{Options: []string{"yes", "no"}, ClosesAt: "tomorrow"}, "poll closes_at must be an RFC 3339 time"},
+		{"closes before it opens", models.PollSpec{Options: []string{"yes", "no"}, ClosesAt: opensAt.Add(-time.Hour).Format(time.RFC3339)}, "poll closes_at must be after the post is published"},
+	}
+	for _, tt := range tests {
+		options, _, msg := preparePoll(&tt.spec, opensAt)
+		if msg != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, msg, tt.want)
+		}
+		if msg == "" && len(options) != 2 {
+			t.Errorf("%s: got %d options, want 2", tt.name, len(options))
+		}
+	}
+}
+
+// createTestPoll publishes a post by userID with a poll on it.
+func createTestPoll(t *testing.T, userID string, spec models.PollSpec) models.Poll {
+	t.Helper()
+	postID := createTestPost(t, userID)
+	if err := createPoll(postID, &spec); err != nil {
+		t.Fatal("create poll:", err)
+	}
+	poll, err := db.GetPoll(userID, postID)
+	if err != nil {
+		t.Fatal("get poll:", err)
+	}
+	return poll
+}
+
+func vote(t *testing.T, token, postID string, optionIDs ...string) (int, models.Poll) {
+	t.Helper()
+	var poll models.Poll
+	body := map[string]any{"post_id": postID, "option_ids": optionIDs}
+	rec := apiRequest(t, PollVote, http.MethodPost, "/api/polls/vote", token, body, &poll)
+	slices.Sort(poll.MyVotes)
+	return rec.Code, poll
+}
+
+func optionVotes(poll models.Poll) []int {
+	votes := make([]int, len(poll.Options))
+	for i, o := range poll.Options {
+		votes[i] = o.Votes
+	}
+	return votes
+}
+
+func TestPollVoteSingleChoice(t *testing.T) {
+	authorID, _ := newTestUser(t)
+	_, alice := newTestUser(t)
+	_, bob := newTestUser(t)
+	poll := createTestPoll(t, authorID, models.PollSpec{Options: []string{"red", "green", "blue"}})
+	red, green, blue := poll.Options[0].ID, poll.Options[1].ID, poll.Options[2].ID
+
+	if code, _ := vote(t, alice, poll.PostID, red, green); code != http.StatusBadRequest {
+		t.Errorf("two choices: %d, want 400", code)
+	}
+	// Sending the same option twice is still one choice
+	if code, got := vote(t, alice, poll.PostID, red, red); code != http.StatusOK || !slices.Equal(got.MyVotes, []string{red}) {
+		t.Errorf("the same option twice: %d, voted %v", code, got.MyVotes)
+	}
+	vote(t, bob, poll.PostID, red)
+
+	// Voting again replaces the vote
+	_, got := vote(t, alice, poll.PostID, blue)
+	if !slices.Equal(optionVotes(got), []int{1, 0, 1}) || got.TotalVoters != 2 || !slices.Equal(got.MyVotes, []string{blue}) {
+		t.Errorf("after changing the vote: %v from %d voters, mine %v", optionVotes(got), got.TotalVoters, got.MyVotes)
+	}
+
+	// An empty list takes it back
+	_, got = vote(t, alice, poll.PostID)
+	if !slices.Equal(optionVotes(got), []int{1, 0, 0}) || got.TotalVoters != 1 || len(got.MyVotes) != 0 {
+		t.Errorf("after taking it back: %v from %d voters, mine %v", optionVotes(got), got.TotalVoters, got.MyVotes)
+	}
+}
+
+func TestPollVoteMultipleChoice(t *testing.T) {
+	authorID, _ := newTestUser(t)
+	_, alice := newTestUser(t)
+	poll := createTestPoll(t, authorID, models.PollSpec{Options: []string{"red", "green", "blue"}, Multiple: true})
+	red, blue := poll.Options[0].ID, poll.Options[2].ID
+
+	code, got := vote(t, alice, poll.PostID, blue, red)
+	want := []string{red, blue}
+	slices.Sort(want)
+	if code != http.StatusOK || !slices.Equal(got.MyVotes, want) {
+		t.Fatalf("%d, voted %v", code, got.MyVotes)
+	}
+	if !slices.Equal(optionVotes(got), []int{1, 0, 1}) || got.TotalVoters != 1 {
+		t.Errorf("got %v from %d voters; one person counts once", optionVotes(got), got.TotalVoters)
+	}
+}
+
+func TestPollVoteRejected(t *testing.T) {
+	authorID, _ := newTestUser(t)
+	_, alice := newTestUser(t)
+	poll := createTestPoll(t, authorID, models.PollSpec{Options: []string{"yes", "no"}})
+	other := createTestPoll(t, authorID, models.PollSpec{Options: []string{"yes", "no"}})
+
+	closedID := createTestPost(t, authorID)
+	closed := []models.PollOption{{ID: closedID + "-yes", Text: "yes"}, {ID: closedID + "-no", Text: "no"}}
+	if err := db.CreatePoll(closedID, closed, false, false, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal("create poll:", err)
+	}
+
+	tests := []struct {
+		name      string
+		postID    string
+		optionIDs []string
+		want      int
+	}{
+		{"another poll's option", poll.PostID, []string{other.Options[0].ID}, http.StatusBadRequest},
+		{"no poll", authorID, []string{poll.Options[0].ID}, http.StatusNotFound},
+		{"closed", closedID, []string{closed[0].ID}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if code, _ := vote(t, alice, tt.postID, tt.optionIDs...); code != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	if p, _ := db.GetPoll(authorID, poll.PostID); p.TotalVoters != 0 {
+		t.Errorf("%d votes counted", p.TotalVoters)
+	}
+}
+
+func TestPollVoters(t *testing.T) {
+	authorID, author := newTestUser(t)
+	aliceID, alice := newTestUser(t)
+
+	for _, anonymous := range []bool{false, true} {
+		poll := createTestPoll(t, authorID, models.PollSpec{Options: []string{"yes", "no"}, Anonymous: anonymous})
+		yes := poll.Options[0].ID
+		vote(t, alice, poll.PostID, yes)
+
+		// The voter always sees their own vote
+		mine, _ := db.GetPoll(aliceID, poll.PostID)
+		if !slices.Equal(mine.MyVotes, []string{yes}) {
+			t.Errorf("anonymous %v: the voter sees %v as theirs", anonymous, mine.MyVotes)
+		}
+		var seen models.Poll
+		apiRequest(t, Poll, http.MethodGet, "/api/polls?post_id="+poll.PostID, author, nil, &seen)
+		voters := seen.Options[0].Voters
+		switch {
+		case anonymous && len(voters) != 0:
+			t.Errorf("an anonymous poll lists %v", voters)
+		case !anonymous && (len(voters) != 1 || voters[0].ID != aliceID):
+			t.Errorf("a public poll lists %v, want the voter", voters)
+		}
+		if seen.Options[0].Votes != 1 || len(seen.MyVotes) != 0 {
+			t.Errorf("anonymous %v: others see %d votes, theirs %v", anonymous, seen.Options[0].Votes, seen.MyVotes)
+		}
+	}
+}
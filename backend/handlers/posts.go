@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+	"time"
 
 	"real-time-forum/db"
+	"real-time-forum/models"
 
 	"github.com/google/uuid"
 )
@@ -41,17 +43,24 @@ func DeletePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ownerID, err := db.GetPostOwnerID(req.PostID)
-	if err != nil || ownerID == "" {
+	post, err := db.GetPostByID(req.PostID)
+	if err != nil {
 		jsonError(w, "post not found", http.StatusNotFound)
 		return
 	}
-	if ownerID != userID {
+	if post.UserID != userID {
 		jsonError(w, "forbidden", http.StatusForbidden)
 		return
 	}
 
-	db.DeletePostCascade(req.PostID)
+	if err := db.DeletePostCascade(req.PostID); err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	// Open post views watch the post's topic; feeds that haven't subscribed
+	// to the card yet still get it through the topics it was published to.
+	topics := append(postTopics(post.UserID, post.Category), postTopic(post.ID))
+	publish(topics, "post_deleted", postDeletedEvent{PostID: post.ID})
 	jsonOK(w, http.StatusOK, map[string]string{"message": "post deleted"})
 }
 
@@ -84,6 +93,21 @@ func listPosts(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		jsonOK(w, http.StatusOK, posts)
+	case "saved":
+		folder := strings.TrimSpace(r.URL.Query().Get("folder"))
+		posts, err := db.ListSavedPosts(userID, folder, orderBy)
+		if err != nil {
+			jsonError(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		jsonOK(w, http.StatusOK, posts)
+	case "following":
+		posts, err := db.ListFollowingPosts(userID, orderBy)
+		if err != nil {
+			jsonError(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		jsonOK(w, http.StatusOK, posts)
 	default:
 		if categories != "" {
 			posts, err := db.ListPostsByCategories(userID, strings.Split(categories, ","), orderBy)
@@ -111,10 +135,13 @@ func createPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Title      string   `json:"title"`
-		Content    string   `json:"content"`
-		Categories []string `json:"categories"`
-		ImageURL   string   `json:"image_url"`
+		Title       string              `json:"title"`
+		Content     string              `json:"content"`
+		Categories  []string            `json:"categories"`
+		Attachments []models.Attachment `json:"attachments"`
+		DraftID     string              `json:"draft_id"`
+		PublishAt   string              `json:"publish_at"`
+		Poll        *models.PollSpec    `json:"poll"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -123,39 +150,116 @@ func createPost(w http.ResponseWriter, r *http.Request) {
 
 	req.Title = strings.TrimSpace(req.Title)
 	req.Content = strings.TrimSpace(req.Content)
-	req.ImageURL = strings.TrimSpace(req.ImageURL)
+	category := strings.Join(cleanCategories(req.Categories), ",")
+
+	if msg := validatePost(req.Title, req.Content, category, len(req.Attachments) > 0); msg != "" {
+		jsonError(w, msg, http.StatusBadRequest)
+		return
+	}
+	attachments, msg := prepareAttachments(userID, req.Attachments)
+	if msg != "" {
+		jsonError(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	publishAt := time.Now()
+	if req.PublishAt != "" {
+		t, err := time.Parse(time.RFC3339, req.PublishAt)
+		if err != nil {
+			jsonError(w, "publish_at must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		if !t.After(publishAt) {
+			jsonError(w, "publish_at must be in the future", http.StatusBadRequest)
+			return
+		}
+		publishAt = t
+	}
+	if req.Poll != nil {
+		if _, _, msg := preparePoll(req.Poll, publishAt); msg != "" {
+			jsonError(w, msg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// A publish_at in the future keeps the post as a scheduled draft
+	if req.PublishAt != "" {
+		schedulePost(w, models.Draft{
+			ID:          req.DraftID,
+			UserID:      userID,
+			Title:       req.Title,
+			Content:     req.Content,
+			Category:    category,
+			Attachments: attachments,
+			Poll:        req.Poll,
+		}, publishAt)
+		return
+	}
+
+	post, err := publishPost(uuid.NewString(), userID, req.Title, req.Content, category, attachments, req.Poll)
+	if err != nil {
+		attachmentError(w, err)
+		return
+	}
+	if req.DraftID != "" {
+		db.DeleteDraft(userID, req.DraftID)
+	}
+	post.IsWatching = true
+	jsonOK(w, http.StatusCreated, post)
+}
 
+// cleanCategories trims the categories and drops empty ones.
+func cleanCategories(categories []string) []string {
 	cleaned := []string{}
-	for _, c := range req.Categories {
+	for _, c := range categories {
 		c = strings.TrimSpace(c)
 		if c != "" {
 			cleaned = append(cleaned, c)
 		}
 	}
+	return cleaned
+}
 
-	if req.Title == "" || len(cleaned) == 0 {
-		jsonError(w, "title and at least one category are required", http.StatusBadRequest)
-		return
+// validatePost returns why a post can't be published, or "" if it can.
+func validatePost(title, content, category string, hasImages bool) string {
+	if title == "" || category == "" {
+		return "title and at least one category are required"
 	}
-	
-	if req.Content == "" && req.ImageURL == "" {
-		jsonError(w, "post must have content or an image", http.StatusBadRequest)
-		return
+	if content == "" && !hasImages {
+		return "post must have content or an image"
 	}
+	return ""
+}
 
-	category := strings.Join(cleaned, ",")
-	id := uuid.NewString()
-	if err := db.CreatePost(id, userID, req.Title, req.Content, category, req.ImageURL); err != nil {
-		jsonError(w, "internal server error", http.StatusInternalServerError)
-		return
+// publishPost creates a post with its attachments, and a poll when there is
+// one, tells everyone subscribed to it and returns it. If the attachments or
+// poll can't be added the post is deleted again, so a retry doesn't leave a
+// duplicate behind.
+func publishPost(id, userID, title, content, category string, attachments []models.Attachment, poll *models.PollSpec) (models.Post, error) {
+	if err := db.CreatePost(id, userID, title, content, category); err != nil {
+		return models.Post{}, err
+	}
+	if err := db.SetAttachments(userID, db.AttachedToPost, id, attachments); err != nil {
+		db.DeletePostCascade(id)
+		return models.Post{}, err
 	}
+	if poll != nil {
+		if err := createPoll(id, poll); err != nil {
+			db.DeletePostCascade(id)
+			return models.Post{}, err
+		}
+	}
+	saveMentions(db.MentionInPost, id, content)
+	autoWatch(userID, id)
 
 	post, err := db.GetPostByID(id)
 	if err != nil {
-		jsonError(w, "internal server error", http.StatusInternalServerError)
-		return
+		return models.Post{}, err
 	}
 
-	BroadcastAll("new_post", post)
-	jsonOK(w, http.StatusCreated, post)
-}
\ No newline at end of file
+	publishOrBroadcast(postTopics(post.UserID, post.Category), "new_post", post)
+	// Popular authors and categories can have a lot of followers; don't hold
+	// up the response
+	go notifyNewPost(post)
+	return post, nil
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"real-time-forum/db"
+)
+
+const (
+	// presenceFlushInterval is how long presence changes are collected before
+	// being broadcast, so a burst of connects and disconnects goes out as one event.
+	presenceFlushInterval = 250 * time.Millisecond
+
+	// presenceIdleAfter is how long a connected user can go without activity
+	// before being shown as away.
+	presenceIdleAfter = 5 * time.Minute
+
+	// presenceSweepInterval is how often idle users are checked and last_seen_at
+	// is refreshed for everyone online.
+	presenceSweepInterval = 30 * time.Second
+
+	// presenceStaleAfter is when a directory entry that hasn't been refreshed
+	// by its instance's sweep is treated as offline, e.g. after a crash.
+	presenceStaleAfter = 3 * presenceSweepInterval
+
+	maxStatusTextLen = 80
+)
+
+// Presence statuses. A user picks online, away, dnd or invisible; offline is
+// what everyone else sees while they're disconnected or invisible.
+const (
+	statusOnline    = "online"
+	statusAway      = "away"
+	statusDND       = "dnd"
+	statusInvisible = "invisible"
+	statusOffline   = "offline"
+)
+
+var selectableStatuses = map[string]bool{
+	statusOnline:    true,
+	statusAway:      true,
+	statusDND:       true,
+	statusInvisible: true,
+}
+
+type UserStatus struct {
+	ID          string `json:"id"`
+	Nickname    string `json:"nickname"`
+	Online      bool   `json:"online"`
+	Status      string `json:"status"`
+	StatusText  string `json:"status_text"`
+	LastSeenAt  string `json:"last_seen_at"`
+	LastMsg     string `json:"last_msg"`
+	UnreadCount int    `json:"unread_count"`
+}
+
+// PresenceChange is one entry of a presence_update event.
+type PresenceChange struct {
+	UserID     string `json:"user_id"`
+	Nickname   string `json:"nickname"`
+	Online     bool   `json:"online"`
+	Status     string `json:"status"`
+	StatusText string `json:"status_text"`
+	LastSeenAt string `json:"last_seen_at"`
+}
+
+// presenceState is what other users currently see for someone.
+type presenceState struct {
+	Status string `json:"status"`
+	Text   string `json:"text,omitempty"`
+}
+
+// visible is how the user behind e appears to other users.
+func (e presenceEntry) visible() presenceState {
+	switch {
+	case e.Status == statusInvisible:
+		return presenceState{Status: statusOffline}
+	case e.Status == statusOnline && e.Idle:
+		return presenceState{Status: statusAway, Text: e.StatusText}
+	default:
+		return presenceState{Status: e.Status, Text: e.StatusText}
+	}
+}
+
+func (e presenceEntry) stale() bool {
+	return time.Since(time.Unix(e.Seen, 0)) > presenceStaleAfter
+}
+
+// lookupPresence returns userID's directory entry if they are connected to any
+// instance.
+func lookupPresence(userID string) (presenceEntry, bool) {
+	e, ok := backend.LookupPresence(userID)
+	return e, ok && !e.stale()
+}
+
+// visiblePresence looks up how userID currently appears to other users.
+func visiblePresence(userID string) presenceState {
+	if e, ok := lookupPresence(userID); ok {
+		return e.visible()
+	}
+	return presenceState{Status: statusOffline}
+}
+
+// appearsOnline reports whether other users can see userID is connected. An
+// invisible user doesn't, so nothing they do may give them away: no delivery
+// or read receipts and no typing indicators.
+func appearsOnline(userID string) bool {
+	return visiblePresence(userID).Status != statusOffline
+}
+
+// invisible reports whether c's user has chosen to appear offline.
+func (c *Client) invisible() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status == statusInvisible
+}
+
+// presenceEntry describes c for the shared presence directory.
+func (c *Client) presenceEntry() presenceEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return presenceEntry{
+		Instance:   instanceID,
+		Status:     c.status,
+		StatusText: c.statusText,
+		Idle:       c.idle,
+		Seen:       time.Now().Unix(),
+	}
+}
+
+// publishPresence writes c's current state to the directory and schedules a
+// presence_update. It returns the entry that was replaced, if any.
+func (c *Client) publishPresence() (prev presenceEntry, existed bool) {
+	prev, existed, err := backend.SetPresence(c.userID, c.presenceEntry())
+	if err != nil {
+		log.Println("set presence error:", err)
+	}
+	presence.changed(c.userID)
+	return prev, existed && !prev.stale()
+}
+
+// touch records activity from c, bringing it back from auto-away.
+func (c *Client) touch() {
+	c.mu.Lock()
+	c.lastActive = time.Now()
+	wasIdle := c.idle
+	c.idle = false
+	c.mu.Unlock()
+
+	if wasIdle {
+		c.publishPresence()
+	}
+}
+
+// isDND reports whether userID is connected with do-not-disturb on. Events that
+// only exist to get the user's attention are held back for them.
+func isDND(userID string) bool {
+	e, online := lookupPresence(userID)
+	return online && e.Status == statusDND
+}
+
+type setStatusRequest struct {
+	Status     string `json:"status"`
+	StatusText string `json:"status_text"`
+}
+
+func (c *Client) handleSetStatus(p setStatusRequest) (map[string]any, error) {
+	p.StatusText = strings.TrimSpace(p.StatusText)
+	if !selectableStatuses[p.Status] {
+		return nil, invalidPayload("status must be online, away, dnd or invisible")
+	}
+	if utf8.RuneCountInString(p.StatusText) > maxStatusTextLen {
+		return nil, invalidPayload("status text is too long")
+	}
+
+	if err := db.SetUserStatus(c.userID, p.Status, p.StatusText); err != nil {
+		return nil, err
+	}
+
+	wasVisible := c.presenceEntry().visible().Status != statusOffline
+	c.mu.Lock()
+	c.status, c.statusText = p.Status, p.StatusText
+	c.mu.Unlock()
+
+	// Going invisible looks like disconnecting, so last seen is now
+	if wasVisible && p.Status == statusInvisible {
+		if _, err := db.TouchLastSeen([]string{c.userID}); err != nil {
+			log.Println("touch last seen error:", err)
+		}
+		stopAllTyping(c.userID)
+	}
+	c.publishPresence()
+	// and coming back looks like connecting
+	if !wasVisible && p.Status != statusInvisible {
+		deliverPending(c.userID)
+	}
+	return map[string]any{"status": p.Status, "status_text": p.StatusText}, nil
+}
+
+// presenceBatcher coalesces presence changes. Clients get a full user_list
+// snapshot once on connect and only presence_update diffs after that. The last
+// state announced for each user lives in the hub backend, so instances agree
+// on what everyone has already been told.
+type presenceBatcher struct {
+	mu      sync.Mutex
+	pending map[string]struct{}
+	timer   *time.Timer
+}
+
+var presence = &presenceBatcher{
+	pending: make(map[string]struct{}),
+}
+
+// changed records that userID's presence may have changed. The state that gets
+// broadcast is read from the presence directory at flush time, so a quick
+// reconnect inside one interval produces no event at all.
+func (p *presenceBatcher) changed(userID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending[userID] = struct{}{}
+	if p.timer == nil {
+		p.timer = time.AfterFunc(presenceFlushInterval, p.flush)
+	}
+}
+
+func (p *presenceBatcher) flush() {
+	p.mu.Lock()
+	pending := p.pending
+	p.pending = make(map[string]struct{})
+	p.timer = nil
+	p.mu.Unlock()
+
+	changes := []PresenceChange{}
+	for userID := range pending {
+		state := visiblePresence(userID)
+		prev, err := backend.SwapAnnounced(userID, state)
+		if err != nil {
+			log.Println("announce presence error:", err)
+			continue
+		}
+		if prev == state {
+			continue
+		}
+		changes = append(changes, PresenceChange{
+			UserID:     userID,
+			Online:     state.Status != statusOffline,
+			Status:     state.Status,
+			StatusText: state.Text,
+		})
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+
+	ids := make([]string, len(changes))
+	for i, c := range changes {
+		ids[i] = c.UserID
+	}
+	names, err := db.GetNicknames(ids)
+	if err != nil {
+		log.Println("presence nicknames error:", err)
+	}
+	for i := range changes {
+		changes[i].Nickname = names[changes[i].UserID]
+		if !changes[i].Online {
+			changes[i].LastSeenAt = db.GetLastSeen(changes[i].UserID)
+		}
+	}
+
+	BroadcastAll("presence_update", changes)
+}
+
+// StartPresenceSweep marks connected users idle once they stop being active
+// and keeps their directory entries and last seen times fresh.
+func StartPresenceSweep() {
+	go presence.sweep()
+}
+
+// sweep periodically marks idle clients as away and refreshes last_seen_at for
+// everyone visibly online, so it stays close to accurate even after a crash.
+func (p *presenceBatcher) sweep() {
+	for range time.Tick(presenceSweepInterval) {
+		hub.mu.RLock()
+		clients := make([]*Client, 0, len(hub.clients))
+		for _, c := range hub.clients {
+			clients = append(clients, c)
+		}
+		hub.mu.RUnlock()
+
+		seen := []string{}
+		for _, c := range clients {
+			c.mu.Lock()
+			becameIdle := !c.idle && time.Since(c.lastActive) > presenceIdleAfter
+			if becameIdle {
+				c.idle = true
+			}
+			invisible := c.status == statusInvisible
+			c.mu.Unlock()
+
+			// Also refreshes the entry so it doesn't go stale
+			if _, _, err := backend.SetPresence(c.userID, c.presenceEntry()); err != nil {
+				log.Println("set presence error:", err)
+			}
+			if becameIdle {
+				p.changed(c.userID)
+			}
+			if !invisible {
+				seen = append(seen, c.userID)
+			}
+		}
+		if _, err := db.TouchLastSeen(seen); err != nil {
+			log.Println("touch last seen error:", err)
+		}
+	}
+}
+
+// sendUserList sends c the full user_list snapshot: every other user with their
+// presence, last message time and unread count.
+func sendUserList(c *Client) {
+	summaries, err := db.GetConversationSummaries(c.userID)
+	if err != nil {
+		log.Println("user list error:", err)
+		return
+	}
+
+	connected := backend.AllPresence()
+	users := make([]UserStatus, 0, len(summaries))
+	for _, s := range summaries {
+		state := presenceState{Status: statusOffline}
+		if e, ok := connected[s.ID]; ok && !e.stale() {
+			state = e.visible()
+		}
+		u := UserStatus{
+			ID:          s.ID,
+			Nickname:    s.Nickname,
+			Online:      state.Status != statusOffline,
+			Status:      state.Status,
+			StatusText:  state.Text,
+			LastMsg:     s.LastMsg,
+			UnreadCount: s.UnreadCount,
+		}
+		if !u.Online {
+			u.LastSeenAt = s.LastSeenAt
+		}
+		users = append(users, u)
+	}
+
+	envelope, _ := json.Marshal(WSMessage{
+		Type:    "user_list",
+		Payload: mustMarshal(users),
+	})
+	c.enqueue(envelope)
+}
+
+// sendOwnStatus tells c which status and status text its user has picked.
+func sendOwnStatus(c *Client) {
+	c.mu.Lock()
+	payload := ownStatusEvent{Status: c.status, StatusText: c.statusText}
+	c.mu.Unlock()
+	sendEphemeral(c.userID, "own_status", payload)
+}
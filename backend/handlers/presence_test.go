@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"real-time-forum/db"
+)
+
+// BenchmarkPresence measures what broadcasting presence changes costs with
+// 1000 users connected: database queries and hub backend operations per
+// change, and the events that reach clients. "single" flushes after every
+// change; "burst" has every user change before one flush, which is what the
+// batcher is for.
+func BenchmarkPresence(b *testing.B) {
+	const users = 1000
+
+	var queries, ops, events atomic.Int64
+	countDBQueries(b, &queries)
+	prevBackend := backend
+	UseHubBackend(countingBackend{HubBackend: prevBackend, ops: &ops})
+	b.Cleanup(func() { UseHubBackend(prevBackend) })
+
+	clients := make([]*Client, users)
+	for i := range clients {
+		userID, _ := newTestUser(b)
+		clients[i] = newBenchClient(b, userID, &events)
+	}
+	flushPresence()
+
+	// toggle changes c between online and away
+	toggle := func(c *Client) {
+		c.mu.Lock()
+		if c.status == statusOnline {
+			c.status = statusAway
+		} else {
+			c.status = statusOnline
+		}
+		c.mu.Unlock()
+		c.publishPresence()
+	}
+	report := func(b *testing.B, changes int64) {
+		b.ReportMetric(float64(queries.Load())/float64(changes), "queries/change")
+		b.ReportMetric(float64(ops.Load())/float64(changes), "backend-ops/change")
+		b.ReportMetric(float64(events.Load())/float64(changes), "events/change")
+	}
+	reset := func(b *testing.B) {
+		b.ResetTimer()
+		queries.Store(0)
+		ops.Store(0)
+		events.Store(0)
+	}
+
+	b.Run("single", func(b *testing.B) {
+		reset(b)
+		var changes int64
+		for b.Loop() {
+			toggle(clients[changes%users])
+			flushPresence()
+			changes++
+		}
+		b.StopTimer()
+		waitDelivered(b, &events, changes*users) // one event per change for each user
+		report(b, changes)
+	})
+
+	b.Run("burst", func(b *testing.B) {
+		reset(b)
+		var changes int64
+		for b.Loop() {
+			for _, c := range clients {
+				toggle(c)
+			}
+			flushPresence()
+			changes += users
+		}
+		b.StopTimer()
+		waitDelivered(b, &events, changes) // one event per flush for each user
+		report(b, changes)
+	})
+}
+
+// flushPresence broadcasts pending presence changes now rather than when the
+// batcher's timer fires.
+func flushPresence() {
+	presence.mu.Lock()
+	if presence.timer != nil {
+		presence.timer.Stop()
+	}
+	presence.mu.Unlock()
+	presence.flush()
+}
+
+// newBenchClient connects a client for userID without a network connection,
+// counting the events it is sent.
+func newBenchClient(b *testing.B, userID string, events *atomic.Int64) *Client {
+	c := newClient(userID, nil)
+	c.send = make(chan []byte, 1024)
+	go func() {
+		for range c.send {
+			events.Add(1)
+		}
+	}()
+	hub.mu.Lock()
+	hub.clients[userID] = c
+	hub.mu.Unlock()
+	c.publishPresence()
+
+	b.Cleanup(func() {
+		hub.mu.Lock()
+		delete(hub.clients, userID)
+		hub.mu.Unlock()
+		c.shutdown()
+		backend.ReleasePresence(userID, instanceID)
+	})
+	return c
+}
+
+// waitDelivered waits for the clients to have read n events, so none are
+// dropped as the benchmark ends.
+func waitDelivered(b *testing.B, events *atomic.Int64, n int64) {
+	deadline := time.Now().Add(10 * time.Second)
+	for events.Load() < n {
+		if time.Now().After(deadline) {
+			b.Fatalf("clients got %d events, want %d", events.Load(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// countDBQueries counts every statement the db package runs until the
+// benchmark ends.
+func countDBQueries(b *testing.B, queries *atomic.Int64) {
+	counted := sql.OpenDB(countingConnector{driver: db.DB.Driver(), name: testDBPath, queries: queries})
+	prev := db.DB
+	db.DB = counted
+	b.Cleanup(func() {
+		db.DB = prev
+		counted.Close()
+	})
+}
+
+type countingConnector struct {
+	driver  driver.Driver
+	name    string
+	queries *atomic.Int64
+}
+
+func (c countingConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.name)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{Conn: conn, queries: c.queries}, nil
+}
+
+func (c countingConnector) Driver() driver.Driver { return c.driver }
+
+// countingConn hides the driver's direct query methods, so database/sql
+// prepares every statement and each one is counted.
+type countingConn struct {
+	driver.Conn
+	queries *atomic.Int64
+}
+
+func (c countingConn) Prepare(query string) (driver.Stmt, error) {
+	c.queries.Add(1)
+	return c.Conn.Prepare(query)
+}
+
+// countingBackend counts the operations on a hub backend.
+type countingBackend struct {
+	HubBackend
+	ops *atomic.Int64
+}
+
+func (b countingBackend) Publish(m hubMessage) error {
+	b.ops.Add(1)
+	return b.HubBackend.Publish(m)
+}
+
+func (b countingBackend) SetPresence(userID string, e presenceEntry) (presenceEntry, bool, error) {
+	b.ops.Add(1)
+	return b.HubBackend.SetPresence(userID, e)
+}
+
+func (b countingBackend) ReleasePresence(userID, instance string) error {
+	b.ops.Add(1)
+	return b.HubBackend.ReleasePresence(userID, instance)
+}
+
+func (b countingBackend) LookupPresence(userID string) (presenceEntry, bool) {
+	b.ops.Add(1)
+	return b.HubBackend.LookupPresence(userID)
+}
+
+func (b countingBackend) AllPresence() map[string]presenceEntry {
+	b.ops.Add(1)
+	return b.HubBackend.AllPresence()
+}
+
+func (b countingBackend) SwapAnnounced(userID string, s presenceState) (presenceState, error) {
+	b.ops.Add(1)
+	return b.HubBackend.SwapAnnounced(userID, s)
+}
@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"real-time-forum/models"
+)
+
+// Protocol versions this server speaks. Clients announce theirs in "hello";
+// the connection runs at the lower of the two.
+const (
+	protocolVersion    = 1
+	minProtocolVersion = 1
+)
+
+// capabilities are the protocol features this server supports. A client lists
+// the ones it understands in "hello" and gets back the intersection; from then
+// on it isn't sent events of the features it left out, and requests that need
+// one fail. Acks aren't optional: every request is answered.
+var capabilities = []string{"resume", "receipts", "typing", "presence", "status", "edit", "topics"}
+
+// eventCapabilities maps the events that belong to an optional feature to it.
+var eventCapabilities = map[string]string{
+	"user_list":         "presence",
+	"presence_update":   "presence",
+	"own_status":        "status",
+	"message_updated":   "edit",
+	"message_delivered": "receipts",
+	"message_read":      "receipts",
+	"typing_start":      "typing",
+	"typing_stop":       "typing",
+}
+
+// requestCapabilities maps the requests that belong to an optional feature to it.
+var requestCapabilities = map[string]string{
+	"set_status":   "status",
+	"edit_message": "edit",
+	"typing_start": "typing",
+	"typing_stop":  "typing",
+	"subscribe":    "topics",
+	"unsubscribe":  "topics",
+}
+
+// Error codes sent in "error" events.
+const (
+	codeBadJSON            = "bad_json"
+	codeUnknownType        = "unknown_type"
+	codeInvalidPayload     = "invalid_payload"
+	codeUnsupportedVersion = "unsupported_version"
+	codeNotNegotiated      = "not_negotiated"
+	codeNotFound           = "not_found"
+	codeForbidden          = "forbidden"
+	codeEditWindowClosed   = "edit_window_closed"
+	codeMessageUnsent      = "message_unsent"
+	codeInternal           = "internal"
+)
+
+var errorCodes = map[string]string{
+	codeBadJSON:            "the frame was not a JSON envelope",
+	codeUnknownType:        "no handler for the request type",
+	codeInvalidPayload:     "the payload is malformed or fails validation",
+	codeUnsupportedVersion: "the client's protocol version is too old",
+	codeNotNegotiated:      "the request belongs to a capability the client didn't ask for in hello",
+	codeNotFound:           "the referenced resource does not exist",
+	codeForbidden:          "the user may not act on the resource",
+	codeEditWindowClosed:   "the message is too old to edit",
+	codeMessageUnsent:      "the message was unsent",
+	codeInternal:           "the server failed; retrying may help",
+}
+
+// protocolError is a client mistake whose code and message can be returned as-is.
+type protocolError struct {
+	Code    string
+	Message string
+}
+
+func (e protocolError) Error() string { return e.Message }
+
+func invalidPayload(msg string) error {
+	return protocolError{Code: codeInvalidPayload, Message: msg}
+}
+
+// asProtocolError turns err into something that is safe to show the client.
+func asProtocolError(err error) protocolError {
+	var pe protocolError
+	if errors.As(err, &pe) {
+		return pe
+	}
+	switch {
+	case errors.Is(err, errMessageNotFound):
+		return protocolError{codeNotFound, err.Error()}
+	case errors.Is(err, errMessageForbidden):
+		return protocolError{codeForbidden, err.Error()}
+	case errors.Is(err, errEditWindowClosed):
+		return protocolError{codeEditWindowClosed, err.Error()}
+	case errors.Is(err, errMessageUnsent):
+		return protocolError{codeMessageUnsent, err.Error()}
+	case errors.Is(err, errMessageEmpty):
+		return protocolError{codeInvalidPayload, err.Error()}
+	}
+	log.Println("ws request error:", err)
+	return protocolError{codeInternal, "internal server error"}
+}
+
+// wsHandler handles one request type. Its result is merged into the ack.
+type wsHandler struct {
+	description string
+	payload     reflect.Type
+	handle      func(c *Client, raw json.RawMessage) (map[string]any, error)
+}
+
+var wsHandlers = map[string]wsHandler{}
+
+// handle registers fn for msgType. The payload is decoded into P before fn
+// runs, so handlers only ever see well-formed requests.
+func handle[P any](msgType, description string, fn func(c *Client, p P) (map[string]any, error)) {
+	wsHandlers[msgType] = wsHandler{
+		description: description,
+		payload:     reflect.TypeFor[P](),
+		handle: func(c *Client, raw json.RawMessage) (map[string]any, error) {
+			var p P
+			if len(raw) > 0 {
+				if err := json.Unmarshal(raw, &p); err != nil {
+					return nil, invalidPayload("invalid payload for " + msgType)
+				}
+			}
+			return fn(c, p)
+		},
+	}
+}
+
+func init() {
+	handle("hello", "negotiate the protocol version and capabilities", (*Client).handleHello)
+	handle("activity", "report user input so the user doesn't go idle", (*Client).handleActivity)
+	handle("send_message", "send a private message", (*Client).handleSendMessage)
+	handle("mark_read", "mark every message from sender_id as read", (*Client).handleMarkRead)
+	handle("edit_message", "edit one of your messages", (*Client).handleEditMessage)
+	handle("unsend_message", "remove one of your messages for both participants", (*Client).handleUnsendMessage)
+	handle("delete_message", "hide a message from your side of the conversation", (*Client).handleDeleteMessage)
+	handle("set_status", "set your presence status and status text", (*Client).handleSetStatus)
+	handle("typing_start", "tell receiver_id you are typing", (*Client).handleTypingStart)
+	handle("typing_stop", "tell receiver_id you stopped typing", (*Client).handleTypingStop)
+	handle("subscribe", "receive events for topics: feed, post:<id>, category:<name>, user:<id>", (*Client).handleSubscribe)
+	handle("unsubscribe", "stop receiving events for topics", (*Client).handleUnsubscribe)
+}
+
+// dispatch handles one request from the client, whichever transport it came
+// in on. Every request is answered, with an ack or an error, carrying its id
+// and any client_id in the payload so the client can match them up.
+func (c *Client) dispatch(msg WSMessage) {
+	c.touch()
+
+	var ids struct {
+		ClientID string `json:"client_id"`
+	}
+	json.Unmarshal(msg.Payload, &ids)
+	req := requestRef{Type: msg.Type, ID: msg.ID, ClientID: ids.ClientID}
+
+	h, ok := wsHandlers[msg.Type]
+	if !ok {
+		c.replyError(req, protocolError{codeUnknownType, "unknown request type " + msg.Type})
+		return
+	}
+	if capability, ok := requestCapabilities[msg.Type]; ok && !c.supports(capability) {
+		c.replyError(req, protocolError{codeNotNegotiated, msg.Type + " needs the " + capability + " capability"})
+		return
+	}
+	result, err := h.handle(c, msg.Payload)
+	if err != nil {
+		c.replyError(req, err)
+		return
+	}
+	payload := map[string]any{}
+	for k, v := range result {
+		payload[k] = v
+	}
+	payload["request_id"] = req.ID
+	payload["client_id"] = req.ClientID
+	sendEphemeral(c.userID, "ack", payload)
+}
+
+// requestRef identifies the request a reply answers.
+type requestRef struct {
+	Type     string
+	ID       string
+	ClientID string
+}
+
+func (c *Client) replyError(req requestRef, err error) {
+	pe := asProtocolError(err)
+	sendEphemeral(c.userID, "error", errorEvent{
+		Code:        pe.Code,
+		Error:       pe.Message,
+		RequestType: req.Type,
+		RequestID:   req.ID,
+		ClientID:    req.ClientID,
+	})
+}
+
+type helloRequest struct {
+	Protocol     int      `json:"protocol"`
+	Capabilities []string `json:"capabilities"`
+}
+
+func (c *Client) handleHello(p helloRequest) (map[string]any, error) {
+	version := min(p.Protocol, protocolVersion)
+	if version < minProtocolVersion {
+		return nil, protocolError{codeUnsupportedVersion, "protocol versions below 1 are not supported"}
+	}
+	common := []string{}
+	supported := make(map[string]bool)
+	for _, name := range p.Capabilities {
+		if slices.Contains(capabilities, name) && !supported[name] {
+			common = append(common, name)
+			supported[name] = true
+		}
+	}
+	c.mu.Lock()
+	c.protocol, c.capabilities = version, supported
+	c.mu.Unlock()
+	return map[string]any{"protocol": version, "capabilities": common}, nil
+}
+
+// supports reports whether c may use capability. Until hello it may use them all.
+func (c *Client) supports(capability string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capabilities == nil || c.capabilities[capability]
+}
+
+// wantsLocked reports whether the encoded event data is one c negotiated, or
+// doesn't belong to an optional feature. Must be called with c.mu held.
+func (c *Client) wantsLocked(data []byte) bool {
+	if c.capabilities == nil || len(c.capabilities) == len(capabilities) {
+		return true
+	}
+	var env struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(data, &env)
+	capability, ok := eventCapabilities[env.Type]
+	return !ok || c.capabilities[capability]
+}
+
+func (c *Client) handleActivity(struct{}) (map[string]any, error) {
+	return nil, nil // dispatch has already recorded the activity
+}
+
+// sendHello is the first event on every connection.
+func sendHello(c *Client) {
+	data, _ := json.Marshal(WSMessage{Type: "hello", Payload: mustMarshal(helloEvent{
+		Protocol:     protocolVersion,
+		MinProtocol:  minProtocolVersion,
+		Capabilities: capabilities,
+		UserID:       c.userID,
+	})})
+	c.enqueue(data)
+}
+
+// Payloads of server events that don't have a model of their own.
+type (
+	helloEvent struct {
+		Protocol     int      `json:"protocol"`
+		MinProtocol  int      `json:"min_protocol"`
+		Capabilities []string `json:"capabilities"`
+		UserID       string   `json:"user_id"`
+	}
+	errorEvent struct {
+		Code        string `json:"code"`
+		Error       string `json:"error"`
+		RequestType string `json:"request_type"`
+		RequestID   string `json:"request_id"`
+		ClientID    string `json:"client_id"`
+	}
+	ackEvent struct {
+		RequestID string `json:"request_id"`
+		ClientID  string `json:"client_id"`
+		// plus the fields listed under the request's "result"
+	}
+	resumedEvent struct {
+		LastSeq  int64 `json:"last_seq"`
+		Replayed int   `json:"replayed"`
+		Complete bool  `json:"complete"`
+	}
+	ownStatusEvent struct {
+		Status     string `json:"status"`
+		StatusText string `json:"status_text"`
+	}
+	deliveredEvent struct {
+		ReceiverID  string   `json:"receiver_id"`
+		MessageIDs  []string `json:"message_ids"`
+		DeliveredAt string   `json:"delivered_at"`
+	}
+	readEvent struct {
+		ReaderID   string   `json:"reader_id"`
+		MessageIDs []string `json:"message_ids"`
+		ReadAt     string   `json:"read_at"`
+	}
+	messageDeletedEvent struct {
+		MessageID string `json:"message_id"`
+		Scope     string `json:"scope"` // "everyone" or "me"
+	}
+	typingEvent struct {
+		UserID string `json:"user_id"`
+	}
+	voteUpdateEvent struct {
+		PostID    string `json:"post_id"`
+		Upvotes   int    `json:"upvotes"`
+		Downvotes int    `json:"downvotes"`
+	}
+	commentDeletedEvent struct {
+		CommentID string `json:"comment_id"`
+		PostID    string `json:"post_id"`
+	}
+	postDeletedEvent struct {
+		PostID string `json:"post_id"`
+	}
+	notificationEvent struct {
+		Notification models.Notification `json:"notification"`
+		Unread       int                 `json:"unread"`
+	}
+	draftUnscheduledEvent struct {
+		DraftID string `json:"draft_id"`
+		Title   string `json:"title"`
+		Reason  string `json:"reason"`
+	}
+)
+
+// eventSchemas lists every event the server sends and its payload type.
+var eventSchemas = []struct {
+	Type        string
+	Description string
+	Payload     any
+}{
+	{"hello", "first event on every connection", helloEvent{}},
+	{"ack", "a request succeeded", ackEvent{}},
+	{"error", "a request failed", errorEvent{}},
+	{"resumed", "replay after last_seq is done", resumedEvent{}},
+	{"user_list", "snapshot of every user, sent once per connection", []UserStatus{}},
+	{"own_status", "the status you picked", ownStatusEvent{}},
+	{"presence_update", "users whose presence changed", []PresenceChange{}},
+	{"new_message", "a private message to or from you", models.Message{}},
+	{"message_updated", "a message was edited", models.Message{}},
+	{"message_deleted", "a message was unsent or deleted for you", messageDeletedEvent{}},
+	{"message_delivered", "your messages reached the receiver", deliveredEvent{}},
+	{"message_read", "your messages were read", readEvent{}},
+	{"typing_start", "user_id started typing to you", typingEvent{}},
+	{"typing_stop", "user_id stopped typing to you", typingEvent{}},
+	{"force_logout", "you logged in elsewhere; the connection is closing", ""},
+	{"new_post", "a post was created (topics feed, category:<name>, user:<author>; sent to all without topics)", models.Post{}},
+	{"vote_update", "a post's vote counts changed (topic post:<id>; sent to all without topics)", voteUpdateEvent{}},
+	{"poll_update", "a post's poll results changed; my_votes is left out (topic post:<id>)", models.Poll{}},
+	{"new_comment", "a comment was added (topic post:<id>)", models.Comment{}},
+	{"comment_deleted", "a comment was deleted (topic post:<id>)", commentDeletedEvent{}},
+	{"post_deleted", "a post was deleted (topics post:<id>, feed, category:<name>, user:<author>)", postDeletedEvent{}},
+	{"notification", "new activity for you, or more on an unread notification (same id)", notificationEvent{}},
+	{"draft_unscheduled", "your scheduled post couldn't be published and is a plain draft again", draftUnscheduledEvent{}},
+}
+
+// WSSchema describes the real-time protocol: the envelope, every request and
+// event with its payload, and the error codes.
+func WSSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requests := map[string]any{}
+	for msgType, h := range wsHandlers {
+		requests[msgType] = map[string]any{
+			"description": h.description,
+			"payload":     schemaOf(h.payload),
+		}
+	}
+	events := map[string]any{}
+	for _, e := range eventSchemas {
+		events[e.Type] = map[string]any{
+			"description": e.Description,
+			"payload":     schemaOf(reflect.TypeOf(e.Payload)),
+		}
+	}
+
+	jsonOK(w, http.StatusOK, map[string]any{
+		"protocol":     protocolVersion,
+		"min_protocol": minProtocolVersion,
+		"capabilities": capabilities,
+		"envelope":     schemaOf(reflect.TypeFor[WSMessage]()),
+		"requests":     requests,
+		"events":       events,
+		"errors":       errorCodes,
+	})
+}
+
+var rawMessageType = reflect.TypeFor[json.RawMessage]()
+
+// schemaOf describes t the way it is encoded as JSON: type names for scalars,
+// a one-element list for arrays and a field map for structs.
+func schemaOf(t reflect.Type) any {
+	if t == rawMessageType {
+		return "any"
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return []any{schemaOf(t.Elem())}
+	case reflect.Struct:
+		fields := map[string]any{}
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			fields[name] = schemaOf(f.Type)
+		}
+		return fields
+	case reflect.Map:
+		return "object"
+	}
+	return "any"
+}
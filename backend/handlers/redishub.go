@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys shared by every instance.
+const (
+	redisHubChannel   = "forum:hub"
+	redisPresenceKey  = "forum:presence"
+	redisAnnouncedKey = "forum:announced"
+)
+
+// redisBackend shares the hub between instances through Redis: pub/sub for
+// events and two hashes for the presence directory.
+type redisBackend struct {
+	rdb *redis.Client
+	sub *redis.PubSub
+}
+
+// Only delete the entry if it still belongs to the releasing instance
+var releasePresenceScript = redis.NewScript(`
+local cur = redis.call('HGET', KEYS[1], ARGV[1])
+if cur and cjson.decode(cur).instance == ARGV[2] then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return 0`)
+
+var swapScript = redis.NewScript(`
+local prev = redis.call('HGET', KEYS[1], ARGV[1])
+if ARGV[2] == '' then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+else
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+end
+return prev or ''`)
+
+// NewRedisHub connects to the Redis server at url (redis://host:port/db) for
+// a hub backend that routes events and presence through it.
+func NewRedisHub(url string) (HubBackend, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return newRedisBackend(opts, receiveHubMessage)
+}
+
+// newRedisBackend connects to Redis and passes every hub message published by
+// any instance to handle.
+func newRedisBackend(opts *redis.Options, handle func(hubMessage)) (*redisBackend, error) {
+	rdb := redis.NewClient(opts)
+	ctx := context.Background()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		return nil, err
+	}
+
+	sub := rdb.Subscribe(ctx, redisHubChannel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		rdb.Close()
+		return nil, err
+	}
+	go func() {
+		for msg := range sub.Channel() {
+			var m hubMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				log.Println("hub message error:", err)
+				continue
+			}
+			handle(m)
+		}
+	}()
+	return &redisBackend{rdb: rdb, sub: sub}, nil
+}
+
+func (b *redisBackend) Publish(m hubMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return b.rdb.Publish(context.Background(), redisHubChannel, data).Err()
+}
+
+func (b *redisBackend) SetPresence(userID string, e presenceEntry) (presenceEntry, bool, error) {
+	data, _ := json.Marshal(e)
+	raw, err := swapScript.Run(context.Background(), b.rdb,
+		[]string{redisPresenceKey}, userID, string(data)).Text()
+	if err != nil || raw == "" {
+		return presenceEntry{}, false, err
+	}
+	var prev presenceEntry
+	err = json.Unmarshal([]byte(raw), &prev)
+	return prev, err == nil, err
+}
+
+func (b *redisBackend) ReleasePresence(userID, instance string) error {
+	return releasePresenceScript.Run(context.Background(), b.rdb,
+		[]string{redisPresenceKey}, userID, instance).Err()
+}
+
+func (b *redisBackend) LookupPresence(userID string) (presenceEntry, bool) {
+	var e presenceEntry
+	raw, err := b.rdb.HGet(context.Background(), redisPresenceKey, userID).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Println("presence lookup error:", err)
+		}
+		return e, false
+	}
+	return e, json.Unmarshal(raw, &e) == nil
+}
+
+func (b *redisBackend) AllPresence() map[string]presenceEntry {
+	all := map[string]presenceEntry{}
+	fields, err := b.rdb.HGetAll(context.Background(), redisPresenceKey).Result()
+	if err != nil {
+		log.Println("presence list error:", err)
+		return all
+	}
+	for id, raw := range fields {
+		var e presenceEntry
+		if json.Unmarshal([]byte(raw), &e) == nil {
+			all[id] = e
+		}
+	}
+	return all
+}
+
+func (b *redisBackend) SwapAnnounced(userID string, s presenceState) (presenceState, error) {
+	data := ""
+	if s.Status != statusOffline {
+		raw, _ := json.Marshal(s)
+		data = string(raw)
+	}
+	raw, err := swapScript.Run(context.Background(), b.rdb,
+		[]string{redisAnnouncedKey}, userID, data).Text()
+	prev := presenceState{Status: statusOffline}
+	if err != nil || raw == "" {
+		return prev, err
+	}
+	err = json.Unmarshal([]byte(raw), &prev)
+	return prev, err
+}
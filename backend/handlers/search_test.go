@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"real-time-forum/db"
+
+	"github.com/google/uuid"
+)
+
+func sendTestMessage(t *testing.T, senderID, receiverID, content string) string {
+	t.Helper()
+	id := uuid.NewString()
+	if err := db.CreateMessage(id, senderID, receiverID, content, ""); err != nil {
+		t.Fatal("create message:", err)
+	}
+	return id
+}
+
+// searchMessages returns the IDs of the messages a search for q finds.
+func searchMessages(t *testing.T, token, q string) []string {
+	t.Helper()
+	var hits []db.MessageHit
+	rec := apiRequest(t, SearchMessages, http.MethodGet, "/api/messages/search?q="+url.QueryEscape(q), token, nil, &hits)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("search %q: %d %s", q, rec.Code, rec.Body)
+	}
+	ids := make([]string, len(hits))
+	for i, h := range hits {
+		ids[i] = h.Message.ID
+	}
+	return ids
+}
+
+// indexed reports whether the search index has msgID under word, whoever can
+// see it.
+func indexed(t *testing.T, msgID, word string) bool {
+	t.Helper()
+	var n int
+	err := db.DB.QueryRow(`SELECT COUNT(*) FROM messages_fts
+		WHERE messages_fts MATCH ? AND docid = (SELECT rowid FROM messages WHERE id = ?)`, word, msgID).Scan(&n)
+	if err != nil {
+		t.Fatal("query index:", err)
+	}
+	return n > 0
+}
+
+func messageAction(t *testing.T, handler http.HandlerFunc, token, msgID, content string) {
+	t.Helper()
+	body := messageActionRequest{MessageID: msgID, Content: content}
+	if rec := apiRequest(t, handler, http.MethodPost, "/api/messages/action", token, body, nil); rec.Code != http.StatusOK {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSearchAfterEdit(t *testing.T) {
+	aliceID, alice := newTestUser(t)
+	bobID, bob := newTestUser(t)
+	id := sendTestMessage(t, aliceID, bobID, "lunch at noon?")
+	if got := searchMessages(t, bob, "lunch"); !slices.Equal(got, []string{id}) {
+		t.Fatalf("before editing got %v, want the message", got)
+	}
+
+	messageAction(t, EditMessage, alice, id, "dinner at eight?")
+	for _, token := range []string{alice, bob} {
+		if got := searchMessages(t, token, "lunch"); len(got) != 0 {
+			t.Errorf("the old text still finds %v", got)
+		}
+		if got := searchMessages(t, token, "dinner"); !slices.Equal(got, []string{id}) {
+			t.Errorf("the new text finds %v, want the message", got)
+		}
+	}
+	if indexed(t, id, "noon") {
+		t.Error("the old text is still in the index")
+	}
+}
+
+func TestSearchAfterDelete(t *testing.T) {
+	aliceID, alice := newTestUser(t)
+	bobID, bob := newTestUser(t)
+
+	t.Run("unsent", func(t *testing.T) {
+		id := sendTestMessage(t, aliceID, bobID, "unsent secret")
+		messageAction(t, UnsendMessage, alice, id, "")
+		for _, token := range []string{alice, bob} {
+			if got := searchMessages(t, token, "secret"); len(got) != 0 {
+				t.Errorf("found %v after unsending", got)
+			}
+		}
+		if indexed(t, id, "secret") {
+			t.Error("an unsent message is still in the index")
+		}
+	})
+
+	t.Run("deleted for me", func(t *testing.T) {
+		id := sendTestMessage(t, aliceID, bobID, "private gossip")
+		messageAction(t, DeleteMessage, bob, id, "")
+		if got := searchMessages(t, bob, "gossip"); len(got) != 0 {
+			t.Errorf("found %v after deleting it", got)
+		}
+		// The other side still has it
+		if got := searchMessages(t, alice, "gossip"); !slices.Equal(got, []string{id}) {
+			t.Errorf("the sender's search found %v, want the message", got)
+		}
+	})
+
+	t.Run("never delivered", func(t *testing.T) {
+		id := sendTestMessage(t, aliceID, bobID, "undelivered parcel")
+		if err := db.DeleteMessage(id); err != nil {
+			t.Fatal("delete:", err)
+		}
+		var n int
+		db.DB.QueryRow(`SELECT COUNT(*) FROM messages_fts WHERE messages_fts MATCH 'parcel'`).Scan(&n)
+		if n != 0 {
+			t.Errorf("the index still has %d entries for the deleted message", n)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+)
+
+// maxCategoryLen matches what a post's category can reasonably be.
+const maxCategoryLen = 50
+
+// WatchPost and UnwatchPost take {"post_id": "..."} and start or stop
+// notifying the caller about new comments on the post.
+func WatchPost(w http.ResponseWriter, r *http.Request) {
+	changeWatch(w, r, db.WatchPost, true)
+}
+
+func UnwatchPost(w http.ResponseWriter, r *http.Request) {
+	changeWatch(w, r, db.UnwatchPost, false)
+}
+
+func changeWatch(w http.ResponseWriter, r *http.Request, change func(userID, postID string) error, watching bool) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		PostID string `json:"post_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PostID == "" {
+		jsonError(w, "post_id is required", http.StatusBadRequest)
+		return
+	}
+	if !db.PostExists(req.PostID) {
+		jsonError(w, "post not found", http.StatusNotFound)
+		return
+	}
+
+	if err := change(userID, req.PostID); err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, map[string]any{"post_id": req.PostID, "watching": watching})
+}
+
+// SubscribeCategory and UnsubscribeCategory take {"category": "..."} and
+// start or stop notifying the caller about new posts in it.
+func SubscribeCategory(w http.ResponseWriter, r *http.Request) {
+	changeCategorySubscription(w, r, db.SubscribeCategory, true)
+}
+
+func UnsubscribeCategory(w http.ResponseWriter, r *http.Request) {
+	changeCategorySubscription(w, r, db.UnsubscribeCategory, false)
+}
+
+func changeCategorySubscription(w http.ResponseWriter, r *http.Request, change func(userID, category string) error, subscribed bool) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Category string `json:"category"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Category = strings.TrimSpace(req.Category)
+	if req.Category == "" || utf8.RuneCountInString(req.Category) > maxCategoryLen {
+		jsonError(w, "category must be 1-50 characters", http.StatusBadRequest)
+		return
+	}
+
+	if err := change(userID, req.Category); err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, map[string]any{"category": req.Category, "subscribed": subscribed})
+}
+
+// Subscriptions lists the posts the caller watches and the categories they
+// are subscribed to.
+func Subscriptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	posts, categories, err := db.ListSubscriptions(userID)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, map[string]any{"posts": posts, "categories": categories})
+}
+
+// UnsubscribeAll stops the caller watching every post and drops all their
+// category subscriptions. Posts they create or comment on later are watched
+// again as usual.
+func UnsubscribeAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := db.UnsubscribeAll(userID); err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusOK, map[string]string{"message": "unsubscribed"})
+}
+
+// autoWatch makes userID watch a post they created or commented on, unless
+// they unwatched it before.
+func autoWatch(userID, postID string) {
+	if err := db.AutoWatchPost(userID, postID); err != nil {
+		log.Println("auto-watch error:", err)
+	}
+}
+
+// notifyNewPost tells the users a new post mentions, the author's followers
+// and the subscribers of its categories about it. Each user is told once, by
+// the most specific reason that applies to them.
+func notifyNewPost(post models.Post) {
+	told := map[string]bool{post.UserID: true}
+	tell := func(ids []string, typ string) {
+		for _, id := range ids {
+			if !told[id] {
+				told[id] = true
+				notify(id, typ, post.ID, "", post.UserID)
+			}
+		}
+	}
+
+	tell(mentionedUsers(post.Mentions), notifyMention)
+	if followers, err := db.ListFollowerIDs(post.UserID); err == nil {
+		tell(followers, notifyPost)
+	}
+	if subscribers, err := db.ListCategorySubscriberIDs(strings.Split(post.Category, ",")); err == nil {
+		tell(subscribers, notifyCategory)
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+)
+
+// maxTopicsPerClient caps subscriptions, which grow with every post card on screen.
+const maxTopicsPerClient = 200
+
+// Topics a client can subscribe to. Events that only matter to whoever is
+// looking at something go to its topic instead of to everyone.
+const feedTopic = "feed" // every new post
+
+func postTopic(postID string) string       { return "post:" + postID }       // a post's votes and comments
+func categoryTopic(category string) string { return "category:" + category } // new posts in a category
+func userTopic(userID string) string       { return "user:" + userID }       // new posts by a user
+
+func validTopic(topic string) bool {
+	if topic == feedTopic {
+		return true
+	}
+	kind, id, ok := strings.Cut(topic, ":")
+	if !ok || id == "" || len(topic) > 100 {
+		return false
+	}
+	return kind == "post" || kind == "category" || kind == "user"
+}
+
+// postTopics are the topics a new post is published to.
+func postTopics(authorID, categories string) []string {
+	topics := []string{feedTopic, userTopic(authorID)}
+	for _, c := range strings.Split(categories, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			topics = append(topics, categoryTopic(c))
+		}
+	}
+	return topics
+}
+
+type topicsRequest struct {
+	Topics []string `json:"topics"`
+}
+
+func (c *Client) handleSubscribe(p topicsRequest) (map[string]any, error) {
+	for _, t := range p.Topics {
+		if !validTopic(t) {
+			return nil, invalidPayload("invalid topic " + t)
+		}
+	}
+
+	hub.topicsMu.Lock()
+	defer hub.topicsMu.Unlock()
+	if c.topics == nil {
+		c.topics = make(map[string]struct{})
+	}
+	for _, t := range p.Topics {
+		if _, ok := c.topics[t]; ok {
+			continue
+		}
+		if len(c.topics) >= maxTopicsPerClient {
+			return nil, invalidPayload("too many subscriptions")
+		}
+		c.topics[t] = struct{}{}
+		if hub.subscribers[t] == nil {
+			hub.subscribers[t] = make(map[*Client]struct{})
+		}
+		hub.subscribers[t][c] = struct{}{}
+	}
+	return map[string]any{"topics": c.topicList()}, nil
+}
+
+func (c *Client) handleUnsubscribe(p topicsRequest) (map[string]any, error) {
+	hub.topicsMu.Lock()
+	defer hub.topicsMu.Unlock()
+	for _, t := range p.Topics {
+		c.dropTopic(t)
+	}
+	return map[string]any{"topics": c.topicList()}, nil
+}
+
+// unsubscribeAll drops every subscription c has, e.g. on disconnect.
+func unsubscribeAll(c *Client) {
+	hub.topicsMu.Lock()
+	defer hub.topicsMu.Unlock()
+	for t := range c.topics {
+		c.dropTopic(t)
+	}
+}
+
+// dropTopic must be called with hub.topicsMu held.
+func (c *Client) dropTopic(t string) {
+	delete(c.topics, t)
+	delete(hub.subscribers[t], c)
+	if len(hub.subscribers[t]) == 0 {
+		delete(hub.subscribers, t)
+	}
+}
+
+// topicList must be called with hub.topicsMu held.
+func (c *Client) topicList() []string {
+	list := make([]string, 0, len(c.topics))
+	for t := range c.topics {
+		list = append(list, t)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// publish sends an event to the subscribers of any of topics, on every
+// instance. A client subscribed to several of them gets it once.
+func publish(topics []string, msgType string, payload any) {
+	publishTopics(topics, false, msgType, payload)
+}
+
+// publishOrBroadcast is publish for the events that went to everyone before
+// topics existed: clients that haven't opted into topics, such as ones that
+// never sent hello, still get every one of them.
+func publishOrBroadcast(topics []string, msgType string, payload any) {
+	publishTopics(topics, true, msgType, payload)
+}
+
+func publishTopics(topics []string, noTopics bool, msgType string, payload any) {
+	envelope, _ := json.Marshal(WSMessage{
+		Type:    msgType,
+		Payload: mustMarshal(payload),
+	})
+	if err := backend.Publish(hubMessage{Topics: topics, NoTopics: noTopics, Data: envelope}); err != nil {
+		log.Println("hub publish error:", err)
+	}
+}
+
+// deliverTopicsLocal sends envelope to this instance's subscribers of topics
+// and, if noTopics is set, to its clients that don't use topics.
+func deliverTopicsLocal(topics []string, noTopics bool, envelope []byte) {
+	hub.topicsMu.Lock()
+	clients := map[*Client]struct{}{}
+	for _, t := range topics {
+		for c := range hub.subscribers[t] {
+			clients[c] = struct{}{}
+		}
+	}
+	hub.topicsMu.Unlock()
+
+	if noTopics {
+		hub.mu.RLock()
+		all := make([]*Client, 0, len(hub.clients))
+		for _, c := range hub.clients {
+			all = append(all, c)
+		}
+		hub.mu.RUnlock()
+		for _, c := range all {
+			if !c.usesTopics() {
+				clients[c] = struct{}{}
+			}
+		}
+	}
+
+	for c := range clients {
+		c.enqueue(envelope)
+	}
+}
+
+// usesTopics reports whether c negotiated topics in hello, and so only wants
+// the topic events it subscribes to.
+func (c *Client) usesTopics() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capabilities["topics"]
+}
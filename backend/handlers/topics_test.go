@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+)
+
+// withCapabilities makes c behave as if it had negotiated caps in hello.
+func withCapabilities(c *Client, caps ...string) *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.capabilities = map[string]bool{}
+	for _, name := range caps {
+		c.capabilities[name] = true
+	}
+	return c
+}
+
+func subscribe(t *testing.T, c *Client, topics ...string) {
+	t.Helper()
+	if _, err := c.handleSubscribe(topicsRequest{Topics: topics}); err != nil {
+		t.Fatal("subscribe:", err)
+	}
+	t.Cleanup(func() { unsubscribeAll(c) })
+}
+
+func TestPublishOrBroadcast(t *testing.T) {
+	newUser := func() string {
+		userID, _ := newTestUser(t)
+		return userID
+	}
+	authorID := newUser()
+	legacy := newLocalClient(t, newUser())                                 // never sent hello
+	noTopics := withCapabilities(newLocalClient(t, newUser()), "presence") // left topics out
+	feed := withCapabilities(newLocalClient(t, newUser()), "topics")
+	subscribe(t, feed, feedTopic)
+	elsewhere := withCapabilities(newLocalClient(t, newUser()), "topics")
+	subscribe(t, elsewhere, categoryTopic("other"))
+
+	topics := postTopics(authorID, "go")
+	publishOrBroadcast(topics, "new_post", "post")
+	publish(topics, "post_deleted", "post")
+	tests := []struct {
+		name   string
+		c      *Client
+		events []string
+	}{
+		{"legacy", legacy, []string{"new_post"}},
+		{"without topics", noTopics, []string{"new_post"}},
+		{"subscribed", feed, []string{"new_post", "post_deleted"}},
+		{"subscribed elsewhere", elsewhere, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for len(tt.c.send) > 0 {
+			if msg := nextEvent(t, tt.c); msg.Type != "presence_update" {
+				got = append(got, msg.Type)
+			}
+		}
+		if !slices.Equal(got, tt.events) {
+			t.Errorf("%s got %v, want %v", tt.name, got, tt.events)
+		}
+	}
+}
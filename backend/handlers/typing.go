@@ -0,0 +1,96 @@
+package handlers
+
+import "time"
+
+// typingTimeout is how long a typing indicator stays up without a fresh
+// typing_start from the client.
+const typingTimeout = 6 * time.Second
+
+type typingKey struct {
+	from string
+	to   string
+}
+
+type typingRequest struct {
+	ReceiverID string `json:"receiver_id"`
+}
+
+func (p typingRequest) validate(userID string) error {
+	if p.ReceiverID == "" || p.ReceiverID == userID {
+		return invalidPayload("receiver_id must be another user")
+	}
+	return nil
+}
+
+func (c *Client) handleTypingStart(p typingRequest) (map[string]any, error) {
+	if err := p.validate(c.userID); err != nil {
+		return nil, err
+	}
+	// Typing would show an invisible user is online
+	if c.invisible() {
+		return nil, nil
+	}
+	startTyping(c.userID, p.ReceiverID)
+	return nil, nil
+}
+
+func (c *Client) handleTypingStop(p typingRequest) (map[string]any, error) {
+	if err := p.validate(c.userID); err != nil {
+		return nil, err
+	}
+	stopTyping(c.userID, p.ReceiverID)
+	return nil, nil
+}
+
+// startTyping relays typing_start to the receiver and (re)arms the expiry timer
+// so the indicator clears itself if the client never sends typing_stop.
+func startTyping(from, to string) {
+	key := typingKey{from: from, to: to}
+
+	hub.typingMu.Lock()
+	t, active := hub.typing[key]
+	if active {
+		t.Reset(typingTimeout)
+	} else {
+		hub.typing[key] = time.AfterFunc(typingTimeout, func() { stopTyping(from, to) })
+	}
+	hub.typingMu.Unlock()
+
+	// Clients repeat typing_start while typing; only relay the first one
+	if !active {
+		sendEphemeral(to, "typing_start", typingEvent{UserID: from})
+	}
+}
+
+// stopTyping clears an active typing indicator and relays typing_stop.
+func stopTyping(from, to string) {
+	key := typingKey{from: from, to: to}
+
+	hub.typingMu.Lock()
+	t, active := hub.typing[key]
+	if active {
+		t.Stop()
+		delete(hub.typing, key)
+	}
+	hub.typingMu.Unlock()
+
+	if active {
+		sendEphemeral(to, "typing_stop", typingEvent{UserID: from})
+	}
+}
+
+// stopAllTyping clears every indicator started by userID, e.g. on disconnect.
+func stopAllTyping(userID string) {
+	hub.typingMu.Lock()
+	var peers []string
+	for key := range hub.typing {
+		if key.from == userID {
+			peers = append(peers, key.to)
+		}
+	}
+	hub.typingMu.Unlock()
+
+	for _, to := range peers {
+		stopTyping(userID, to)
+	}
+}
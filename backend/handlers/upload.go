@@ -1,25 +1,63 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
+
+	"real-time-forum/db"
+	"real-time-forum/imaging"
+	"real-time-forum/models"
+	"real-time-forum/storage"
+
+	"github.com/google/uuid"
 )
 
-const (
-	maxUploadSize = 10 << 20 // 10 MB
-	uploadDir     = "./uploads"
+const maxUploadSize = 10 << 20 // 10 MB
+
+// UploadLimits caps how much each user can upload. Zero fields keep their
+// defaults, and a negative one removes the limit.
+type UploadLimits struct {
+	QuotaBytes   int64 // total size of the images a user keeps
+	DailyUploads int64 // images a user can upload per day, reset at midnight UTC
+}
+
+var uploadLimits = UploadLimits{
+	QuotaBytes:   200 << 20,
+	DailyUploads: 100,
+}
+
+// ConfigureUploads overrides the upload limits.
+func ConfigureUploads(limits UploadLimits) {
+	if limits.QuotaBytes != 0 {
+		uploadLimits.QuotaBytes = max(limits.QuotaBytes, 0)
+	}
+	if limits.DailyUploads != 0 {
+		uploadLimits.DailyUploads = max(limits.DailyUploads, 0)
+	}
+}
+
+// uploads is where uploaded files are kept, and signedURLTTL how long the
+// links handed out for them last when it can sign them; 0 serves every file
+// through this server instead.
+var (
+	uploads      storage.Storage = storage.NewLocal("./uploads")
+	signedURLTTL time.Duration
 )
 
-var allowedTypes = map[string]string{
-	"image/jpeg": ".jpg",
-	"image/png":  ".png",
-	"image/gif":  ".gif",
-	"image/webp": ".webp",
+// UseStorage keeps uploads in s. With a signedURLTTL, and storage that can
+// sign links, downloads are redirected to s for that long rather than passing
+// through this server.
+func UseStorage(s storage.Storage, ttl time.Duration) {
+	uploads = s
+	signedURLTTL = ttl
 }
 
 func Upload(w http.ResponseWriter, r *http.Request) {
@@ -40,73 +78,220 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, header, err := r.FormFile("image")
+	file, _, err := r.FormFile("image")
 	if err != nil {
 		jsonError(w, "image field is required", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
 
-	// Read first 512 bytes to detect content type
-	buf := make([]byte, 512)
-	n, _ := file.Read(buf)
-	contentType := http.DetectContentType(buf[:n])
+	data, err := io.ReadAll(file)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
 
-	ext, ok := allowedTypes[contentType]
-	if !ok {
-		jsonError(w, "only JPEG, PNG, GIF and WebP images are allowed", http.StatusBadRequest)
+	// Don't bother processing an image that can't be kept anyway
+	usage, err := db.UploadUsage(userID)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if uploadLimits.DailyUploads > 0 && usage.UploadsToday >= uploadLimits.DailyUploads {
+		uploadLimitError(w, db.ErrDailyUploadLimit)
+		return
+	}
+	if uploadLimits.QuotaBytes > 0 && usage.UsedBytes >= uploadLimits.QuotaBytes {
+		uploadLimitError(w, db.ErrStorageQuota)
 		return
 	}
 
-	// Seek back to start
-	if seeker, ok := file.(io.Seeker); ok {
-		seeker.Seek(0, io.SeekStart)
+	// Uploads of the same image share the stored file, found by the hash of
+	// what was uploaded. The hash stays internal: files are stored under
+	// random names and each upload is served from its own URL. The upload is
+	// the caller's to attach; until they do, it is cleaned up after a while.
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	a, shared := db.UploadedFile(hash)
+	if shared {
+		a.ID = uuid.NewString()
+		err = db.CreateAttachment(userID, &a, false, uploadLimits.QuotaBytes, uploadLimits.DailyUploads)
+		// It may have been cleaned up since it was looked up
+		shared = !errors.Is(err, db.ErrUploadedFileGone)
+	}
+	if !shared {
+		var written []string
+		a, written, err = storeImage(r.Context(), hash, data)
+		if errors.Is(err, imaging.ErrUnsupported) || errors.Is(err, imaging.ErrInvalid) || errors.Is(err, imaging.ErrTooLarge) {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println("store upload error:", err)
+			jsonError(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		a.ID = uuid.NewString()
+		err = db.CreateAttachment(userID, &a, true, uploadLimits.QuotaBytes, uploadLimits.DailyUploads)
+		// The names are new, so nothing else can be using these files unless
+		// they were recorded; an identical upload may have been recorded first
+		if err != nil || a.Filename != written[0] {
+			removeUploads(written)
+		}
+	}
+	if errors.Is(err, db.ErrDailyUploadLimit) || errors.Is(err, db.ErrStorageQuota) {
+		uploadLimitError(w, err)
+		return
 	}
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, http.StatusCreated, a)
+}
 
-	// Sanitize original name
-	origName := strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename))
-	if len(origName) > 32 {
-		origName = origName[:32]
+// storeImage processes an uploaded image whose bytes hash to hash, and stores
+// it and its variants under new random names. It returns the attachment
+// describing them along with the names written, the original first.
+func storeImage(ctx context.Context, hash string, data []byte) (a models.Attachment, written []string, err error) {
+	// Decoding the image checks it really is one, and what is stored is
+	// stripped of metadata such as where the photo was taken
+	img, err := imaging.Process(data)
+	if err != nil {
+		return a, nil, err
+	}
+	a = models.Attachment{
+		Hash:        hash,
+		ContentType: img.ContentType,
+		Size:        int64(len(img.Data)),
+		Width:       img.Width,
+		Height:      img.Height,
+	}
+	// Never reusing a name means a file being cleaned up can't be one an
+	// identical upload has just stored
+	base := uuid.NewString()
+	a.Filename = base + img.Ext
+	if err := uploads.Put(ctx, a.Filename, img.Data, img.ContentType); err != nil {
+		return a, nil, err
+	}
+	written = append(written, a.Filename)
+	for _, v := range img.Variants {
+		name := base + "_" + v.Name + v.Ext
+		if err := uploads.Put(ctx, name, v.Data, v.ContentType); err != nil {
+			removeUploads(written)
+			return a, nil, err
+		}
+		written = append(written, name)
+		switch v.Name {
+		case db.VariantThumb:
+			a.ThumbFilename = name
+		case db.VariantMedium:
+			a.MediumFilename = name
+		}
 	}
+	return a, written, nil
+}
 
-	// Build unique filename
-	ts := time.Now().UnixMilli()
-	filename := fmt.Sprintf("%d_%s%s", ts, sanitizeName(origName), ext)
+// removeUploads deletes stored files, logging rather than failing, since a
+// file left behind only costs space.
+func removeUploads(names []string) {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if err := uploads.Delete(context.Background(), name); err != nil {
+			log.Println("delete upload error:", err)
+		}
+	}
+}
 
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		jsonError(w, "internal server error", http.StatusInternalServerError)
+// uploadLimitError says which upload limit the caller has reached.
+func uploadLimitError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrDailyUploadLimit) {
+		jsonError(w, fmt.Sprintf("daily upload limit reached (%d images a day)", uploadLimits.DailyUploads), http.StatusTooManyRequests)
 		return
 	}
+	jsonError(w, "storage quota exceeded ("+sizeLabel(uploadLimits.QuotaBytes)+")", http.StatusRequestEntityTooLarge)
+}
 
-	dest, err := os.Create(filepath.Join(uploadDir, filename))
-	if err != nil {
-		jsonError(w, "internal server error", http.StatusInternalServerError)
+// sizeLabel writes n bytes in megabytes, or kilobytes when it is less.
+func sizeLabel(n int64) string {
+	if n < 1<<20 {
+		return fmt.Sprintf("%d KB", n>>10)
+	}
+	return fmt.Sprintf("%d MB", n>>20)
+}
+
+// UploadUsage returns how much of their upload allowance the caller has used.
+func UploadUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	defer dest.Close()
 
-	if _, err := io.Copy(dest, file); err != nil {
-		jsonError(w, "internal server error", http.StatusInternalServerError)
+	userID := userIDFromSession(r)
+	if userID == "" {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	url := "/uploads/" + filename
-	jsonOK(w, http.StatusOK, map[string]string{"url": url})
+	usage, err := db.UploadUsage(userID)
+	if err != nil {
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	usage.QuotaBytes = uploadLimits.QuotaBytes
+	usage.DailyLimit = uploadLimits.DailyUploads
+	jsonOK(w, http.StatusOK, usage)
 }
 
-func sanitizeName(s string) string {
-	var b strings.Builder
-	for _, r := range s {
-		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
-			(r >= '0' && r <= '9') || r == '-' || r == '_' {
-			b.WriteRune(r)
-		} else {
-			b.WriteRune('_')
+// ServeUpload serves an attachment's image at /uploads/{id}, and its smaller
+// variants at /uploads/{id}/medium and /uploads/{id}/thumb, either by
+// redirecting to a signed link or by streaming it from storage.
+func ServeUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, variant, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/uploads/"), "/")
+	name, ok := db.AttachmentFile(id, variant)
+	if !ok {
+		jsonError(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	if signer, ok := uploads.(storage.Signer); ok && signedURLTTL > 0 {
+		url, err := signer.SignedURL(r.Context(), name, signedURLTTL)
+		if errors.Is(err, storage.ErrNotFound) {
+			jsonError(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("sign upload url error:", err)
+			jsonError(w, "internal server error", http.StatusInternalServerError)
+			return
 		}
+		// Browsers may reuse the redirect only while the link still works
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(signedURLTTL.Seconds()/2)))
+		http.Redirect(w, r, url, http.StatusFound)
+		return
 	}
-	result := b.String()
-	if len(result) == 0 {
-		return "img"
+
+	f, err := uploads.Open(r.Context(), name)
+	if errors.Is(err, storage.ErrNotFound) {
+		jsonError(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("open upload error:", err)
+		jsonError(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if f.ContentType != "" {
+		w.Header().Set("Content-Type", f.ContentType)
 	}
-	return result
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, f.ModTime, f)
 }
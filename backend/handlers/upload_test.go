@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"real-time-forum/db"
+	"real-time-forum/models"
+	"real-time-forum/storage"
+)
+
+// useTestStorage keeps uploads in a new directory for the rest of the test.
+func useTestStorage(t *testing.T, wrap func(*storage.Local) storage.Storage) string {
+	t.Helper()
+	prev, prevTTL := uploads, signedURLTTL
+	t.Cleanup(func() { UseStorage(prev, prevTTL) })
+
+	dir := filepath.Join(t.TempDir(), "uploads")
+	local := storage.NewLocal(dir)
+	if wrap == nil {
+		UseStorage(local, 0)
+	} else {
+		UseStorage(wrap(local), time.Minute)
+	}
+	return dir
+}
+
+// testImages makes every testPNG different, so uploads from earlier tests,
+// whose storage is gone, are never shared.
+var testImages atomic.Uint32
+
+// testPNG encodes a w×h image.
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 100, 255})
+		}
+	}
+	n := testImages.Add(1)
+	img.Set(0, 0, color.RGBA{uint8(n), uint8(n >> 8), uint8(n >> 16), 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// uploadImage uploads data as token's user and returns the new attachment.
+func uploadImage(t *testing.T, token string, data []byte) models.Attachment {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("image", "photo.png")
+	part.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Session-Token", token)
+	rec := httptest.NewRecorder()
+	Upload(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+	}
+	var a models.Attachment
+	if err := json.Unmarshal(rec.Body.Bytes(), &a); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func serveUpload(method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	ServeUpload(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+func TestServeUpload(t *testing.T) {
+	useTestStorage(t, nil)
+	_, token := newTestUser(t)
+	// Big enough for a thumbnail, too small for a medium copy
+	a := uploadImage(t, token, testPNG(t, 600, 400))
+
+	if a.URL != "/uploads/"+a.ID {
+		t.Errorf("url %s, want /uploads/%s", a.URL, a.ID)
+	}
+	tests := []struct {
+		url   string
+		width int
+	}{
+		{a.URL, 600},
+		{a.MediumURL, 600}, // falls back to the original
+		{a.ThumbnailURL, 320},
+	}
+	for _, tt := range tests {
+		rec := serveUpload(http.MethodGet, tt.url)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: %d", tt.url, rec.Code)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "image/") {
+			t.Errorf("%s: content type %q", tt.url, ct)
+		}
+		if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: not marked nosniff", tt.url)
+		}
+		cfg, _, err := image.DecodeConfig(rec.Body)
+		if err != nil || cfg.Width != tt.width {
+			t.Errorf("%s: served %d wide (%v), want %d", tt.url, cfg.Width, err, tt.width)
+		}
+	}
+
+	if rec := serveUpload(http.MethodHead, a.URL); rec.Code != http.StatusOK {
+		t.Errorf("HEAD: %d", rec.Code)
+	}
+	if rec := serveUpload(http.MethodPost, a.URL); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: %d", rec.Code)
+	}
+}
+
+func TestServeUploadNotFound(t *testing.T) {
+	dir := useTestStorage(t, nil)
+	_, token := newTestUser(t)
+	a := uploadImage(t, token, testPNG(t, 40, 40))
+	stored, _ := db.AttachmentFile(a.ID, "")
+
+	// Something next to the uploads directory that mustn't be reachable
+	os.WriteFile(filepath.Join(filepath.Dir(dir), "secret.txt"), []byte("secret"), 0644)
+
+	for _, path := range []string{
+		"/uploads/",
+		"/uploads/unknown",
+		"/uploads/" + a.ID + "/huge",
+		"/uploads/" + stored, // files aren't served by their stored name
+		"/uploads/../secret.txt",
+		"/uploads/..%2fsecret.txt",
+		"/uploads/%2e%2e/secret.txt",
+		"/uploads/" + a.ID + "/../../secret.txt",
+	} {
+		rec := serveUpload(http.MethodGet, path)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: %d, want 404", path, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "secret") {
+			t.Errorf("%s: served the secret", path)
+		}
+	}
+}
+
+func TestUploadSharesFilesPrivately(t *testing.T) {
+	useTestStorage(t, nil)
+	_, alice := newTestUser(t)
+	_, bob := newTestUser(t)
+	data := testPNG(t, 50, 50)
+
+	a := uploadImage(t, alice, data)
+	b := uploadImage(t, bob, data)
+	if a.URL == b.URL {
+		t.Error("identical uploads got the same URL")
+	}
+	fileA, _ := db.AttachmentFile(a.ID, "")
+	fileB, _ := db.AttachmentFile(b.ID, "")
+	if fileA != fileB {
+		t.Errorf("identical uploads stored twice: %s and %s", fileA, fileB)
+	}
+
+	// Knowing an image mustn't be enough to find out whether it was uploaded
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	for _, s := range []string{a.URL, a.ThumbnailURL, a.MediumURL, fileA} {
+		if strings.Contains(s, hash) {
+			t.Errorf("%s gives away the image's hash", s)
+		}
+	}
+}
+
+// signingStorage is local storage that pretends it can sign links.
+type signingStorage struct {
+	*storage.Local
+}
+
+func (signingStorage) SignedURL(ctx context.Context, name string, ttl time.Duration) (string, error) {
+	return "https://bucket.example/" + name + "?ttl=" + ttl.String(), nil
+}
+
+func TestServeUploadSigned(t *testing.T) {
+	useTestStorage(t, func(l *storage.Local) storage.Storage { return signingStorage{l} })
+	_, token := newTestUser(t)
+	a := uploadImage(t, token, testPNG(t, 40, 40))
+	stored, _ := db.AttachmentFile(a.ID, "")
+
+	rec := serveUpload(http.MethodGet, a.URL)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("%d, want a redirect", rec.Code)
+	}
+	if want := "https://bucket.example/" + stored + "?ttl=1m0s"; rec.Header().Get("Location") != want {
+		t.Errorf("redirected to %s, want %s", rec.Header().Get("Location"), want)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "private, max-age=30" {
+		t.Errorf("cache control %q", cc)
+	}
+	if rec := serveUpload(http.MethodGet, "/uploads/unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown upload: %d", rec.Code)
+	}
+}
@@ -46,13 +46,19 @@ func Vote(w http.ResponseWriter, r *http.Request) {
 
 	upvotes, downvotes, userVote := db.GetVoteSummary(req.PostID, userID)
 
-	// Notify all connected clients about the updated vote counts
-	BroadcastAll("vote_update", map[string]any{
-		"post_id":   req.PostID,
-		"upvotes":   upvotes,
-		"downvotes": downvotes,
+	// Notify everyone looking at the post about the updated vote counts
+	publishOrBroadcast([]string{postTopic(req.PostID)}, "vote_update", voteUpdateEvent{
+		PostID:    req.PostID,
+		Upvotes:   upvotes,
+		Downvotes: downvotes,
 	})
 
+	if userVote == 1 && existingValue != 1 {
+		if authorID, err := db.GetPostOwnerID(req.PostID); err == nil {
+			notify(authorID, notifyVote, req.PostID, "", userID)
+		}
+	}
+
 	jsonOK(w, http.StatusOK, map[string]int{
 		"upvotes":   upvotes,
 		"downvotes": downvotes,
@@ -2,12 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"hash/fnv"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"real-time-forum/db"
+	"real-time-forum/models"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -17,30 +21,106 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
+// WSConfig controls WebSocket liveness checks and limits.
+type WSConfig struct {
+	PingInterval   time.Duration // how often the server pings each client
+	PongWait       time.Duration // how long a client may stay silent, pongs included, before it is dropped
+	WriteWait      time.Duration // deadline for writing a single frame
+	MaxMessageSize int64         // largest inbound message in bytes
+}
+
+var wsConfig = WSConfig{
+	PingInterval:   25 * time.Second,
+	PongWait:       60 * time.Second,
+	WriteWait:      10 * time.Second,
+	MaxMessageSize: 32 << 10,
+}
+
+// ConfigureWS overrides the WebSocket settings. Zero fields keep their
+// defaults, and the ping interval is capped below the pong wait so a healthy
+// client is never dropped between two pings.
+func ConfigureWS(cfg WSConfig) {
+	if cfg.PingInterval > 0 {
+		wsConfig.PingInterval = cfg.PingInterval
+	}
+	if cfg.PongWait > 0 {
+		wsConfig.PongWait = cfg.PongWait
+	}
+	if cfg.WriteWait > 0 {
+		wsConfig.WriteWait = cfg.WriteWait
+	}
+	if cfg.MaxMessageSize > 0 {
+		wsConfig.MaxMessageSize = cfg.MaxMessageSize
+	}
+	if wsConfig.PingInterval >= wsConfig.PongWait {
+		wsConfig.PingInterval = wsConfig.PongWait * 9 / 10
+	}
+}
+
 type Client struct {
 	conn   *websocket.Conn
 	userID string
 	send   chan []byte
+	cfg    WSConfig // wsConfig when the client connected
+
+	mu     sync.Mutex
+	closed bool
+
+	// what hello negotiated, guarded by mu; capabilities stays nil until
+	// then and the client gets every event
+	protocol     int
+	capabilities map[string]bool
+
+	// presence, guarded by mu
+	status     string
+	statusText string
+	lastActive time.Time
+	idle       bool
+
+	topics map[string]struct{} // guarded by hub.topicsMu
 }
 
+// Hub holds the WebSocket connections on this instance. What has to be shared
+// with other instances goes through the hub backend.
 type Hub struct {
 	mu      sync.RWMutex
 	clients map[string]*Client
+
+	// eventMu keeps sequence numbers and delivery order in step, so a client
+	// never sees seq N+1 before seq N and a resume never interleaves with live
+	// events. Order only matters per user, so users are spread over shards and
+	// a slow event log write holds up only the users sharing its shard.
+	eventMu [64]sync.Mutex
+
+	typingMu sync.Mutex
+	typing   map[typingKey]*time.Timer
+
+	// topicsMu guards subscribers and every client's topics
+	topicsMu    sync.Mutex
+	subscribers map[string]map[*Client]struct{}
 }
 
 var hub = &Hub{
-	clients: make(map[string]*Client),
+	clients:     make(map[string]*Client),
+	typing:      make(map[typingKey]*time.Timer),
+	subscribers: make(map[string]map[*Client]struct{}),
 }
 
 type WSMessage struct {
-	Type    string          `json:"type"`
+	Type string `json:"type"`
+	// ID is an optional request ID chosen by the client, echoed in the reply.
+	ID      string          `json:"id,omitempty"`
 	Payload json.RawMessage `json:"payload"`
+	Seq     int64           `json:"seq,omitempty"`
+	// Silent asks the client not to alert the user (sound, toast) for this event.
+	Silent bool `json:"silent,omitempty"`
 }
 
 type SendMessagePayload struct {
-	ReceiverID string `json:"receiver_id"`
-	Content    string `json:"content"`
-	ImageURL   string `json:"image_url"`
+	ClientID    string              `json:"client_id"`
+	ReceiverID  string              `json:"receiver_id"`
+	Content     string              `json:"content"`
+	Attachments []models.Attachment `json:"attachments"`
 }
 
 func ServeWS(w http.ResponseWriter, r *http.Request) {
@@ -50,168 +130,430 @@ func ServeWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	lastSeq, resume, err := resumePoint(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("ws upgrade error:", err)
 		return
 	}
 
-	client := &Client{
-		conn:   conn,
-		userID: userID,
-		send:   make(chan []byte, 256),
+	// The write pump has to be draining the buffer while attach replays
+	client := newClient(userID, conn)
+	go client.writePump()
+	attach(client, lastSeq, resume)
+	client.readPump()
+	detach(client)
+}
+
+// resumePoint reads ?last_seq=N, which asks for every logged event after N to
+// be replayed before live events.
+func resumePoint(r *http.Request) (lastSeq int64, resume bool, err error) {
+	if !r.URL.Query().Has("last_seq") {
+		return 0, false, nil
+	}
+	lastSeq, err = strconv.ParseInt(r.URL.Query().Get("last_seq"), 10, 64)
+	if err != nil || lastSeq < 0 {
+		return 0, false, errors.New("invalid last_seq")
 	}
+	return lastSeq, true, nil
+}
 
+// newClient sets up userID's client with their saved status. conn is nil for
+// clients on the SSE and long-poll transports.
+func newClient(userID string, conn *websocket.Conn) *Client {
+	status, statusText := db.GetUserStatus(userID)
+	return &Client{
+		conn:       conn,
+		userID:     userID,
+		send:       make(chan []byte, 256),
+		cfg:        wsConfig,
+		status:     status,
+		statusText: statusText,
+		lastActive: time.Now(),
+	}
+}
+
+// attach makes c its user's connection, replacing any other one, replays what
+// it missed and sends the initial state. Whatever the transport, events reach
+// the client through c.send.
+func attach(c *Client, lastSeq int64, resume bool) {
+	mu := eventLock(c.userID)
+	mu.Lock()
 	hub.mu.Lock()
-	if old, exists := hub.clients[userID]; exists {
-		kick, _ := json.Marshal(WSMessage{Type: "force_logout", Payload: mustMarshal("logged in elsewhere")})
-		select {
-		case old.send <- kick:
-		default:
-		}
-		go func(old *Client) {
-			time.Sleep(2 * time.Second)
-			old.conn.Close()
-		}(old)
+	if old, exists := hub.clients[c.userID]; exists {
+		kickClient(old)
 	}
-	hub.clients[userID] = client
+	hub.clients[c.userID] = c
 	hub.mu.Unlock()
+	sendHello(c)
+	c.replay(lastSeq, resume)
+	mu.Unlock()
+
+	// A connection on another instance has to go too
+	if prev, existed := c.publishPresence(); existed && prev.Instance != instanceID {
+		if err := backend.Publish(hubMessage{UserID: c.userID, Kick: prev.Instance}); err != nil {
+			log.Println("hub publish error:", err)
+		}
+	}
+	sendUserList(c)
+	sendOwnStatus(c)
+	if !c.invisible() {
+		deliverPending(c.userID)
+	}
+}
 
-	broadcastPresence()
-	sendUserList(client)
-
-	go client.writePump()
-	client.readPump()
-
+// detach cleans up after c's connection has ended.
+func detach(c *Client) {
 	hub.mu.Lock()
-	if hub.clients[userID] == client {
-		delete(hub.clients, userID)
+	current := hub.clients[c.userID] == c
+	if current {
+		delete(hub.clients, c.userID)
 	}
 	hub.mu.Unlock()
-	close(client.send)
-	broadcastPresence()
+	c.shutdown()
+	unsubscribeAll(c)
+	stopAllTyping(c.userID)
+	if c.presenceEntry().visible().Status != statusOffline {
+		db.TouchLastSeen([]string{c.userID})
+	}
+	if current {
+		if err := backend.ReleasePresence(c.userID, instanceID); err != nil {
+			log.Println("release presence error:", err)
+		}
+	}
+	presence.changed(c.userID)
+}
+
+// kickClient tells c its user logged in elsewhere and ends its connection
+// once the notice has gone out.
+func kickClient(c *Client) {
+	kick, _ := json.Marshal(WSMessage{Type: "force_logout", Payload: mustMarshal("logged in elsewhere")})
+	c.enqueue(kick)
+	c.shutdown()
+}
+
+// replay sends the events logged after lastSeq, followed by a "resumed" marker
+// carrying the latest seq. complete is false when the log no longer reaches back
+// to lastSeq and the client has to refetch its state over REST instead. A
+// replay can be far bigger than the send buffer, so something has to be
+// reading c.send while it runs.
+// Must be called with c's user's eventLock held.
+func (c *Client) replay(lastSeq int64, resume bool) {
+	events, complete := []models.Event{}, true
+	if resume {
+		var err error
+		events, complete, err = db.EventsSince(c.userID, lastSeq)
+		if err != nil {
+			log.Println("replay events error:", err)
+			events, complete = nil, false
+		}
+	}
+	for _, e := range events {
+		data, _ := json.Marshal(WSMessage{Type: e.Type, Payload: e.Payload, Seq: e.Seq})
+		if !c.enqueueWait(data) {
+			return
+		}
+	}
+
+	data, _ := json.Marshal(WSMessage{Type: "resumed", Payload: mustMarshal(resumedEvent{
+		LastSeq:  db.LastEventSeq(c.userID),
+		Replayed: len(events),
+		Complete: complete,
+	})})
+	c.enqueueWait(data)
 }
 
+// readPump handles incoming messages until the connection fails. Every frame,
+// including the pongs answering writePump's pings, pushes the read deadline
+// back, so a dead TCP connection is noticed within PongWait.
 func (c *Client) readPump() {
 	defer c.conn.Close()
 
+	c.conn.SetReadLimit(c.cfg.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.cfg.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.cfg.PongWait))
+	})
+
 	for {
 		_, raw, err := c.conn.ReadMessage()
 		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("ws read error for %s: %v", c.userID, err)
+			}
 			break
 		}
+		c.conn.SetReadDeadline(time.Now().Add(c.cfg.PongWait))
 
 		var msg WSMessage
 		if err := json.Unmarshal(raw, &msg); err != nil {
+			c.replyError(requestRef{}, protocolError{codeBadJSON, "message is not a JSON envelope"})
 			continue
 		}
-
-		switch msg.Type {
-		case "send_message":
-			c.handleSendMessage(msg.Payload)
-		case "mark_read":
-			c.handleMarkRead(msg.Payload)
-		}
+		c.dispatch(msg)
 	}
 }
 
+// writePump writes queued messages and pings the client every PingInterval.
+// Each write has a deadline so a client that stops reading can't block it.
+// When the send channel is closed it says goodbye with a close frame.
 func (c *Client) writePump() {
-	for data := range c.send {
-		if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-			break
+	ticker := time.NewTicker(c.cfg.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
 
-func (c *Client) handleSendMessage(raw json.RawMessage) {
-	var p SendMessagePayload
-	if err := json.Unmarshal(raw, &p); err != nil {
-		return
+// enqueue hands data to the write pump. A client whose buffer is full is
+// disconnected instead of silently missing the event; it catches up by
+// resuming from its last seq when it reconnects.
+func (c *Client) enqueue(data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
 	}
+	if !c.wantsLocked(data) {
+		return true // left out on purpose, not lost
+	}
+	select {
+	case c.send <- data:
+		return true
+	default:
+		log.Printf("ws send buffer full for %s, dropping connection", c.userID)
+		c.closed = true
+		close(c.send)
+		return false
+	}
+}
 
-	// Must have either text content or an image (or both)
-	if p.Content == "" && p.ImageURL == "" {
-		return
+// enqueueWait is enqueue for a replay, which queues far more at once than the
+// buffer holds: when it is full, it waits up to WriteWait for the client to
+// make room before giving up on the connection.
+func (c *Client) enqueueWait(data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
 	}
-	if p.ReceiverID == "" {
-		return
+	if !c.wantsLocked(data) {
+		return true
+	}
+	select {
+	case c.send <- data:
+		return true
+	default:
 	}
 
-	msgID := uuid.NewString()
-	if err := db.CreateMessage(msgID, c.userID, p.ReceiverID, p.Content, p.ImageURL); err != nil {
-		log.Println("insert message error:", err)
-		return
+	timer := time.NewTimer(c.cfg.WriteWait)
+	defer timer.Stop()
+	select {
+	case c.send <- data:
+		return true
+	case <-timer.C:
+		log.Printf("%s stopped reading during replay, dropping connection", c.userID)
+		c.closed = true
+		close(c.send)
+		return false
 	}
+}
 
-	msg, err := db.GetMessageByID(msgID)
+// shutdown stops the write pump. It is safe to call more than once.
+func (c *Client) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+func (c *Client) handleSendMessage(p SendMessagePayload) (map[string]any, error) {
+	msg, duplicate, err := c.sendMessage(p)
 	if err != nil {
-		log.Println("fetch message error:", err)
-		return
+		return nil, err
 	}
+	return map[string]any{"message": msg, "duplicate": duplicate}, nil
+}
 
-	envelope, _ := json.Marshal(WSMessage{
-		Type:    "new_message",
-		Payload: mustMarshal(msg),
-	})
+// sendMessage stores a message and delivers it to both participants. A retry
+// carrying a client_id that was already stored returns the original message
+// with duplicate set instead of inserting it twice.
+func (c *Client) sendMessage(p SendMessagePayload) (msg models.Message, duplicate bool, err error) {
+	// Must have either text content or an image (or both)
+	if p.Content == "" && len(p.Attachments) == 0 {
+		return msg, false, errMessageEmpty
+	}
+	if p.ReceiverID == "" || p.ReceiverID == c.userID || !db.UserExists(p.ReceiverID) {
+		return msg, false, invalidPayload("unknown receiver")
+	}
 
-	hub.mu.RLock()
-	receiver, online := hub.clients[p.ReceiverID]
-	hub.mu.RUnlock()
+	if p.ClientID != "" {
+		if msg, err := db.GetMessageByClientID(c.userID, p.ClientID); err == nil {
+			return msg, true, nil
+		}
+	}
 
-	if online {
-		select {
-		case receiver.send <- envelope:
-		default:
+	attachments, errMsg := prepareAttachments(c.userID, p.Attachments)
+	if errMsg != "" {
+		return msg, false, invalidPayload(errMsg)
+	}
+
+	msgID := uuid.NewString()
+	if err := db.CreateMessage(msgID, c.userID, p.ReceiverID, p.Content, p.ClientID); err != nil {
+		// A concurrent retry may have won the race on the unique client_id
+		if p.ClientID != "" {
+			if msg, err := db.GetMessageByClientID(c.userID, p.ClientID); err == nil {
+				return msg, true, nil
+			}
 		}
+		return msg, false, err
 	}
+	if err := db.SetAttachments(c.userID, db.AttachedToMessage, msgID, attachments); err != nil {
+		db.DeleteMessage(msgID)
+		if errors.Is(err, db.ErrAttachmentNotFound) {
+			return msg, false, invalidPayload("attachment not found")
+		}
+		return msg, false, err
+	}
+	// Mentions in a private message are only rendered, not notified: the
+	// receiver hears about the message anyway and nobody else can read it.
+	saveMentions(db.MentionInMessage, msgID, p.Content)
 
-	select {
-	case c.send <- envelope:
-	default:
+	// Sending a message ends the sender's typing indicator
+	stopTyping(c.userID, p.ReceiverID)
+
+	if appearsOnline(p.ReceiverID) {
+		db.MarkMessageDelivered(msgID)
+	}
+
+	msg, err = db.GetMessageByID(msgID)
+	if err != nil {
+		return msg, false, err
 	}
 
-	broadcastPresence()
+	// Both sides bump the conversation and unread badge from the event itself.
+	// A receiver on do not disturb still gets the message, since the open chat
+	// would otherwise be out of date, but marked silent so the client doesn't
+	// alert; notifications, which are only alerts, are held back instead.
+	sendToUser(p.ReceiverID, "new_message", msg, withSilent(isDND(p.ReceiverID)))
+	sendToUser(c.userID, "new_message", msg)
+	return msg, false, nil
 }
 
-func (c *Client) handleMarkRead(raw json.RawMessage) {
-	var p struct {
-		SenderID string `json:"sender_id"`
+type markReadRequest struct {
+	SenderID string `json:"sender_id"`
+}
+
+func (c *Client) handleMarkRead(p markReadRequest) (map[string]any, error) {
+	if p.SenderID == "" {
+		return nil, invalidPayload("sender_id is required")
 	}
-	if err := json.Unmarshal(raw, &p); err != nil || p.SenderID == "" {
-		return
+	ids, readAt, err := db.MarkMessagesRead(c.userID, p.SenderID)
+	if err != nil {
+		return nil, err
 	}
-	db.MarkMessagesRead(c.userID, p.SenderID)
-	// Refresh this client's user list so badge clears immediately
-	sendUserList(c)
+	// Let the sender show "seen" on the messages that were just read, unless
+	// the reader is invisible
+	if len(ids) > 0 && !c.invisible() {
+		sendToUser(p.SenderID, "message_read", readEvent{
+			ReaderID:   c.userID,
+			MessageIDs: ids,
+			ReadAt:     readAt,
+		})
+	}
+	return map[string]any{"message_ids": ids}, nil
 }
 
-type UserStatus struct {
-	ID          string `json:"id"`
-	Nickname    string `json:"nickname"`
-	Online      bool   `json:"online"`
-	LastMsg     string `json:"last_msg"`
-	UnreadCount int    `json:"unread_count"`
+func (c *Client) handleEditMessage(p messageActionRequest) (map[string]any, error) {
+	if p.MessageID == "" {
+		return nil, invalidPayload("message_id is required")
+	}
+	msg, err := editMessage(c.userID, p.MessageID, p.Content)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"message": msg}, nil
 }
 
-func broadcastPresence() {
-	hub.mu.RLock()
-	allClients := make([]*Client, 0, len(hub.clients))
-	for _, c := range hub.clients {
-		allClients = append(allClients, c)
+func (c *Client) handleUnsendMessage(p messageActionRequest) (map[string]any, error) {
+	if p.MessageID == "" {
+		return nil, invalidPayload("message_id is required")
 	}
-	hub.mu.RUnlock()
+	if err := unsendMessage(c.userID, p.MessageID); err != nil {
+		return nil, err
+	}
+	return map[string]any{"message_id": p.MessageID}, nil
+}
 
-	for _, c := range allClients {
-		sendUserList(c)
+func (c *Client) handleDeleteMessage(p messageActionRequest) (map[string]any, error) {
+	if p.MessageID == "" {
+		return nil, invalidPayload("message_id is required")
 	}
+	if err := deleteMessageForMe(c.userID, p.MessageID); err != nil {
+		return nil, err
+	}
+	return map[string]any{"message_id": p.MessageID}, nil
 }
 
-// BroadcastAll sends a WS envelope to every connected client.
+// deliverPending stamps messages that arrived while userID was offline as delivered
+// and tells each online sender which of their messages reached the receiver.
+func deliverPending(userID string) {
+	bySender, deliveredAt, err := db.MarkPendingDelivered(userID)
+	if err != nil {
+		log.Println("mark delivered error:", err)
+		return
+	}
+	for senderID, ids := range bySender {
+		sendToUser(senderID, "message_delivered", deliveredEvent{
+			ReceiverID:  userID,
+			MessageIDs:  ids,
+			DeliveredAt: deliveredAt,
+		})
+	}
+}
+
+// BroadcastAll sends a WS envelope to every connected client on every
+// instance. Broadcasts are not logged per user; clients refetch the feed after
+// an incomplete resume.
 func BroadcastAll(msgType string, payload any) {
 	envelope, _ := json.Marshal(WSMessage{
 		Type:    msgType,
 		Payload: mustMarshal(payload),
 	})
+	if err := backend.Publish(hubMessage{Data: envelope}); err != nil {
+		log.Println("hub publish error:", err)
+	}
+}
+
+// broadcastLocal sends envelope to every client connected to this instance.
+func broadcastLocal(envelope []byte) {
 	hub.mu.RLock()
 	clients := make([]*Client, 0, len(hub.clients))
 	for _, c := range hub.clients {
@@ -219,46 +561,72 @@ func BroadcastAll(msgType string, payload any) {
 	}
 	hub.mu.RUnlock()
 	for _, c := range clients {
-		select {
-		case c.send <- envelope:
-		default:
-		}
+		c.enqueue(envelope)
 	}
 }
 
-func sendUserList(c *Client) {
-	usersFromDB, err := db.GetAllUsersExcept(c.userID)
+// sendOption adjusts an envelope before it is delivered.
+type sendOption func(*WSMessage)
+
+// withSilent marks the envelope as one the client shouldn't alert for.
+func withSilent(silent bool) sendOption {
+	return func(m *WSMessage) { m.Silent = silent }
+}
+
+// sendToUser records an event in userID's log under the next sequence number
+// and delivers it if they are connected, reporting whether they were. Events
+// logged while the user is offline or reconnecting are replayed on resume.
+func sendToUser(userID, msgType string, payload any, opts ...sendOption) bool {
+	data := mustMarshal(payload)
+
+	mu := eventLock(userID)
+	mu.Lock()
+	defer mu.Unlock()
+
+	seq, err := db.AppendEvent(userID, msgType, data)
 	if err != nil {
-		return
+		log.Println("append event error:", err)
 	}
+	msg := WSMessage{Type: msgType, Payload: data, Seq: seq}
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	return deliver(userID, msg)
+}
+
+// eventLock returns the lock that orders userID's events.
+func eventLock(userID string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return &hub.eventMu[h.Sum32()%uint32(len(hub.eventMu))]
+}
+
+// sendEphemeral delivers an event that isn't worth replaying later, such as
+// typing indicators and request acks.
+func sendEphemeral(userID, msgType string, payload any) bool {
+	return deliver(userID, WSMessage{Type: msgType, Payload: mustMarshal(payload)})
+}
+
+// deliver sends msg to userID's connection, going through the hub backend when
+// that connection is on another instance.
+func deliver(userID string, msg WSMessage) bool {
+	envelope, _ := json.Marshal(msg)
 
 	hub.mu.RLock()
-	onlineIDs := make(map[string]bool)
-	for id := range hub.clients {
-		onlineIDs[id] = true
-	}
+	c, local := hub.clients[userID]
 	hub.mu.RUnlock()
-
-	var users []UserStatus
-	for _, u := range usersFromDB {
-		users = append(users, UserStatus{
-			ID:          u.ID,
-			Nickname:    u.Nickname,
-			Online:      onlineIDs[u.ID],
-			LastMsg:     db.GetLastMessageTimeBetween(c.userID, u.ID),
-			UnreadCount: db.GetUnreadCount(c.userID, u.ID),
-		})
+	if local {
+		return c.enqueue(envelope)
 	}
 
-	envelope, _ := json.Marshal(WSMessage{
-		Type:    "user_list",
-		Payload: mustMarshal(users),
-	})
-
-	select {
-	case c.send <- envelope:
-	default:
+	if _, online := lookupPresence(userID); !online {
+		return false
+	}
+	if err := backend.Publish(hubMessage{UserID: userID, Data: envelope}); err != nil {
+		log.Println("hub publish error:", err)
+		return false
 	}
+	return true
 }
 
 func mustMarshal(v any) json.RawMessage {
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/db"
+
+	"github.com/gorilla/websocket"
+)
+
+// useWSConfig applies cfg for the rest of the test.
+func useWSConfig(t *testing.T, cfg WSConfig) {
+	t.Helper()
+	prev := wsConfig
+	t.Cleanup(func() { wsConfig = prev })
+	ConfigureWS(cfg)
+}
+
+// dialWS connects to a test server running ServeWS as token's user.
+func dialWS(t *testing.T, srv *httptest.Server, token string) *websocket.Conn {
+	t.Helper()
+	return dialWSURL(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?token="+token)
+}
+
+// dialWSResume connects like dialWS, asking for the events after lastSeq.
+func dialWSResume(t *testing.T, srv *httptest.Server, token string, lastSeq int64) *websocket.Conn {
+	t.Helper()
+	return dialWSURL(t, fmt.Sprintf("ws%s/ws?token=%s&last_seq=%d", strings.TrimPrefix(srv.URL, "http"), token, lastSeq))
+}
+
+func dialWSURL(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// waitFor polls cond until it holds, failing the test after timeout.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// localClient returns userID's connection on this instance, if any.
+func localClient(userID string) *Client {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return hub.clients[userID]
+}
+
+func connected(userID string) func() bool {
+	return func() bool { return localClient(userID) != nil }
+}
+
+func disconnected(userID string) func() bool {
+	return func() bool {
+		_, online := lookupPresence(userID)
+		return localClient(userID) == nil && !online
+	}
+}
+
+// readUntilError reads from conn until the connection ends, or timeout
+// passes, and returns the error it ended with.
+func readUntilError(conn *websocket.Conn, timeout time.Duration) error {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return err
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func newWSServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(ServeWS))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWSPongTimeout(t *testing.T) {
+	useWSConfig(t, WSConfig{PingInterval: 50 * time.Millisecond, PongWait: 200 * time.Millisecond})
+	srv := newWSServer(t)
+	userID, token := newTestUser(t)
+
+	conn := dialWS(t, srv, token)
+	waitFor(t, time.Second, "the client is attached", connected(userID))
+
+	// Keep reading, so the connection isn't stalled, but never answer a ping
+	conn.SetPingHandler(func(string) error { return nil })
+	start := time.Now()
+	if err := readUntilError(conn, 5*time.Second); isTimeout(err) {
+		t.Fatal("the server never dropped the connection:", err)
+	}
+	waitFor(t, time.Second, "the client is detached", disconnected(userID))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("dropped after %v, want about the pong wait", elapsed)
+	}
+}
+
+func TestWSPongKeepsAlive(t *testing.T) {
+	useWSConfig(t, WSConfig{PingInterval: 50 * time.Millisecond, PongWait: 200 * time.Millisecond})
+	srv := newWSServer(t)
+	userID, token := newTestUser(t)
+
+	// The default ping handler answers with a pong while the client reads
+	conn := dialWS(t, srv, token)
+	waitFor(t, time.Second, "the client is attached", connected(userID))
+	if err := readUntilError(conn, time.Second); !isTimeout(err) {
+		t.Fatal("a client answering pings was dropped:", err)
+	}
+	if localClient(userID) == nil {
+		t.Error("a client answering pings was detached")
+	}
+}
+
+func TestWSMaxMessageSize(t *testing.T) {
+	useWSConfig(t, WSConfig{MaxMessageSize: 1024})
+	srv := newWSServer(t)
+	userID, token := newTestUser(t)
+
+	conn := dialWS(t, srv, token)
+	waitFor(t, time.Second, "the client is attached", connected(userID))
+
+	big, _ := json.Marshal(WSMessage{Type: "activity", Payload: json.RawMessage(`"` + strings.Repeat("x", 4096) + `"`)})
+	if err := conn.WriteMessage(websocket.TextMessage, big); err != nil {
+		t.Fatal("write:", err)
+	}
+	err := readUntilError(conn, 2*time.Second)
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("connection ended with %v, want close 1009 (message too big)", err)
+	}
+	waitFor(t, time.Second, "the client is detached", disconnected(userID))
+}
+
+func TestWSWriteDeadline(t *testing.T) {
+	// A long pong wait, so only the write deadline can end the connection
+	useWSConfig(t, WSConfig{PingInterval: time.Minute, PongWait: 2 * time.Minute, WriteWait: 200 * time.Millisecond})
+	srv := newWSServer(t)
+	userID, token := newTestUser(t)
+
+	// Connect and then never read, like a client whose network has stalled
+	dialWS(t, srv, token)
+	waitFor(t, time.Second, "the client is attached", connected(userID))
+	c := localClient(userID)
+
+	// Queue big events slowly enough that the send buffer never fills, so the
+	// connection can only end because a write blocked past its deadline
+	payload := mustMarshal(strings.Repeat("x", 1<<20))
+	data, _ := json.Marshal(WSMessage{Type: "activity", Payload: payload})
+	deadline := time.Now().Add(10 * time.Second)
+	for localClient(userID) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("a client that stopped reading was never dropped")
+		}
+		if len(c.send) < cap(c.send)/4 {
+			c.enqueue(data)
+		}
+		if len(c.send) == cap(c.send) {
+			t.Fatal("the send buffer filled up; the write deadline never fired")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitFor(t, time.Second, "the client is detached", disconnected(userID))
+}
+
+func TestWSDisconnectRemovesPresence(t *testing.T) {
+	srv := newWSServer(t)
+	leaverID, leaverToken := newTestUser(t)
+	_, watcherToken := newTestUser(t)
+
+	watcher := dialWS(t, srv, watcherToken)
+	leaver := dialWS(t, srv, leaverToken)
+	// Going offline within one flush of coming online announces nothing
+	waitForPresenceUpdate(t, watcher, leaverID, statusOnline)
+
+	leaver.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	leaver.Close()
+	waitFor(t, time.Second, "the leaver is detached", disconnected(leaverID))
+	if visiblePresence(leaverID).Status != statusOffline {
+		t.Error("the leaver still looks online")
+	}
+	waitForPresenceUpdate(t, watcher, leaverID, statusOffline)
+}
+
+// waitForPresenceUpdate reads from conn until a presence_update says userID
+// now has status.
+func waitForPresenceUpdate(t *testing.T, conn *websocket.Conn, userID, status string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("never told %s is %s: %v", userID, status, err)
+		}
+		var msg WSMessage
+		json.Unmarshal(raw, &msg)
+		if msg.Type != "presence_update" {
+			continue
+		}
+		var changes []PresenceChange
+		json.Unmarshal(msg.Payload, &changes)
+		for _, ch := range changes {
+			if ch.UserID == userID && ch.Status == status && ch.Online == (status != statusOffline) {
+				return
+			}
+		}
+	}
+}
+
+// logTestEvents logs n events for userID, as if they had happened while the
+// user was away, and returns the seq before the first.
+func logTestEvents(t *testing.T, userID string, n int) int64 {
+	t.Helper()
+	before := db.LastEventSeq(userID)
+	for i := range n {
+		if _, err := db.AppendEvent(userID, "test_event", mustMarshal(i)); err != nil {
+			t.Fatal("append event:", err)
+		}
+	}
+	return before
+}
+
+// checkReplay checks that events hold the n events logged after lastSeq, in
+// order, followed by a complete resumed marker and the initial state.
+func checkReplay(t *testing.T, events []WSMessage, lastSeq int64, n int) {
+	t.Helper()
+	var replayed []int64
+	var resumed *resumedEvent
+	var types []string
+	for _, msg := range events {
+		types = append(types, msg.Type)
+		switch msg.Type {
+		case "test_event":
+			replayed = append(replayed, msg.Seq)
+		case "resumed":
+			resumed = &resumedEvent{}
+			json.Unmarshal(msg.Payload, resumed)
+		}
+	}
+	if len(replayed) != n {
+		t.Fatalf("replayed %d events, want %d", len(replayed), n)
+	}
+	for i, seq := range replayed {
+		if seq != lastSeq+int64(i)+1 {
+			t.Fatalf("event %d has seq %d, want %d", i, seq, lastSeq+int64(i)+1)
+		}
+	}
+	if resumed == nil || resumed.Replayed != n || !resumed.Complete || resumed.LastSeq != lastSeq+int64(n) {
+		t.Errorf("resumed %+v, want %d replayed up to %d", resumed, n, lastSeq+int64(n))
+	}
+	if !slices.Contains(types, "user_list") || types[len(types)-1] != "own_status" {
+		t.Errorf("the initial state didn't follow the replay: got %v", types[n:])
+	}
+}
+
+// readWSUntil reads events from conn up to and including one of type last.
+func readWSUntil(t *testing.T, conn *websocket.Conn, last string) []WSMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	var events []WSMessage
+	for {
+		var msg WSMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("after %d events: %v", len(events), err)
+		}
+		events = append(events, msg)
+		if msg.Type == last {
+			return events
+		}
+	}
+}
+
+func TestWSResumeLongGap(t *testing.T) {
+	srv := newWSServer(t)
+	userID, token := newTestUser(t)
+
+	// Far more than fits in the send buffer at once
+	const missed = 600
+	lastSeq := logTestEvents(t, userID, missed)
+
+	conn := dialWSResume(t, srv, token, lastSeq)
+	checkReplay(t, readWSUntil(t, conn, "own_status"), lastSeq, missed)
+	if localClient(userID) == nil {
+		t.Error("dropped after the replay")
+	}
+}
@@ -0,0 +1,165 @@
+// Package imaging checks uploaded images and gets them ready to serve: it
+// rejects files that don't decode or would take too much memory to, strips
+// metadata such as EXIF and GPS tags, and makes smaller variants so feeds
+// don't have to load full-size photos.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxPixels caps the size of an image that will be decoded. A few kilobytes
+// of compressed data can claim tens of thousands of pixels a side, and
+// decoding that would take gigabytes; 25 megapixels is well above what phone
+// cameras produce.
+const MaxPixels = 25_000_000
+
+var (
+	ErrUnsupported = errors.New("only JPEG, PNG, GIF and WebP images are allowed")
+	ErrInvalid     = errors.New("the file is not a valid image")
+	ErrTooLarge    = errors.New("the image has too many pixels (max 25 megapixels)")
+)
+
+// Variant is a smaller copy of an uploaded image.
+type Variant struct {
+	Name          string // "thumb" or "medium"
+	Data          []byte
+	Ext           string
+	ContentType   string
+	Width, Height int
+}
+
+// Image is an uploaded image ready to store: Data is the original with its
+// metadata removed, and Variants holds the smaller sizes it is bigger than.
+type Image struct {
+	Data          []byte
+	Ext           string
+	ContentType   string
+	Width, Height int
+	Variants      []Variant
+}
+
+// sizes are the variants made of each image, by the longest side they fit in.
+var sizes = []struct {
+	name    string
+	maxSide int
+}{
+	{"thumb", 320},
+	{"medium", 1280},
+}
+
+var formats = map[string]struct{ ext, contentType string }{
+	"jpeg": {".jpg", "image/jpeg"},
+	"png":  {".png", "image/png"},
+	"gif":  {".gif", "image/gif"},
+	"webp": {".webp", "image/webp"},
+}
+
+// Process validates an uploaded image and prepares it for storage.
+func Process(data []byte) (*Image, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	f, ok := formats[format]
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrInvalid
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	// Decoding the whole image, not just its header, catches truncated and
+	// corrupt files. Only a GIF's first frame is decoded.
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	out := &Image{Ext: f.ext, ContentType: f.contentType}
+	var stripped []byte
+	switch format {
+	case "jpeg":
+		// Dropping EXIF drops the orientation with it, so turn the pixels
+		// the way it said first
+		if o := jpegOrientation(data); o > 1 && o <= 8 {
+			img = orient(img, o)
+		} else {
+			stripped, ok = stripJPEG(data)
+		}
+	case "png":
+		stripped, ok = stripPNG(data)
+	case "webp":
+		stripped, ok = stripWebP(data)
+	case "gif":
+		// Re-encoding would keep only the first frame
+		stripped, ok = stripGIF(data)
+	}
+	if stripped != nil && ok {
+		out.Data = stripped
+	} else {
+		// Re-encoding keeps only the pixels
+		out.Data, out.Ext, out.ContentType, err = encode(img)
+		if err != nil {
+			return nil, err
+		}
+	}
+	out.Width, out.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	for _, size := range sizes {
+		// A still medium copy of an animated GIF would stop it playing in
+		// the feed; the thumbnail is fine as a still
+		if format == "gif" && size.name != "thumb" {
+			continue
+		}
+		if max(out.Width, out.Height) <= size.maxSide {
+			continue
+		}
+		small := resize(img, size.maxSide)
+		v := Variant{Name: size.name, Width: small.Bounds().Dx(), Height: small.Bounds().Dy()}
+		if v.Data, v.Ext, v.ContentType, err = encode(small); err != nil {
+			return nil, err
+		}
+		out.Variants = append(out.Variants, v)
+	}
+	return out, nil
+}
+
+// encode writes img as a JPEG, or as a PNG if it has transparency to keep.
+func encode(img image.Image) (data []byte, ext, contentType string, err error) {
+	var buf bytes.Buffer
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), ".jpg", "image/jpeg", err
+	}
+	err = png.Encode(&buf, img)
+	return buf.Bytes(), ".png", "image/png", err
+}
+
+// resize scales img down so its longest side is maxSide.
+func resize(img image.Image, maxSide int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w >= h {
+		w, h = maxSide, max(1, h*maxSide/w)
+	} else {
+		w, h = max(1, w*maxSide/h), maxSide
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,288 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// gpsMarker stands in for a camera's GPS position; it mustn't survive Process.
+const gpsMarker = "GPS 51.5007N 0.1246W"
+
+// halves is a w×h image whose left half is red and right half blue.
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// exif builds an EXIF APP1 payload with the given orientation, 0 for none,
+// followed by the GPS marker.
+func exif(orientation int) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	if orientation == 0 {
+		binary.Write(&tiff, binary.BigEndian, uint16(0))
+	} else {
+		binary.Write(&tiff, binary.BigEndian, uint16(1))
+		binary.Write(&tiff, binary.BigEndian, []uint16{0x0112, 3})
+		binary.Write(&tiff, binary.BigEndian, uint32(1))
+		binary.Write(&tiff, binary.BigEndian, []uint16{uint16(orientation), 0})
+	}
+	binary.Write(&tiff, binary.BigEndian, uint32(0)) // no next IFD
+	tiff.WriteString(gpsMarker)
+	return append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+}
+
+// withJPEGSegments inserts segments right after a JPEG's start of image.
+func withJPEGSegments(data []byte, segments ...[]byte) []byte {
+	out := append([]byte{}, data[:2]...)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, data[2:]...)
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// withPNGChunks inserts chunks right after a PNG's IHDR.
+func withPNGChunks(data []byte, chunks ...[]byte) []byte {
+	const ihdrEnd = 8 + 12 + 13
+	out := append([]byte{}, data[:ihdrEnd]...)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	return append(out, data[ihdrEnd:]...)
+}
+
+func pngChunk(typ string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// animatedGIF encodes a looping two-frame GIF, and returns it as it is and
+// with a comment and an XMP application extension added, the way editors do.
+func animatedGIF(t *testing.T) (plain, tagged []byte) {
+	t.Helper()
+	frames := &gif.GIF{LoopCount: 0}
+	for _, c := range []color.Color{color.White, color.Black} {
+		frame := image.NewPaletted(image.Rect(0, 0, 400, 300), palette.Plan9)
+		for i := range frame.Pix {
+			frame.Pix[i] = uint8(frame.Palette.Index(c))
+		}
+		frames.Image = append(frames.Image, frame)
+		frames.Delay = append(frames.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, frames); err != nil {
+		t.Fatal(err)
+	}
+	plain = buf.Bytes()
+
+	var ext []byte
+	ext = append(ext, 0x21, 0xfe, byte(len(gpsMarker)))
+	ext = append(ext, gpsMarker...)
+	ext = append(ext, 0)
+	ext = append(ext, 0x21, 0xff, 11)
+	ext = append(ext, "XMP DataXMP"...)
+	xmp := "<x:xmpmeta>" + gpsMarker + "</x:xmpmeta>"
+	ext = append(ext, byte(len(xmp)))
+	ext = append(ext, xmp...)
+	ext = append(ext, 0)
+
+	// Before the trailer
+	tagged = append([]byte{}, plain[:len(plain)-1]...)
+	tagged = append(tagged, ext...)
+	return plain, append(tagged, 0x3b)
+}
+
+func TestProcessStripsMetadata(t *testing.T) {
+	img := halves(64, 48)
+	_, animated := animatedGIF(t)
+	tests := []struct {
+		name   string
+		data   []byte
+		frames int // for GIFs, how many frames must be kept
+	}{
+		{"jpeg exif", withJPEGSegments(encodeJPEG(t, img), jpegSegment(0xe1, exif(0))), 0},
+		{"jpeg exif orientation 1", withJPEGSegments(encodeJPEG(t, img), jpegSegment(0xe1, exif(1))), 0},
+		{"jpeg xmp and comment", withJPEGSegments(encodeJPEG(t, img),
+			jpegSegment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00"+gpsMarker)),
+			jpegSegment(0xfe, []byte(gpsMarker))), 0},
+		{"png text and exif", withPNGChunks(encodePNG(t, img),
+			pngChunk("tEXt", []byte("Comment\x00"+gpsMarker)),
+			pngChunk("eXIf", exif(0)[6:])), 0},
+		{"animated gif", animated, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Process(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(out.Data, []byte(gpsMarker)) || bytes.Contains(out.Data, []byte("Exif\x00\x00")) {
+				t.Error("metadata survived")
+			}
+			if _, _, err := image.Decode(bytes.NewReader(out.Data)); err != nil {
+				t.Error("result doesn't decode:", err)
+			}
+			for _, v := range out.Variants {
+				if bytes.Contains(v.Data, []byte(gpsMarker)) {
+					t.Errorf("metadata survived in %s", v.Name)
+				}
+			}
+			if tt.frames > 0 {
+				g, err := gif.DecodeAll(bytes.NewReader(out.Data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(g.Image) != tt.frames || g.LoopCount != 0 {
+					t.Errorf("%d frames looping %d, want %d frames looping forever", len(g.Image), g.LoopCount, tt.frames)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessOrientation(t *testing.T) {
+	// Turned upright, the red half ends up on top for 6 and at the bottom for 8
+	tests := []struct {
+		orientation int
+		topRed      bool
+	}{
+		{6, true},
+		{8, false},
+	}
+	for _, tt := range tests {
+		data := withJPEGSegments(encodeJPEG(t, halves(80, 40)), jpegSegment(0xe1, exif(tt.orientation)))
+		out, err := Process(data)
+		if err != nil {
+			t.Fatalf("orientation %d: %v", tt.orientation, err)
+		}
+		if out.Width != 40 || out.Height != 80 {
+			t.Errorf("orientation %d: %dx%d, want 40x80", tt.orientation, out.Width, out.Height)
+		}
+		if bytes.Contains(out.Data, []byte(gpsMarker)) {
+			t.Errorf("orientation %d: metadata survived", tt.orientation)
+		}
+		img, _, err := image.Decode(bytes.NewReader(out.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 80 {
+			t.Errorf("orientation %d: stored %dx%d, want 40x80", tt.orientation, b.Dx(), b.Dy())
+		}
+		if got := isRed(img.At(20, 10)); got != tt.topRed {
+			t.Errorf("orientation %d: top is red = %v, want %v", tt.orientation, got, tt.topRed)
+		}
+		if got := isRed(img.At(20, 70)); got == tt.topRed {
+			t.Errorf("orientation %d: bottom is red = %v, want %v", tt.orientation, got, !tt.topRed)
+		}
+	}
+}
+
+func isRed(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > b
+}
+
+func TestProcessRejects(t *testing.T) {
+	jpg := encodeJPEG(t, halves(64, 48))
+	pngData := encodePNG(t, halves(64, 48))
+	_, animated := animatedGIF(t)
+
+	// A PNG header claiming to be far bigger than its data
+	bomb := bytes.Clone(encodePNG(t, image.NewGray(image.Rect(0, 0, 1, 1))))
+	binary.BigEndian.PutUint32(bomb[16:], 20000)
+	binary.BigEndian.PutUint32(bomb[20:], 20000)
+	binary.BigEndian.PutUint32(bomb[29:], crc32.ChecksumIEEE(bomb[12:29]))
+
+	// And a GIF whose screen is as big as GIF allows
+	gifBomb := bytes.Clone(animated)
+	binary.LittleEndian.PutUint16(gifBomb[6:], 0xffff)
+	binary.LittleEndian.PutUint16(gifBomb[8:], 0xffff)
+
+	corrupt := bytes.Clone(pngData)
+	for i := 60; i < len(corrupt)-12; i++ {
+		corrupt[i] ^= 0xff
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrUnsupported},
+		{"text", []byte("definitely not an image"), ErrUnsupported},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), ErrUnsupported},
+		{"truncated jpeg", jpg[:len(jpg)/2], ErrInvalid},
+		{"truncated png", pngData[:len(pngData)/2], ErrInvalid},
+		{"truncated gif", animated[:len(animated)/3], ErrInvalid},
+		{"png header only", pngData[:33], ErrInvalid},
+		{"corrupt png", corrupt, ErrInvalid},
+		{"png bomb", bomb, ErrTooLarge},
+		{"gif bomb", gifBomb, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Process(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("Process: %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripGIFKeepsFrames(t *testing.T) {
+	plain, tagged := animatedGIF(t)
+	stripped, ok := stripGIF(tagged)
+	if !ok {
+		t.Fatal("couldn't strip a well-formed GIF")
+	}
+	if !bytes.Equal(stripped, plain) {
+		t.Errorf("stripped to %d bytes, want the %d of the GIF before tagging", len(stripped), len(plain))
+	}
+	if _, ok := stripGIF(tagged[:len(tagged)-1]); ok {
+		t.Error("stripped a GIF without its trailer")
+	}
+}
@@ -0,0 +1,293 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// The strip functions remove metadata from a file without touching the
+// compressed image, so there is no loss in quality. They return false if the
+// file isn't laid out the way they expect, and the caller re-encodes instead.
+
+// stripJPEG drops every APPn segment but JFIF (APP0), the ICC colour profile
+// (APP2) and Adobe's colour transform flag (APP14), along with comments. EXIF
+// and XMP, where cameras put GPS positions, live in APP1.
+func stripJPEG(data []byte) ([]byte, bool) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, false
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xff {
+			return nil, false
+		}
+		marker := data[i+1]
+		if marker == 0xff { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xda { // start of scan: the rest is image data
+			out.Write(data[i:])
+			return out.Bytes(), true
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + n
+		if n < 2 || end > len(data) {
+			return nil, false
+		}
+		payload := data[i+4 : end]
+		keep := true
+		switch {
+		case marker == 0xfe:
+			keep = false
+		case marker == 0xe2:
+			keep = bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+		case marker >= 0xe1 && marker <= 0xef:
+			keep = marker == 0xee
+		}
+		if keep {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+}
+
+// pngDropped are the chunks that carry metadata rather than pixels or colour
+// information.
+var pngDropped = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+func stripPNG(data []byte) ([]byte, bool) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, false
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString(signature)
+	for i := len(signature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, false
+		}
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + n
+		if n < 0 || end > len(data) {
+			return nil, false
+		}
+		typ := string(data[i+4 : i+8])
+		if !pngDropped[typ] {
+			out.Write(data[i:end])
+		}
+		i = end
+		if typ == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), true
+}
+
+// stripWebP drops the EXIF and XMP chunks and clears the flags in the
+// extended header that announce them.
+func stripWebP(data []byte) ([]byte, bool) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, false
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, false
+		}
+		fourCC := string(data[i : i+4])
+		n := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + n + n%2 // chunks are padded to an even size
+		if n < 0 || end > len(data) {
+			return nil, false
+		}
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := bytes.Clone(data[i:end])
+			if n > 0 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP present
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b, true
+}
+
+// stripGIF drops the comment, plain text and application extensions, where
+// editors put XMP, keeping the NETSCAPE one that makes an animation loop.
+// Frames and their graphic controls are copied as they are.
+func stripGIF(data []byte) ([]byte, bool) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return nil, false
+	}
+	i := 13 + colorTableSize(data[10])
+	if i > len(data) {
+		return nil, false
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:i])
+	for i < len(data) {
+		switch data[i] {
+		case 0x3b: // trailer
+			out.WriteByte(0x3b)
+			return out.Bytes(), true
+		case 0x21: // extension
+			if i+2 > len(data) {
+				return nil, false
+			}
+			end, ok := skipSubBlocks(data, i+2)
+			if !ok {
+				return nil, false
+			}
+			label, body := data[i+1], data[i+2:end]
+			if label == 0xf9 || (label == 0xff && (bytes.HasPrefix(body, []byte("\x0bNETSCAPE2.0")) ||
+				bytes.HasPrefix(body, []byte("\x0bANIMEXTS1.0")))) {
+				out.Write(data[i:end])
+			}
+			i = end
+		case 0x2c: // image descriptor, then the LZW code size and the frame
+			if i+10 > len(data) {
+				return nil, false
+			}
+			start := i + 10 + colorTableSize(data[i+9]) + 1
+			if start > len(data) {
+				return nil, false
+			}
+			end, ok := skipSubBlocks(data, start)
+			if !ok {
+				return nil, false
+			}
+			out.Write(data[i:end])
+			i = end
+		default:
+			return nil, false
+		}
+	}
+	return nil, false // no trailer
+}
+
+// colorTableSize is the size of the colour table a GIF's screen or image
+// descriptor with the given packed field says follows it.
+func colorTableSize(packed byte) int {
+	if packed&0x80 == 0 {
+		return 0
+	}
+	return 3 << (packed&0x07 + 1)
+}
+
+// skipSubBlocks returns where the GIF data sub-blocks starting at i end,
+// after their zero-length terminator.
+func skipSubBlocks(data []byte, i int) (int, bool) {
+	for i < len(data) {
+		n := int(data[i])
+		i++
+		if n == 0 {
+			return i, true
+		}
+		i += n
+	}
+	return 0, false
+}
+
+// jpegOrientation reads the EXIF orientation of a JPEG, 1 to 8, or 0 when it
+// has none.
+func jpegOrientation(data []byte) int {
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		if marker == 0xda {
+			return 0
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + n
+		if n < 2 || end > len(data) {
+			return 0
+		}
+		if payload := data[i+4 : end]; marker == 0xe1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return exifOrientation(payload[6:])
+		}
+		i = end
+	}
+	return 0
+}
+
+// exifOrientation finds the Orientation tag in the first IFD of a TIFF
+// structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		at := ifd + 2 + e*12
+		if at+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[at:]) == 0x0112 {
+			return int(order.Uint16(tiff[at+8:]))
+		}
+	}
+	return 0
+}
+
+// orient turns img upright according to EXIF orientation o.
+func orient(img image.Image, o int) image.Image {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2: // flip horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotate 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // flip vertically
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90° counter-clockwise
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			s, d := src.PixOffset(x, y), dst.PixOffset(dx, dy)
+			copy(dst.Pix[d:d+4], src.Pix[s:s+4])
+		}
+	}
+	return dst
+}
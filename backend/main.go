@@ -1,18 +1,63 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"real-time-forum/db"
 	"real-time-forum/handlers"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate-uploads" {
+		migrateUploads(os.Args[2:])
+		return
+	}
+
 	// Use absolute path to DB in Render
 	db.Init("./forum.db")
 
+	// Keep uploads in a bucket when the host's disk doesn't last between
+	// deploys
+	uploads, err := uploadStorage(context.Background())
+	if err != nil {
+		log.Fatal("upload storage: ", err)
+	}
+	handlers.UseStorage(uploads, envDuration("UPLOAD_SIGNED_URL_TTL"))
+	handlers.ConfigureUploads(handlers.UploadLimits{
+		QuotaBytes:   envInt("UPLOAD_QUOTA_BYTES"),
+		DailyUploads: envInt("UPLOAD_DAILY_LIMIT"),
+	})
+
+	handlers.ConfigureWS(handlers.WSConfig{
+		PingInterval:   envDuration("WS_PING_INTERVAL"),
+		PongWait:       envDuration("WS_PONG_WAIT"),
+		WriteWait:      envDuration("WS_WRITE_WAIT"),
+		MaxMessageSize: envInt("WS_MAX_MESSAGE_BYTES"),
+	})
+
+	// Share WebSocket users between instances when Redis is configured
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		hub, err := handlers.NewRedisHub(url)
+		if err != nil {
+			log.Fatal("redis hub: ", err)
+		}
+		handlers.UseHubBackend(hub)
+	}
+
+	// Mark idle users away and keep last seen times fresh
+	handlers.StartPresenceSweep()
+
+	// Publish scheduled posts as they fall due
+	handlers.StartScheduler(envDuration("SCHEDULER_INTERVAL"))
+
+	// Delete uploads that were never attached to anything
+	handlers.StartAttachmentCleanup(envDuration("ATTACHMENT_GRACE_PERIOD"))
+
 	mux := http.NewServeMux()
 
 	// API routes
@@ -21,18 +66,54 @@ func main() {
 	mux.HandleFunc("/api/logout", handlers.Logout)
 	mux.HandleFunc("/api/posts", handlers.Posts)
 	mux.HandleFunc("/api/posts/delete", handlers.DeletePost)
+	mux.HandleFunc("/api/posts/scheduled", handlers.ScheduledPosts)
+	mux.HandleFunc("/api/posts/scheduled/cancel", handlers.CancelScheduledPost)
+	mux.HandleFunc("/api/polls", handlers.Poll)
+	mux.HandleFunc("/api/polls/vote", handlers.PollVote)
+	mux.HandleFunc("/api/drafts", handlers.Drafts)
+	mux.HandleFunc("/api/drafts/delete", handlers.DeleteDraft)
 	mux.HandleFunc("/api/comments", handlers.Comments)
 	mux.HandleFunc("/api/comments/delete", handlers.DeleteComment)
 	mux.HandleFunc("/api/votes", handlers.Vote)
+	mux.HandleFunc("/api/bookmarks", handlers.Bookmarks)
+	mux.HandleFunc("/api/bookmarks/delete", handlers.DeleteBookmark)
+	mux.HandleFunc("/api/bookmarks/folders", handlers.BookmarkFolders)
+	mux.HandleFunc("/api/posts/watch", handlers.WatchPost)
+	mux.HandleFunc("/api/posts/unwatch", handlers.UnwatchPost)
+	mux.HandleFunc("/api/categories/subscribe", handlers.SubscribeCategory)
+	mux.HandleFunc("/api/categories/unsubscribe", handlers.UnsubscribeCategory)
+	mux.HandleFunc("/api/subscriptions", handlers.Subscriptions)
+	mux.HandleFunc("/api/subscriptions/unsubscribe-all", handlers.UnsubscribeAll)
 	mux.HandleFunc("/api/messages", handlers.Messages)
+	mux.HandleFunc("/api/messages/search", handlers.SearchMessages)
+	mux.HandleFunc("/api/messages/context", handlers.MessageContext)
+	mux.HandleFunc("/api/messages/edit", handlers.EditMessage)
+	mux.HandleFunc("/api/messages/unsend", handlers.UnsendMessage)
+	mux.HandleFunc("/api/messages/delete", handlers.DeleteMessage)
 	mux.HandleFunc("/api/users", handlers.Users)
+	mux.HandleFunc("/api/users/mentions", handlers.MentionSuggestions)
+	mux.HandleFunc("/api/users/profile", handlers.Profile)
+	mux.HandleFunc("/api/users/following", handlers.Following)
+	mux.HandleFunc("/api/users/follow", handlers.Follow)
+	mux.HandleFunc("/api/users/unfollow", handlers.Unfollow)
+	mux.HandleFunc("/api/notifications", handlers.Notifications)
+	mux.HandleFunc("/api/notifications/read", handlers.MarkNotificationsRead)
+	mux.HandleFunc("/api/notifications/read-all", handlers.MarkAllNotificationsRead)
+	mux.HandleFunc("/api/notifications/preferences", handlers.NotificationPreferences)
 	mux.HandleFunc("/api/upload", handlers.Upload)
+	mux.HandleFunc("/api/upload/usage", handlers.UploadUsage)
 
 	// WebSocket
 	mux.HandleFunc("/ws", handlers.ServeWS)
+	mux.HandleFunc("/api/ws/schema", handlers.WSSchema)
+
+	// Fallbacks for clients that can't keep a WebSocket open
+	mux.HandleFunc("/api/events", handlers.Events)
+	mux.HandleFunc("/api/events/poll", handlers.PollEvents)
+	mux.HandleFunc("/api/events/send", handlers.SendEvent)
 
 	// Serve uploaded files
-	mux.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads"))))
+	mux.HandleFunc("/uploads/", handlers.ServeUpload)
 
 	// Optional: serve frontend if you include build in repo
 	// mux.Handle("/", http.FileServer(http.Dir("./frontend/dist/")))
@@ -47,6 +128,21 @@ func main() {
 	log.Fatal(http.ListenAndServe(":"+port, cors(mux)))
 }
 
+// envDuration reads a duration like "30s" from the environment, or 0 if unset.
+func envDuration(key string) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// envInt reads an integer from the environment, or 0 if unset.
+func envInt(key string) int64 {
+	n, _ := strconv.ParseInt(os.Getenv(key), 10, 64)
+	return n
+}
+
 // CORS middleware
 func cors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
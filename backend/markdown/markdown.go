@@ -0,0 +1,61 @@
+// Package markdown turns the Markdown users write in posts, comments and
+// messages into HTML that is safe to put straight into the page.
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// The renderer supports CommonMark: paragraphs, emphasis, headings, lists,
+// block quotes, code spans and fenced or indented code blocks, links and
+// rules. Raw HTML in the source is dropped rather than passed through, and a
+// single newline is a line break, as people expect in a chat or a comment.
+var renderer = goldmark.New(
+	goldmark.WithRendererOptions(gmhtml.WithHardWraps()),
+)
+
+// policy is the sanitizer run over everything the renderer produces, so a
+// bug or a gap in the renderer can't let markup through that CommonMark
+// wouldn't have produced. Images are left out on purpose: posts and messages
+// have their own uploaded images, and remote ones would let anyone track who
+// reads a post.
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements(
+		"p", "br", "hr", "em", "strong", "code", "pre", "blockquote",
+		"ul", "ol", "li", "h1", "h2", "h3", "h4", "h5", "h6",
+	)
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#.-]+$`)).OnElements("code")
+
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.AllowRelativeURLs(false)
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+// Render converts Markdown source to sanitized HTML. Empty source renders to
+// the empty string.
+func Render(source string) string {
+	if source == "" {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := renderer.Convert([]byte(source), &buf); err != nil {
+		// Converting never fails writing to a buffer; fall back to the
+		// escaped text all the same rather than losing the content.
+		return "<p>" + html.EscapeString(source) + "</p>"
+	}
+	return policy.Sanitize(buf.String())
+}
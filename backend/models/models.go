@@ -1,5 +1,7 @@
 package models
 
+import "encoding/json"
+
 type User struct {
 	ID        string `json:"id"`
 	Nickname  string `json:"nickname"`
@@ -12,34 +14,178 @@ type User struct {
 }
 
 type Post struct {
-	ID        string `json:"id"`
-	UserID    string `json:"user_id"`
-	Nickname  string `json:"nickname"`
-	Title     string `json:"title"`
-	Content   string `json:"content"`
-	Category  string `json:"category"`
-	ImageURL  string `json:"image_url"`
-	CreatedAt string `json:"created_at"`
-	Upvotes   int    `json:"upvotes"`
-	Downvotes int    `json:"downvotes"`
-	UserVote  int    `json:"user_vote"`
+	ID           string       `json:"id"`
+	UserID       string       `json:"user_id"`
+	Nickname     string       `json:"nickname"`
+	Title        string       `json:"title"`
+	Content      string       `json:"content"`
+	ContentHTML  string       `json:"content_html"`
+	Category     string       `json:"category"`
+	Attachments  []Attachment `json:"attachments"`
+	CreatedAt    string       `json:"created_at"`
+	Upvotes      int          `json:"upvotes"`
+	Downvotes    int          `json:"downvotes"`
+	UserVote     int          `json:"user_vote"`
+	IsBookmarked bool         `json:"is_bookmarked"`
+	IsWatching   bool         `json:"is_watching"`
+	Mentions     []Mention    `json:"mentions"`
+	Poll         *Poll        `json:"poll"`
 }
 
 type Comment struct {
-	ID        string `json:"id"`
-	PostID    string `json:"post_id"`
-	UserID    string `json:"user_id"`
-	Nickname  string `json:"nickname"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"created_at"`
+	ID          string       `json:"id"`
+	PostID      string       `json:"post_id"`
+	UserID      string       `json:"user_id"`
+	Nickname    string       `json:"nickname"`
+	Content     string       `json:"content"`
+	ContentHTML string       `json:"content_html"`
+	Attachments []Attachment `json:"attachments"`
+	CreatedAt   string       `json:"created_at"`
+	Mentions    []Mention    `json:"mentions"`
 }
 
 type Message struct {
+	ID          string       `json:"id"`
+	SenderID    string       `json:"sender_id"`
+	ReceiverID  string       `json:"receiver_id"`
+	SenderName  string       `json:"sender_name"`
+	Content     string       `json:"content"`
+	ContentHTML string       `json:"content_html"`
+	Attachments []Attachment `json:"attachments"`
+	CreatedAt   string       `json:"created_at"`
+	DeliveredAt string       `json:"delivered_at"`
+	ReadAt      string       `json:"read_at"`
+	Edited      bool         `json:"edited"`
+	EditedAt    string       `json:"edited_at"`
+	Unsent      bool         `json:"unsent"`
+	Mentions    []Mention    `json:"mentions"`
+}
+
+// Attachment is an uploaded image. It belongs to the user who uploaded it,
+// who can attach it, in order and with alt text, to one of their posts,
+// comments, messages or drafts. Uploads nothing uses are deleted after a
+// grace period.
+//
+// ThumbnailURL and MediumURL are smaller copies for feeds and previews; for
+// an image already that small they are the same as URL. Width and Height are
+// 0 for images uploaded before they were recorded.
+type Attachment struct {
+	ID             string `json:"id"`
+	URL            string `json:"url"`
+	ThumbnailURL   string `json:"thumbnail_url"`
+	MediumURL      string `json:"medium_url"`
+	Alt            string `json:"alt"`
+	ContentType    string `json:"content_type"`
+	Size           int64  `json:"size"`
+	Width          int    `json:"width"`
+	Height         int    `json:"height"`
+	Filename       string `json:"-"`
+	ThumbFilename  string `json:"-"`
+	MediumFilename string `json:"-"`
+	Hash           string `json:"-"` // SHA-256 of the uploaded bytes; empty for older uploads
+}
+
+// UploadUsage is how much of their upload allowance a user has used. A limit
+// of 0 means there is none.
+type UploadUsage struct {
+	UsedBytes    int64  `json:"used_bytes"`
+	QuotaBytes   int64  `json:"quota_bytes"`
+	UploadsToday int64  `json:"uploads_today"`
+	DailyLimit   int64  `json:"daily_limit"`
+	ResetsAt     string `json:"resets_at"`
+}
+
+// Poll is a vote attached to a post. MyVotes holds the options the viewer
+// picked; it is left out of the live poll_update events, which go to
+// everyone. Voters are only listed on public polls.
+type Poll struct {
+	PostID      string       `json:"post_id"`
+	Multiple    bool         `json:"multiple"`
+	Anonymous   bool         `json:"anonymous"`
+	ClosesAt    string       `json:"closes_at"`
+	Closed      bool         `json:"closed"`
+	TotalVoters int          `json:"total_voters"`
+	Options     []PollOption `json:"options"`
+	MyVotes     []string     `json:"my_votes,omitempty"`
+}
+
+type PollOption struct {
+	ID     string      `json:"id"`
+	Text   string      `json:"text"`
+	Votes  int         `json:"votes"`
+	Voters []PollVoter `json:"voters,omitempty"`
+}
+
+type PollVoter struct {
+	ID       string `json:"id"`
+	Nickname string `json:"nickname"`
+}
+
+// PollSpec is a poll as its author writes it, before it is created.
+type PollSpec struct {
+	Options   []string `json:"options"`
+	Multiple  bool     `json:"multiple"`
+	Anonymous bool     `json:"anonymous"`
+	ClosesAt  string   `json:"closes_at"`
+}
+
+// Draft is an unpublished post. Drafts with a PublishAt are scheduled and
+// get published by the server at that time.
+type Draft struct {
+	ID          string       `json:"id"`
+	UserID      string       `json:"user_id"`
+	Title       string       `json:"title"`
+	Content     string       `json:"content"`
+	Category    string       `json:"category"`
+	Attachments []Attachment `json:"attachments"`
+	Poll        *PollSpec    `json:"poll"`
+	PublishAt   string       `json:"publish_at"`
+	CreatedAt   string       `json:"created_at"`
+	UpdatedAt   string       `json:"updated_at"`
+}
+
+// Bookmark is a post or comment a user saved, privately, optionally into a
+// named folder. Post or Comment holds what was saved.
+type Bookmark struct {
+	TargetType string   `json:"target_type"` // "post" or "comment"
+	TargetID   string   `json:"target_id"`
+	Folder     string   `json:"folder"`
+	CreatedAt  string   `json:"created_at"`
+	Post       *Post    `json:"post,omitempty"`
+	Comment    *Comment `json:"comment,omitempty"`
+}
+
+// Mention is an @nickname in a post, comment or message that matched a user.
+// Start and End are offsets into the content in Unicode code points, End
+// exclusive, covering the "@" and the nickname.
+type Mention struct {
+	UserID   string `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+// Notification tells a user about activity on their posts or threads. Events of
+// the same type on the same post are grouped into one unread notification;
+// ActorName is whoever acted last and ActorCount how many people acted.
+type Notification struct {
 	ID         string `json:"id"`
-	SenderID   string `json:"sender_id"`
-	ReceiverID string `json:"receiver_id"`
-	SenderName string `json:"sender_name"`
-	Content    string `json:"content"`
-	ImageURL   string `json:"image_url"`
+	Type       string `json:"type"`
+	PostID     string `json:"post_id"`
+	PostTitle  string `json:"post_title"`
+	CommentID  string `json:"comment_id"`
+	ActorID    string `json:"actor_id"`
+	ActorName  string `json:"actor_name"`
+	ActorCount int    `json:"actor_count"`
+	Text       string `json:"text"`
+	Read       bool   `json:"read"`
 	CreatedAt  string `json:"created_at"`
-}
\ No newline at end of file
+	UpdatedAt  string `json:"updated_at"`
+}
+
+// Event is an entry in a user's real-time event log, replayed on reconnect.
+type Event struct {
+	Seq     int64           `json:"seq"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores files in a directory on disk.
+type Local struct {
+	dir string
+}
+
+// NewLocal stores files in dir, which is created when the first file is
+// stored.
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+// tempPrefix marks files still being written; Walk skips them.
+const tempPrefix = ".tmp-"
+
+func (l *Local) Put(ctx context.Context, name string, data []byte, contentType string) error {
+	if !validName(name) {
+		return ErrNotFound
+	}
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a file is never served half written
+	tmp, err := os.CreateTemp(l.dir, tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(l.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (l *Local) Open(ctx context.Context, name string) (*File, error) {
+	if !validName(name) || strings.HasPrefix(name, tempPrefix) {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(filepath.Join(l.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+	return &File{
+		ReadSeekCloser: f,
+		Size:           info.Size(),
+		ModTime:        info.ModTime(),
+		ContentType:    mime.TypeByExtension(filepath.Ext(name)),
+	}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, name string) error {
+	if !validName(name) {
+		return nil
+	}
+	err := os.Remove(filepath.Join(l.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) Walk(ctx context.Context, fn func(name string) error) error {
+	entries, err := os.ReadDir(l.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), tempPrefix) {
+			continue
+		}
+		if err := fn(e.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	testStorage(t, NewLocal(t.TempDir()))
+}
+
+func TestLocalMissingDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "uploads")
+	l := NewLocal(dir)
+	if names := walkAll(t, l); len(names) != 0 {
+		t.Errorf("walked %v in a directory that doesn't exist", names)
+	}
+	// The directory is made by the first Put
+	if err := l.Put(context.Background(), "a.png", []byte("x"), "image/png"); err != nil {
+		t.Fatal("put:", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.png")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLocalSkipsOthers(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	l := NewLocal(dir)
+	l.Put(ctx, "a.png", []byte("x"), "image/png")
+
+	// A file still being written, and a directory, aren't stored files
+	os.WriteFile(filepath.Join(dir, tempPrefix+"123"), []byte("half"), 0644)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+
+	if names := walkAll(t, l); !slices.Equal(names, []string{"a.png"}) {
+		t.Errorf("walked %v, want [a.png]", names)
+	}
+	for _, name := range []string{tempPrefix + "123", "sub"} {
+		if _, err := l.Open(ctx, name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("open %s: %v, want ErrNotFound", name, err)
+		}
+	}
+}
+
+func TestLocalStaysInDir(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "uploads")
+	os.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0644)
+
+	l := NewLocal(dir)
+	if _, err := l.Open(ctx, "../secret.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("open ../secret.txt: %v, want ErrNotFound", err)
+	}
+	l.Delete(ctx, "../secret.txt")
+	if _, err := os.Stat(filepath.Join(parent, "secret.txt")); err != nil {
+		t.Error("delete reached outside the directory:", err)
+	}
+	if _, ok := Storage(l).(Signer); ok {
+		t.Error("local storage can't sign links")
+	}
+}
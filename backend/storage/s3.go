@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config says where an S3-compatible bucket is and how to reach it.
+type S3Config struct {
+	Endpoint  string // host[:port], e.g. s3.amazonaws.com or localhost:9000
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // prepended to every file name, e.g. "uploads/"
+	Insecure  bool   // use plain HTTP, for a local stand-in
+}
+
+// S3 stores files in a bucket of Amazon S3 or any service that speaks its
+// API, such as MinIO.
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 connects to the bucket in cfg, which must already exist.
+func NewS3(ctx context.Context, cfg S3Config) (*S3, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ok, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("bucket %q does not exist", cfg.Bucket)
+	}
+	return &S3{client: client, bucket: cfg.Bucket, prefix: cfg.Prefix}, nil
+}
+
+func (s *S3) Put(ctx context.Context, name string, data []byte, contentType string) error {
+	if !validName(name) {
+		return ErrNotFound
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, s.prefix+name, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *S3) Open(ctx context.Context, name string) (*File, error) {
+	if !validName(name) {
+		return nil, ErrNotFound
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, s.prefix+name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	// GetObject doesn't make a request until the object is read or
+	// described, so this is where a missing file shows up
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, s3Error(err)
+	}
+	return &File{
+		ReadSeekCloser: obj,
+		Size:           info.Size,
+		ModTime:        info.LastModified,
+		ContentType:    info.ContentType,
+	}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	if !validName(name) {
+		return nil
+	}
+	// S3 doesn't complain about deleting what isn't there
+	return s.client.RemoveObject(ctx, s.bucket, s.prefix+name, minio.RemoveObjectOptions{})
+}
+
+func (s *S3) Walk(ctx context.Context, fn func(name string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		name := obj.Key[len(s.prefix):]
+		if !validName(name) {
+			continue
+		}
+		if err := fn(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SignedURL returns a link that downloads name straight from the bucket
+// until ttl has passed.
+func (s *S3) SignedURL(ctx context.Context, name string, ttl time.Duration) (string, error) {
+	if !validName(name) {
+		return "", ErrNotFound
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, s.prefix+name, ttl, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func s3Error(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestS3(t *testing.T) {
+	fake := newFakeS3("uploads")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	s := newTestS3(t, srv.Listener.Addr().String(), "uploads", "prefix/")
+	testStorage(t, s)
+
+	t.Run("Prefix", func(t *testing.T) {
+		// Objects outside the prefix, or that aren't plain names, aren't ours
+		fake.put("other/x.png", []byte("x"))
+		fake.put("prefix/dir/x.png", []byte("x"))
+		if names := walkAll(t, s); !slices.Equal(names, []string{"a.png", "b.png", "c.png"}) {
+			t.Errorf("walked %v", names)
+		}
+		if _, ok := fake.get("prefix/a.png"); !ok {
+			t.Error("a.png isn't stored under the prefix")
+		}
+	})
+
+	t.Run("SignedURL", func(t *testing.T) {
+		testSignedURL(t, s)
+	})
+}
+
+func TestS3MissingBucket(t *testing.T) {
+	srv := httptest.NewServer(newFakeS3("uploads"))
+	defer srv.Close()
+
+	_, err := NewS3(context.Background(), S3Config{
+		Endpoint: srv.Listener.Addr().String(), Bucket: "other", Region: "us-east-1",
+		AccessKey: "key", SecretKey: "secret", Insecure: true,
+	})
+	if err == nil {
+		t.Error("connected to a bucket that doesn't exist")
+	}
+}
+
+// TestMinIO runs the same checks against a real MinIO server, whose bucket
+// MINIO_BUCKET must exist:
+//
+//	MINIO_ENDPOINT=localhost:9000 MINIO_ACCESS_KEY=... MINIO_SECRET_KEY=... MINIO_BUCKET=test go test ./storage
+func TestMinIO(t *testing.T) {
+	endpoint := os.Getenv("MINIO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MINIO_ENDPOINT not set")
+	}
+	ctx := context.Background()
+	s, err := NewS3(ctx, S3Config{
+		Endpoint:  endpoint,
+		Bucket:    os.Getenv("MINIO_BUCKET"),
+		Region:    os.Getenv("MINIO_REGION"),
+		AccessKey: os.Getenv("MINIO_ACCESS_KEY"),
+		SecretKey: os.Getenv("MINIO_SECRET_KEY"),
+		Prefix:    "storage-test-" + uuid.NewString() + "/",
+		Insecure:  os.Getenv("MINIO_INSECURE") != "false",
+	})
+	if err != nil {
+		t.Fatal("connect:", err)
+	}
+	t.Cleanup(func() {
+		s.Walk(ctx, func(name string) error { return s.Delete(ctx, name) })
+	})
+
+	testStorage(t, s)
+	t.Run("SignedURL", func(t *testing.T) {
+		testSignedURL(t, s)
+	})
+}
+
+func testSignedURL(t *testing.T, s *S3) {
+	ctx := context.Background()
+	url, err := s.SignedURL(ctx, "a.png", time.Minute)
+	if err != nil {
+		t.Fatal("sign:", err)
+	}
+	if !strings.Contains(url, "X-Amz-Signature=") {
+		t.Errorf("%s isn't signed", url)
+	}
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(data) != "second" {
+		t.Errorf("signed link gave %d %q", res.StatusCode, data)
+	}
+
+	if _, err := s.SignedURL(ctx, "../a.png", time.Minute); !errors.Is(err, ErrNotFound) {
+		t.Errorf("signed an invalid name: %v", err)
+	}
+}
+
+func newTestS3(t *testing.T, endpoint, bucket, prefix string) *S3 {
+	t.Helper()
+	s, err := NewS3(context.Background(), S3Config{
+		Endpoint:  endpoint,
+		Bucket:    bucket,
+		Region:    "us-east-1",
+		AccessKey: "key",
+		SecretKey: "secret",
+		Prefix:    prefix,
+		Insecure:  true,
+	})
+	if err != nil {
+		t.Fatal("connect:", err)
+	}
+	return s
+}
+
+// fakeS3 is just enough of the S3 API, with path-style addressing and no
+// authentication, for the S3 storage to run against.
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{bucket: bucket, objects: make(map[string]fakeObject)}
+}
+
+func (f *fakeS3) put(key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = fakeObject{data: data, contentType: "application/octet-stream", modTime: time.Now()}
+}
+
+func (f *fakeS3) get(key string) (fakeObject, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[key]
+	return obj, ok
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		s3ErrorResponse(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "" && r.URL.Query().Has("location"):
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`)
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodPut:
+		data, err := readS3Body(r)
+		if err != nil {
+			s3ErrorResponse(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.mu.Lock()
+		f.objects[key] = fakeObject{data: data, contentType: r.Header.Get("Content-Type"), modTime: time.Now()}
+		f.mu.Unlock()
+		w.Header().Set("ETag", etag(data))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		obj, ok := f.get(key)
+		if !ok {
+			s3ErrorResponse(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(obj.data))
+		w.Header().Set("Content-Type", obj.contentType)
+		http.ServeContent(w, r, key, obj.modTime, bytes.NewReader(obj.data))
+	case r.Method == http.MethodDelete:
+		f.mu.Lock()
+		delete(f.objects, key)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3ErrorResponse(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// list answers ListObjectsV2 with every key under prefix in one page.
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	f.mu.Lock()
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`+
+		`<Name>%s</Name><Prefix>%s</Prefix><KeyCount>%d</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>`,
+		f.bucket, prefix, len(keys))
+	for _, key := range keys {
+		obj := f.objects[key]
+		fmt.Fprintf(&b, `<Contents><Key>%s</Key><LastModified>%s</LastModified><ETag>%s</ETag><Size>%d</Size><StorageClass>STANDARD</StorageClass></Contents>`,
+			key, obj.modTime.UTC().Format("2006-01-02T15:04:05.000Z"), etag(obj.data), len(obj.data))
+	}
+	b.WriteString(`</ListBucketResult>`)
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, b.String())
+}
+
+// readS3Body reads an upload, decoding it when the client streamed it in
+// signed chunks, as minio-go does over plain HTTP.
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var data []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil // trailing headers, if any, aren't needed
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+		if _, err := br.Discard(2); err != nil { // CRLF
+			return nil, err
+		}
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func s3ErrorResponse(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message><Resource>%s</Resource><RequestId>1</RequestId></Error>`,
+			code, code, r.URL.Path)
+	}
+}
@@ -0,0 +1,115 @@
+// Package storage keeps uploaded files, either in a directory on local disk
+// or in an S3-compatible bucket, so the server can run on hosts whose disk
+// doesn't outlive a deploy.
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a file doesn't exist or its name isn't valid.
+var ErrNotFound = errors.New("file not found")
+
+// Storage stores files by name. Names are flat: they can't contain slashes.
+type Storage interface {
+	// Put stores data as name, replacing any file already there.
+	Put(ctx context.Context, name string, data []byte, contentType string) error
+	// Open opens name for reading.
+	Open(ctx context.Context, name string) (*File, error)
+	// Delete removes name. Removing a file that doesn't exist isn't an error.
+	Delete(ctx context.Context, name string) error
+	// Walk calls fn with the name of every stored file, stopping at the
+	// first error fn returns.
+	Walk(ctx context.Context, fn func(name string) error) error
+}
+
+// Signer is implemented by storage that can hand out short-lived links, so
+// clients download files straight from it rather than through the server.
+type Signer interface {
+	SignedURL(ctx context.Context, name string, ttl time.Duration) (string, error)
+}
+
+// File is a stored file opened for reading.
+type File struct {
+	io.ReadSeekCloser
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// validName reports whether name is a plain file name, so it can't reach
+// outside the directory or bucket prefix it is stored under.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// Copy copies every file in from to to, skipping ones to already has with
+// the same contents. It calls done with the name of each file to has once it
+// is done with it, and whether it had to be copied.
+func Copy(ctx context.Context, from, to Storage, done func(name string, copied bool)) error {
+	return from.Walk(ctx, func(name string) error {
+		same, err := Same(ctx, from, to, name)
+		if err != nil {
+			return err
+		}
+		if same {
+			done(name, false)
+			return nil
+		}
+
+		src, err := from.Open(ctx, name)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return err
+		}
+		if err := to.Put(ctx, name, data, src.ContentType); err != nil {
+			return err
+		}
+		done(name, true)
+		return nil
+	})
+}
+
+// Same reports whether a and b both have name with the same contents.
+func Same(ctx context.Context, a, b Storage, name string) (bool, error) {
+	sumA, err := checksum(ctx, a, name)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	sumB, err := checksum(ctx, b, name)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return sumA == sumB, nil
+}
+
+// checksum hashes the contents of name in s.
+func checksum(ctx context.Context, s Storage, name string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := s.Open(ctx, name)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+// testStorage runs the checks every Storage has to pass against s, which
+// must start out empty.
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	t.Run("PutOpen", func(t *testing.T) {
+		if err := s.Put(ctx, "a.png", []byte("first"), "image/png"); err != nil {
+			t.Fatal("put:", err)
+		}
+		if err := s.Put(ctx, "a.png", []byte("second"), "image/png"); err != nil {
+			t.Fatal("put again:", err)
+		}
+		f, err := s.Open(ctx, "a.png")
+		if err != nil {
+			t.Fatal("open:", err)
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal("read:", err)
+		}
+		if string(data) != "second" || f.Size != int64(len(data)) {
+			t.Errorf("got %q of size %d, want %q", data, f.Size, "second")
+		}
+		if f.ContentType != "image/png" {
+			t.Errorf("content type %q, want image/png", f.ContentType)
+		}
+		if f.ModTime.IsZero() {
+			t.Error("no modification time")
+		}
+
+		// ServeContent seeks to the end to find the size
+		if n, err := f.Seek(0, io.SeekEnd); err != nil || n != f.Size {
+			t.Errorf("seek to end = %d, %v; want %d", n, err, f.Size)
+		}
+	})
+
+	t.Run("OpenMissing", func(t *testing.T) {
+		if _, err := s.Open(ctx, "missing.png"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("open missing file: %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("InvalidNames", func(t *testing.T) {
+		for _, name := range []string{"", ".", "..", "../a.png", "a/b.png", `a\b.png`, "/etc/passwd"} {
+			if err := s.Put(ctx, name, []byte("x"), "image/png"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("put %q: %v, want ErrNotFound", name, err)
+			}
+			if _, err := s.Open(ctx, name); !errors.Is(err, ErrNotFound) {
+				t.Errorf("open %q: %v, want ErrNotFound", name, err)
+			}
+			if err := s.Delete(ctx, name); err != nil {
+				t.Errorf("delete %q: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := s.Put(ctx, "gone.png", []byte("x"), "image/png"); err != nil {
+			t.Fatal("put:", err)
+		}
+		if err := s.Delete(ctx, "gone.png"); err != nil {
+			t.Fatal("delete:", err)
+		}
+		if _, err := s.Open(ctx, "gone.png"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("open deleted file: %v, want ErrNotFound", err)
+		}
+		if err := s.Delete(ctx, "gone.png"); err != nil {
+			t.Errorf("delete missing file: %v", err)
+		}
+	})
+
+	t.Run("Walk", func(t *testing.T) {
+		for _, name := range []string{"b.png", "c.png"} {
+			if err := s.Put(ctx, name, []byte(name), "image/png"); err != nil {
+				t.Fatal("put:", err)
+			}
+		}
+		names := walkAll(t, s)
+		if want := []string{"a.png", "b.png", "c.png"}; !slices.Equal(names, want) {
+			t.Errorf("walked %v, want %v", names, want)
+		}
+
+		stop := errors.New("stop")
+		calls := 0
+		err := s.Walk(ctx, func(string) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Errorf("walk returned %v after %d calls, want the callback's error after 1", err, calls)
+		}
+	})
+}
+
+// walkAll returns the names s walks over, sorted.
+func walkAll(t *testing.T, s Storage) []string {
+	t.Helper()
+	var names []string
+	if err := s.Walk(context.Background(), func(name string) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatal("walk:", err)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// readFile returns the contents of name in s.
+func readFile(t *testing.T, s Storage, name string) []byte {
+	t.Helper()
+	f, err := s.Open(context.Background(), name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return data
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	from, to := NewLocal(t.TempDir()), NewLocal(t.TempDir())
+	files := map[string]string{
+		"new.png":     "only in from",
+		"same.png":    "already copied",
+		"changed.png": "newer contents",
+	}
+	for name, data := range files {
+		if err := from.Put(ctx, name, []byte(data), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	to.Put(ctx, "same.png", []byte("already copied"), "image/png")
+	// Same size, different contents: has to be copied again
+	to.Put(ctx, "changed.png", []byte("older contents"), "image/png")
+
+	copied := map[string]bool{}
+	if err := Copy(ctx, from, to, func(name string, wasCopied bool) {
+		if _, seen := copied[name]; seen {
+			t.Errorf("%s reported twice", name)
+		}
+		copied[name] = wasCopied
+	}); err != nil {
+		t.Fatal("copy:", err)
+	}
+
+	want := map[string]bool{"new.png": true, "same.png": false, "changed.png": true}
+	for name, wasCopied := range want {
+		got, done := copied[name]
+		if !done || got != wasCopied {
+			t.Errorf("%s: reported %v (copied %v), want copied %v", name, done, got, wasCopied)
+		}
+		if data := readFile(t, to, name); string(data) != files[name] {
+			t.Errorf("%s: destination has %q, want %q", name, data, files[name])
+		}
+	}
+}
+
+func TestSame(t *testing.T) {
+	ctx := context.Background()
+	a, b := NewLocal(t.TempDir()), NewLocal(t.TempDir())
+	a.Put(ctx, "x.png", []byte("contents"), "image/png")
+
+	tests := []struct {
+		name string
+		put  []byte // what b has, nil for nothing
+		want bool
+	}{
+		{"missing", nil, false},
+		{"different size", []byte("other"), false},
+		{"same size", []byte("CONTENTS"), false},
+		{"identical", []byte("contents"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Delete(ctx, "x.png")
+			if tt.put != nil {
+				b.Put(ctx, "x.png", tt.put, "image/png")
+			}
+			same, err := Same(ctx, a, b, "x.png")
+			if err != nil || same != tt.want {
+				t.Errorf("Same = %v, %v; want %v", same, err, tt.want)
+			}
+		})
+	}
+
+	if same, err := Same(ctx, a, b, "missing.png"); err != nil || same {
+		t.Errorf("Same on a file neither has = %v, %v; want false", same, err)
+	}
+}
+
+func TestValidName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"a.png": true, "a_thumb.webp": true, ".tmp-1": true,
+		"": false, ".": false, "..": false, "../a.png": false, "a/b": false, `a\b`: false,
+	} {
+		if got := validName(name); got != want {
+			t.Errorf("validName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
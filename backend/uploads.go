@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"real-time-forum/storage"
+)
+
+// uploadStorage builds the storage uploads are kept in from the environment:
+// UPLOAD_STORAGE=local (the default) keeps them in UPLOAD_DIR, and
+// UPLOAD_STORAGE=s3 in the bucket described by the S3_* variables.
+func uploadStorage(ctx context.Context) (storage.Storage, error) {
+	switch kind := os.Getenv("UPLOAD_STORAGE"); kind {
+	case "", "local":
+		return storage.NewLocal(uploadDir()), nil
+	case "s3":
+		return storage.NewS3(ctx, storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    os.Getenv("S3_REGION"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Prefix:    os.Getenv("S3_PREFIX"),
+			Insecure:  os.Getenv("S3_INSECURE") == "true",
+		})
+	default:
+		return nil, fmt.Errorf("unknown UPLOAD_STORAGE %q", kind)
+	}
+}
+
+// uploadDir is where local storage keeps uploads.
+func uploadDir() string {
+	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return "./uploads"
+}
+
+// migrateUploads copies the files in a local uploads directory to the
+// configured storage, which is how an existing deploy moves to a bucket:
+//
+//	UPLOAD_STORAGE=s3 S3_BUCKET=... ./server migrate-uploads -from ./uploads
+//
+// Files the storage already has are skipped, so it can be run again after
+// an interruption. With -delete, local files are removed once the storage is
+// checked to have them.
+func migrateUploads(args []string) {
+	fs := flag.NewFlagSet("migrate-uploads", flag.ExitOnError)
+	from := fs.String("from", "./uploads", "local directory to copy uploads from")
+	remove := fs.Bool("delete", false, "delete local files once they are copied")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	to, err := uploadStorage(ctx)
+	if err != nil {
+		log.Fatal("upload storage: ", err)
+	}
+	if _, ok := to.(*storage.Local); ok && filepath.Clean(uploadDir()) == filepath.Clean(*from) {
+		log.Fatal("uploads are already in ", *from, "; set UPLOAD_STORAGE or UPLOAD_DIR to where they should go")
+	}
+
+	src := storage.NewLocal(*from)
+	var done []string
+	copied := 0
+	err = storage.Copy(ctx, src, to, func(name string, wasCopied bool) {
+		done = append(done, name)
+		if wasCopied {
+			copied++
+			log.Println("copied", name)
+		}
+	})
+	if err != nil {
+		log.Fatalf("migrate uploads: %v (%d files copied)", err, copied)
+	}
+	log.Printf("copied %d files", copied)
+
+	if *remove {
+		// Only what Copy got to, and only if it is still there intact
+		deleted := 0
+		for _, name := range done {
+			same, err := storage.Same(ctx, src, to, name)
+			if err != nil {
+				log.Fatal("check copied upload: ", err)
+			}
+			if !same {
+				log.Println("not deleting", name, "which the storage doesn't have")
+				continue
+			}
+			if err := src.Delete(ctx, name); err != nil {
+				log.Fatal("delete local upload: ", err)
+			}
+			deleted++
+		}
+		log.Printf("deleted %d local files", deleted)
+	}
+}
@@ -0,0 +1,136 @@
+const MAX_ATTACHMENTS = 10;
+
+// createAttachmentPicker turns a file input, the button that opens it and a
+// preview list into a picker for up to MAX_ATTACHMENTS images. Each image is
+// uploaded as soon as it is picked and gets an alt text field; onChange runs
+// when images are added or removed.
+function createAttachmentPicker({ input, button, list, onChange = () => {} }) {
+  let items = []; // { id, thumb, alt }
+
+  function render() {
+    list.innerHTML = '';
+    list.hidden = items.length === 0;
+    items.forEach((item, i) => {
+      const row = document.createElement('div');
+      row.className = 'attachment-preview';
+      row.innerHTML = `
+        <img src="${escapeHTML(item.thumb)}" class="attachment-preview__img" alt=""/>
+        <input type="text" class="attachment-preview__alt" maxlength="300"
+               placeholder="Describe the image" aria-label="Alt text"/>
+        <button type="button" class="attachment-preview__remove" title="Remove image" aria-label="Remove image">
+          <svg width="12" height="12" viewBox="0 0 24 24" fill="none" stroke="currentColor"
+               stroke-width="2.5" stroke-linecap="round" stroke-linejoin="round">
+            <line x1="18" y1="6" x2="6" y2="18"/><line x1="6" y1="6" x2="18" y2="18"/>
+          </svg>
+        </button>`;
+      const alt = row.querySelector('.attachment-preview__alt');
+      alt.value = item.alt;
+      alt.addEventListener('input', () => { item.alt = alt.value; });
+      row.querySelector('.attachment-preview__remove').addEventListener('click', () => {
+        items.splice(i, 1);
+        render();
+        onChange();
+      });
+      list.appendChild(row);
+    });
+    button.disabled = items.length >= MAX_ATTACHMENTS;
+  }
+
+  button.addEventListener('click', () => {
+    input.value = '';
+    input.click();
+  });
+
+  input.addEventListener('change', async () => {
+    const files = [...input.files].slice(0, MAX_ATTACHMENTS - items.length);
+    if (files.length === 0) return;
+
+    button.disabled = true;
+    button.classList.add('uploading');
+    try {
+      for (const file of files) {
+        const a = await uploadImage(file);
+        if (!a) break;
+        items.push({ id: a.id, thumb: a.thumbnail_url || a.url, alt: '' });
+        render();
+      }
+      onChange();
+    } finally {
+      button.classList.remove('uploading');
+      render();
+    }
+  });
+
+  render();
+  return {
+    hasItems: () => items.length > 0,
+    // refs is what the server takes: [{ id, alt }]
+    refs    : () => items.map(({ id, alt }) => ({ id, alt: alt.trim() })),
+    set(attachments) {
+      items = (attachments || []).map(a => ({ id: a.id, thumb: a.thumbnail_url || a.url, alt: a.alt }));
+      render();
+    },
+    clear() {
+      items = [];
+      input.value = '';
+      render();
+    },
+  };
+}
+
+// uploadImage uploads one image and returns the attachment the server made
+// for it, or null.
+async function uploadImage(file) {
+  const form = new FormData();
+  form.append('image', file);
+  try {
+    const res = await authFetch(`${API_BASE}/api/upload`, { method: 'POST', body: form });
+    const data = await res.json();
+    if (!res.ok) {
+      alert(data.error || 'Upload failed');
+      return null;
+    }
+    return data;
+  } catch {
+    alert('Upload failed. Please try again.');
+    return null;
+  }
+}
+
+// buildAttachmentGallery shows a post's, comment's or message's images: the
+// medium size when there is only one, thumbnails in a grid otherwise.
+// Clicking one opens it full size.
+function buildAttachmentGallery(attachments) {
+  const single  = attachments.length === 1;
+  const gallery = document.createElement('div');
+  gallery.className = 'attachment-gallery';
+  gallery.classList.toggle('attachment-gallery--single', single);
+  attachments.forEach(a => {
+    const img = document.createElement('img');
+    img.className = 'attachment-gallery__img';
+    img.src       = (single ? a.medium_url : a.thumbnail_url) || a.url;
+    img.alt       = a.alt;
+    // Known dimensions let the browser keep the space while it loads
+    if (a.width && a.height) {
+      img.width  = a.width;
+      img.height = a.height;
+    }
+    img.title     = a.alt;
+    img.loading   = 'lazy';
+    img.addEventListener('click', (e) => {
+      e.stopPropagation();
+      openLightbox(a.url);
+    });
+    gallery.appendChild(img);
+  });
+  return gallery;
+}
+
+// fillAttachmentSlot replaces the .attachments-slot placeholder in el with the
+// item's images, or removes it when there are none.
+function fillAttachmentSlot(el, item) {
+  const slot = el.querySelector('.attachments-slot');
+  if (!slot) return;
+  if (item.attachments && item.attachments.length > 0) slot.replaceWith(buildAttachmentGallery(item.attachments));
+  else slot.remove();
+}
@@ -16,21 +16,35 @@ const navHome             = document.getElementById('nav-home');
 const sidebar             = document.getElementById('online-users-sidebar');
 const chatImageBtn        = document.getElementById('chat-image-btn');
 const chatImageInput      = document.getElementById('chat-image-input');
-const chatImagePreview    = document.getElementById('chat-image-preview');
-const chatImagePreviewImg = document.getElementById('chat-image-preview-img');
-const chatImageRemoveBtn  = document.getElementById('chat-image-remove-btn');
+const chatPartnerTyping   = document.getElementById('chat-partner-typing');
+const chatPartnerSeen     = document.getElementById('chat-partner-seen');
+const statusSelect        = document.getElementById('navbar-status');
+const statusTextInput     = document.getElementById('navbar-status-text');
+const msgSearchInput      = document.getElementById('message-search-input');
+const msgSearchResults    = document.getElementById('message-search-results');
 
 let ws             = null;
+let wsFailures     = 0;      // WebSocket attempts in a row that never opened
+let topics         = new Set(); // what the current view wants live events for
 let activePartner  = null;
-let msgOffset      = 0;
+let oldestMsgID    = null;   // keyset cursors for /api/messages?before= / ?after=
+let newestMsgID    = null;
+let viewingLatest  = true;   // false after jumping into the middle of history
 let loadingMore    = false;
 let noMoreMsgs     = false;
 let unread         = {};
+let ownStatus      = null;   // status this user picked, from own_status
 let userMap        = {};
 
 let chatInitialized = false;
-let pendingImageURL = null;
 let topSentinelObserver = null;
+let typingActive    = false;
+let typingStopTimer = null;
+
+// Reliable delivery: every send carries a client_id and stays in the outbox
+// until the server acks it; events carry a seq we resume from on reconnect.
+let lastSeq = null;
+const outbox = new Map();
 
 function throttle(fn, wait) {
   let last = 0;
@@ -58,7 +72,7 @@ function setupTopObserver() {
       activePartner &&
       chatMessagesArea.scrollHeight > chatMessagesArea.clientHeight
     ) {
-      loadMessages(activePartner.id, msgOffset, false);
+      loadMessages(activePartner.id, false);
     }
   }, { root: chatMessagesArea, threshold: 0 });
 
@@ -86,23 +100,80 @@ function showAppSection(section) {
 function connectWS() {
   if (ws && ws.readyState < 2) return;
 
-  const token = sessionStorage.getItem('token') || '';
-  ws = new WebSocket(`wss://forum-da3w.onrender.com/ws?token=${encodeURIComponent(token)}`);
+  const token  = sessionStorage.getItem('token') || '';
+  const resume = lastSeq !== null ? `&last_seq=${lastSeq}` : '';
+  // Some proxies break WebSockets; after a few failed attempts use SSE instead
+  ws = wsFailures < WS_FALLBACK_AFTER
+    ? new WebSocket(`wss://forum-da3w.onrender.com/ws?token=${encodeURIComponent(token)}${resume}`)
+    : new EventStreamSocket(`${API_BASE}/api/events?token=${encodeURIComponent(token)}${resume}`);
+  const socket = ws;
+  let opened   = false;
 
   ws.onopen = () => {
+    opened     = true;
+    wsFailures = 0;
     console.log('[WS] connected');
   };
 
   ws.onmessage = (e) => {
     let envelope;
     try { envelope = JSON.parse(e.data); } catch { return; }
+    if (envelope.seq) lastSeq = Math.max(lastSeq || 0, envelope.seq);
 
     switch (envelope.type) {
+      case 'resumed':
+        handleResumed(envelope.payload);
+        break;
+      case 'ack':
+        outbox.delete(envelope.payload.client_id);
+        break;
+      case 'hello':
+        ws.send(JSON.stringify({
+          type   : 'hello',
+          id     : 'hello',
+          payload: { protocol: PROTOCOL_VERSION, capabilities: CLIENT_CAPABILITIES },
+        }));
+        // Subscriptions belong to the connection, so a new one starts empty
+        sendTopics('subscribe', [...topics]);
+        break;
+      case 'error':
+        // Only requests the user made (those with a client_id) are worth a dialog
+        if (!envelope.payload.client_id) {
+          console.warn('[WS] request failed:', envelope.payload.code, envelope.payload.error);
+          break;
+        }
+        outbox.delete(envelope.payload.client_id);
+        alert(envelope.payload.error || 'Something went wrong.');
+        break;
       case 'user_list':
         renderUserList(envelope.payload);
         break;
+      case 'presence_update':
+        handlePresenceUpdate(envelope.payload);
+        break;
+      case 'own_status':
+        ownStatus             = envelope.payload.status;
+        statusSelect.value    = envelope.payload.status;
+        statusTextInput.value = envelope.payload.status_text;
+        break;
       case 'new_message':
-        handleIncomingMessage(envelope.payload);
+        handleIncomingMessage(envelope.payload, envelope.silent);
+        break;
+      case 'typing_start':
+      case 'typing_stop':
+        handlePeerTyping(envelope.payload, envelope.type === 'typing_start');
+        break;
+      case 'message_delivered':
+        handleReceipt(envelope.payload.message_ids, 'delivered');
+        break;
+      case 'message_read':
+        handleReceipt(envelope.payload.message_ids, 'read');
+        break;
+      case 'message_updated':
+        handleMessageUpdated(envelope.payload);
+        break;
+      case 'message_deleted':
+        handleMessageDeleted(envelope.payload);
         break;
       case 'new_post':
         handleNewPost(envelope.payload);
@@ -110,10 +181,30 @@ function connectWS() {
       case 'vote_update':
         handleVoteUpdate(envelope.payload);
         break;
+      case 'poll_update':
+        handlePollUpdate(envelope.payload);
+        break;
+      case 'new_comment':
+        handleNewComment(envelope.payload);
+        break;
+      case 'comment_deleted':
+        handleCommentDeleted(envelope.payload);
+        break;
+      case 'post_deleted':
+        handlePostDeleted(envelope.payload);
+        break;
+      case 'notification':
+        handleNotification(envelope.payload, envelope.silent);
+        break;
+      case 'draft_unscheduled':
+        handleDraftUnscheduled(envelope.payload);
+        break;
       case 'force_logout': {
         const dying = ws;
         ws = null;
         chatInitialized = false;
+        lastSeq = null;
+        outbox.clear();
         sessionStorage.removeItem('user');
         if (dying) dying.close();
         showPage('login');
@@ -123,6 +214,7 @@ function connectWS() {
   };
 
   ws.onclose = () => {
+    if (!opened && socket instanceof WebSocket) wsFailures++;
     console.log('[WS] disconnected — reconnecting in 3s');
     setTimeout(() => {
       if (ws !== null && sessionStorage.getItem('user')) connectWS();
@@ -134,9 +226,61 @@ function connectWS() {
   };
 }
 
+function handleResumed(data) {
+  lastSeq = data.last_seq;
+  // Gap in the event log: refetch what we're looking at instead of trusting it
+  if (!data.complete) {
+    if (typeof loadPosts === 'function') loadPosts();
+    if (activePartner) openChat(activePartner);
+  }
+  // Retry anything that was never acknowledged; client_id makes this idempotent
+  outbox.forEach(payload => {
+    ws.send(JSON.stringify({ type: 'send_message', payload }));
+  });
+}
+
+function newClientID() {
+  if (window.crypto && crypto.randomUUID) return crypto.randomUUID();
+  return Date.now().toString(36) + Math.random().toString(36).slice(2);
+}
+
+// user_list is a full snapshot, sent once per connection.
 function renderUserList(users) {
   if (!Array.isArray(users)) return;
 
+  userMap = {};
+  users.forEach(u => { userMap[u.id] = u; });
+
+  // Sync in-memory unread from server-authoritative counts
+  unread = {};
+  users.forEach(u => { unread[u.id] = u.unread_count || 0; });
+  updateNavBadge();
+
+  drawUserList();
+}
+
+// presence_update carries only the users whose presence changed.
+function handlePresenceUpdate(changes) {
+  if (!Array.isArray(changes)) return;
+  const me = JSON.parse(sessionStorage.getItem('user') || '{}');
+  changes.forEach(c => {
+    if (String(c.user_id) === String(me.id)) return;
+    if (!userMap[c.user_id]) {
+      // Someone who registered after our snapshot
+      userMap[c.user_id] = { id: c.user_id, nickname: c.nickname, last_msg: '', unread_count: 0 };
+    }
+    Object.assign(userMap[c.user_id], {
+      online      : c.online,
+      status      : c.status,
+      status_text : c.status_text,
+      last_seen_at: c.last_seen_at || userMap[c.user_id].last_seen_at,
+    });
+  });
+  drawUserList();
+}
+
+function drawUserList() {
+  const users = Object.values(userMap);
   users.sort((a, b) => {
     const ta = a.last_msg || '';
     const tb = b.last_msg || '';
@@ -144,12 +288,6 @@ function renderUserList(users) {
     return a.nickname.localeCompare(b.nickname);
   });
 
-  users.forEach(u => { userMap[u.id] = u; });
-
-  // Sync in-memory unread from server-authoritative counts
-  users.forEach(u => { unread[u.id] = u.unread_count || 0; });
-  updateNavBadge();
-
   // Update online users count pill
   const onlineCountEl = document.getElementById('nav-online-count');
   if (onlineCountEl) {
@@ -170,11 +308,17 @@ function renderUserList(users) {
     li.dataset.userId = u.id;
 
     const dot = document.createElement('span');
-    dot.className = 'status-dot ' + (u.online ? 'status-dot--online' : 'status-dot--offline');
+    dot.className = statusDotClass(u);
 
     const name = document.createElement('span');
     name.className = 'user-item__name';
     name.textContent = u.nickname;
+    if (u.online && u.status_text) {
+      const text = document.createElement('small');
+      text.className = 'user-item__status-text';
+      text.textContent = u.status_text;
+      name.appendChild(text);
+    }
 
     li.appendChild(dot);
     li.appendChild(name);
@@ -194,7 +338,8 @@ function renderUserList(users) {
   if (activePartner && userMap[activePartner.id]) {
     const updated = userMap[activePartner.id];
     activePartner = updated; // keep online flag in sync
-    chatPartnerStatus.className = 'status-dot ' + (updated.online ? 'status-dot--online' : 'status-dot--offline');
+    chatPartnerStatus.className = statusDotClass(updated);
+    chatPartnerSeen.textContent = presenceLabel(updated);
     const offline = !updated.online;
     chatInput.disabled        = offline;
     chatInput.placeholder     = offline ? `${updated.nickname} is offline — you can't send messages` : 'Type a message…';
@@ -203,9 +348,72 @@ function renderUserList(users) {
   }
 }
 
+function statusDotClass(u) {
+  const status = u.status || (u.online ? 'online' : 'offline');
+  return 'status-dot status-dot--' + status;
+}
+
+// presenceLabel is the line shown next to the partner's name in the chat header.
+function presenceLabel(u) {
+  if (u.online) {
+    const label = { away: 'Away', dnd: 'Do not disturb' }[u.status] || '';
+    return [label, u.status_text].filter(Boolean).join(' — ');
+  }
+  return u.last_seen_at ? 'last seen ' + timeAgo(u.last_seen_at) : '';
+}
+
+function timeAgo(iso) {
+  const secs = Math.max(0, (Date.now() - new Date(iso).getTime()) / 1000);
+  if (secs < 60)    return 'just now';
+  if (secs < 3600)  return Math.floor(secs / 60) + ' min ago';
+  if (secs < 86400) return Math.floor(secs / 3600) + ' h ago';
+  return formatDate(iso);
+}
+
+function sendStatus() {
+  if (!ws || ws.readyState !== 1) return;
+  ws.send(JSON.stringify({
+    type: 'set_status',
+    payload: { status: statusSelect.value, status_text: statusTextInput.value.trim() },
+  }));
+  // Notifications aren't pushed during do not disturb, so catch up on leaving it
+  if (ownStatus === 'dnd' && statusSelect.value !== 'dnd' && typeof loadNotifications === 'function') {
+    loadNotifications();
+  }
+  ownStatus = statusSelect.value;
+}
+
+statusSelect.addEventListener('change', sendStatus);
+statusTextInput.addEventListener('change', sendStatus);
+
+// Let the server know the user is still around so they don't go idle.
+const reportActivity = throttle(() => {
+  if (ws && ws.readyState === 1) ws.send(JSON.stringify({ type: 'activity' }));
+}, 60000);
+['keydown', 'mousedown', 'scroll', 'touchstart'].forEach(evt =>
+  document.addEventListener(evt, reportActivity, { passive: true, capture: true }));
+
+// Brief pop-up for a message that arrived outside the open conversation.
+// Skipped for silent events, which the server marks while we're on DND.
+function showMessageToast(msg) {
+  const sender = userMap[msg.sender_id];
+  const toast  = document.createElement('div');
+  toast.className   = 'message-toast';
+  toast.textContent = `${sender ? sender.nickname : 'New message'}: ${msg.content || 'sent an image'}`;
+  toast.addEventListener('click', () => {
+    toast.remove();
+    if (sender) openChat(sender);
+  });
+  document.body.appendChild(toast);
+  setTimeout(() => toast.remove(), 4000);
+}
+
 async function openChat(user) {
+  sendTypingStop();
   activePartner  = user;
-  msgOffset      = 0;
+  oldestMsgID    = null;
+  newestMsgID    = null;
+  viewingLatest  = true;
   loadingMore    = false;
   noMoreMsgs     = false;
 
@@ -218,7 +426,9 @@ async function openChat(user) {
     ws.send(JSON.stringify({ type: 'mark_read', payload: { sender_id: user.id } }));
   }
   chatPartnerName.textContent    = user.nickname;
-  chatPartnerStatus.className    = 'status-dot ' + (user.online ? 'status-dot--online' : 'status-dot--offline');
+  chatPartnerStatus.className    = statusDotClass(user);
+  chatPartnerSeen.textContent    = presenceLabel(user);
+  chatPartnerTyping.hidden       = true;
   chatPlaceholder.style.display  = 'none';
   chatConversation.style.display = 'flex';
   // Remove only message bubbles — preserve the sentinel, spinner and nomore elements
@@ -226,6 +436,8 @@ async function openChat(user) {
   chatNoMore.hidden      = true;
   chatLoadSpinner.hidden = true;
 
+  chatAttachments.clear();
+
   // Disable input if user is offline
   const offline = !user.online;
   chatInput.disabled          = offline;
@@ -233,8 +445,6 @@ async function openChat(user) {
   document.getElementById('chat-send-btn').disabled = offline;
   chatImageBtn.disabled       = offline;
 
-  clearChatImagePreview();
-
   showAppSection('chat');
 
   document.querySelectorAll('.user-item').forEach(el => {
@@ -245,10 +455,12 @@ async function openChat(user) {
     }
   });
 
-  await loadMessages(user.id, 0, true);
+  await loadMessages(user.id, true);
 }
 
-async function loadMessages(partnerID, offset, initial = false) {
+const MSG_PAGE_SIZE = 20;
+
+async function loadMessages(partnerID, initial = false) {
   if (loadingMore) return;
   loadingMore = true;
 
@@ -256,12 +468,15 @@ async function loadMessages(partnerID, offset, initial = false) {
   if (!initial) chatLoadSpinner.hidden = false;
 
   try {
-    const res  = await authFetch(`${API_BASE}/api/messages?with=${encodeURIComponent(partnerID)}&offset=${offset}`);
-    const data = await res.json();
+    let url = `${API_BASE}/api/messages?with=${encodeURIComponent(partnerID)}&limit=${MSG_PAGE_SIZE}`;
+    if (!initial && oldestMsgID) url += `&before=${encodeURIComponent(oldestMsgID)}`;
+    const res  = await authFetch(url);
+    const body = await res.json();
 
-    if (!res.ok || !Array.isArray(data)) return;
+    if (!res.ok || !Array.isArray(body.messages)) return;
+    const data = body.messages;
 
-    if (data.length < 10) {
+    if (!body.has_more) {
       noMoreMsgs        = true;
       chatNoMore.hidden = false;
     }
@@ -285,7 +500,10 @@ async function loadMessages(partnerID, offset, initial = false) {
       chatMessagesArea.scrollTop = chatMessagesArea.scrollHeight - prevHeight;
     }
 
-    msgOffset = offset + data.length;
+    if (data.length > 0) {
+      oldestMsgID = data[0].id;
+      if (initial) newestMsgID = data[data.length - 1].id;
+    }
 
   } catch (err) {
     console.error('[Chat] loadMessages error:', err);
@@ -295,11 +513,50 @@ async function loadMessages(partnerID, offset, initial = false) {
   }
 }
 
+// Loads the page after newestMsgID when the user scrolls down from a search jump.
+async function loadNewerMessages() {
+  if (loadingMore || viewingLatest || !activePartner || !newestMsgID) return;
+  loadingMore = true;
+  try {
+    const res  = await authFetch(`${API_BASE}/api/messages?with=${encodeURIComponent(activePartner.id)}&limit=${MSG_PAGE_SIZE}&after=${encodeURIComponent(newestMsgID)}`);
+    const body = await res.json();
+    if (!res.ok || !Array.isArray(body.messages)) return;
+
+    const me = JSON.parse(sessionStorage.getItem('user'));
+    body.messages.forEach(m => {
+      if (!chatMessagesArea.querySelector(`.chat-msg[data-msg-id="${m.id}"]`)) {
+        chatMessagesArea.appendChild(buildMessage(m, me.id));
+      }
+    });
+    if (body.messages.length > 0) newestMsgID = body.messages[body.messages.length - 1].id;
+    viewingLatest = !body.has_more;
+  } catch (err) {
+    console.error('[Chat] loadNewerMessages error:', err);
+  } finally {
+    loadingMore = false;
+  }
+}
+
+chatMessagesArea.addEventListener('scroll', throttle(() => {
+  const nearBottom = chatMessagesArea.scrollHeight - chatMessagesArea.scrollTop - chatMessagesArea.clientHeight < 80;
+  if (nearBottom) loadNewerMessages();
+}, 250));
+
 function buildMessage(m, myID) {
   const mine = String(m.sender_id) === String(myID);
   const div  = document.createElement('div');
   div.className    = 'chat-msg ' + (mine ? 'chat-msg--mine' : 'chat-msg--theirs');
   div.dataset.msgId = m.id;
+  fillMessage(div, m, mine);
+  return div;
+}
+
+// (Re)renders the inside of a message bubble — also used after edits/unsends.
+function fillMessage(div, m, mine) {
+  const receipt = div.dataset.receipt;
+  div.innerHTML = '';
+  div.dataset.createdAt = m.created_at;
+  div.classList.toggle('chat-msg--unsent', !!m.unsent);
 
   if (!mine) {
     const author = document.createElement('span');
@@ -308,36 +565,222 @@ function buildMessage(m, myID) {
     div.appendChild(author);
   }
 
-  
-  if (m.image_url) {
-    const imgWrap = document.createElement('div');
-    imgWrap.className = 'chat-msg__img-wrap';
-    const img = document.createElement('img');
-    img.src = m.image_url;
-    img.className = 'chat-msg__img';
-    img.alt = 'image';
-    img.loading = 'lazy';
-    img.addEventListener('click', () => openLightbox(m.image_url));
-    imgWrap.appendChild(img);
-    div.appendChild(imgWrap);
+  if (m.unsent) {
+    const text = document.createElement('p');
+    text.className   = 'chat-msg__text chat-msg__text--unsent';
+    text.textContent = 'Message unsent';
+    div.appendChild(text);
+  }
+
+  if (m.attachments && m.attachments.length > 0) {
+    div.appendChild(buildAttachmentGallery(m.attachments));
   }
 
-  
   if (m.content) {
-    const text = document.createElement('p');
-    text.className   = 'chat-msg__text';
-    text.textContent = m.content;
+    const text = document.createElement('div');
+    text.className = 'chat-msg__text rich-text';
+    text.innerHTML = renderContent(m);
     div.appendChild(text);
   }
 
   const time = document.createElement('time');
   time.className   = 'chat-msg__date';
-  time.textContent = formatDate(m.created_at);
+  time.textContent = formatDate(m.created_at) + (m.edited && !m.unsent ? ' · edited' : '');
   div.appendChild(time);
 
-  return div;
+  if (mine && !m.unsent) {
+    const ticks = document.createElement('span');
+    ticks.className = 'chat-msg__ticks';
+    time.appendChild(ticks);
+    delete div.dataset.receipt;
+    setReceipt(div, receipt === 'read' || m.read_at ? 'read' : m.delivered_at ? 'delivered' : 'sent');
+  }
+
+  if (!m.unsent) div.appendChild(buildMessageActions(m, mine));
+}
+
+function buildMessageActions(m, mine) {
+  const bar = document.createElement('div');
+  bar.className = 'chat-msg__actions';
+
+  const add = (label, type, confirmText) => {
+    const btn = document.createElement('button');
+    btn.type        = 'button';
+    btn.textContent = label;
+    btn.addEventListener('click', () => {
+      if (!ws || ws.readyState !== 1) return;
+      const payload = { message_id: m.id };
+      if (type === 'edit_message') {
+        const content = prompt('Edit message', m.content);
+        if (content === null || content.trim() === m.content) return;
+        payload.content = content.trim();
+      } else if (confirmText && !confirm(confirmText)) {
+        return;
+      }
+      ws.send(JSON.stringify({ type, payload }));
+    });
+    bar.appendChild(btn);
+  };
+
+  // Edits are only accepted by the server within 15 minutes of sending
+  if (mine && m.content && Date.now() - new Date(m.created_at).getTime() < 15 * 60 * 1000) {
+    add('Edit', 'edit_message');
+  }
+  if (mine) add('Unsend', 'unsend_message', 'Unsend this message for everyone?');
+  add('Delete for me', 'delete_message', 'Delete this message for you?');
+  return bar;
+}
+
+function handleMessageUpdated(m) {
+  const div = chatMessagesArea.querySelector(`.chat-msg[data-msg-id="${m.id}"]`);
+  if (!div) return;
+  const me = JSON.parse(sessionStorage.getItem('user') || '{}');
+  fillMessage(div, m, String(m.sender_id) === String(me.id));
+}
+
+function handleMessageDeleted(data) {
+  const div = chatMessagesArea.querySelector(`.chat-msg[data-msg-id="${data.message_id}"]`);
+  if (!div) return;
+  if (data.scope === 'me') {
+    div.remove();
+    return;
+  }
+  const mine = div.classList.contains('chat-msg--mine');
+  const author = div.querySelector('.chat-msg__author');
+  fillMessage(div, {
+    id         : data.message_id,
+    unsent     : true,
+    created_at : div.dataset.createdAt,
+    sender_name: author ? author.textContent : '',
+  }, mine);
+}
+
+// ✓ sent, ✓✓ delivered, blue ✓✓ seen
+function setReceipt(div, state) {
+  const ticks = div.querySelector('.chat-msg__ticks');
+  if (!ticks) return;
+  // Never downgrade: a late "delivered" must not undo "read"
+  if (div.dataset.receipt === 'read' && state !== 'read') return;
+  div.dataset.receipt = state;
+  ticks.textContent   = state === 'sent' ? ' ✓' : ' ✓✓';
+  ticks.classList.toggle('chat-msg__ticks--read', state === 'read');
+  ticks.title = state === 'read' ? 'Seen' : state === 'delivered' ? 'Delivered' : 'Sent';
+}
+
+function handleReceipt(ids, state) {
+  if (!Array.isArray(ids)) return;
+  ids.forEach(id => {
+    const div = chatMessagesArea.querySelector(`.chat-msg[data-msg-id="${id}"]`);
+    if (div) setReceipt(div, state);
+  });
+}
+
+function handlePeerTyping(data, typing) {
+  if (!activePartner || String(data.user_id) !== String(activePartner.id)) return;
+  chatPartnerTyping.hidden = !typing;
 }
 
+// Repeated while the user types; the server expires the indicator on its own
+// if typing_stop never arrives.
+const sendTypingStart = throttle(() => {
+  if (!activePartner || !ws || ws.readyState !== 1) return;
+  typingActive = true;
+  ws.send(JSON.stringify({ type: 'typing_start', payload: { receiver_id: activePartner.id } }));
+}, 2000);
+
+function sendTypingStop() {
+  clearTimeout(typingStopTimer);
+  if (!typingActive) return;
+  typingActive = false;
+  if (!activePartner || !ws || ws.readyState !== 1) return;
+  ws.send(JSON.stringify({ type: 'typing_stop', payload: { receiver_id: activePartner.id } }));
+}
+
+
+/* ── Message search ─────────────────────────────── */
+
+let searchSeq = 0;
+
+async function runMessageSearch() {
+  const q = msgSearchInput.value.trim();
+  const seq = ++searchSeq;
+  if (q.length < 2) {
+    msgSearchResults.hidden = true;
+    msgSearchResults.innerHTML = '';
+    return;
+  }
+
+  try {
+    const res  = await authFetch(`${API_BASE}/api/messages/search?q=${encodeURIComponent(q)}`);
+    const hits = await res.json();
+    if (seq !== searchSeq || !res.ok || !Array.isArray(hits)) return;
+
+    msgSearchResults.innerHTML = '';
+    if (hits.length === 0) {
+      const li = document.createElement('li');
+      li.className   = 'search-hit search-hit--empty';
+      li.textContent = 'No messages found';
+      msgSearchResults.appendChild(li);
+    }
+    hits.forEach(h => {
+      const li = document.createElement('li');
+      li.className = 'search-hit';
+
+      const who = document.createElement('span');
+      who.className   = 'search-hit__who';
+      who.textContent = `${h.partner_nickname} · ${formatDate(h.message.created_at)}`;
+
+      const text = document.createElement('span');
+      text.className   = 'search-hit__text';
+      text.textContent = h.message.content;
+
+      li.appendChild(who);
+      li.appendChild(text);
+      li.addEventListener('click', () => jumpToMessage(h.partner_id, h.message.id));
+      msgSearchResults.appendChild(li);
+    });
+    msgSearchResults.hidden = false;
+  } catch (err) {
+    console.error('[Chat] search error:', err);
+  }
+}
+
+msgSearchInput.addEventListener('input', throttle(runMessageSearch, 300));
+msgSearchInput.addEventListener('search', runMessageSearch);
+
+// Open the conversation containing msgID and show the messages around it.
+async function jumpToMessage(partnerID, msgID) {
+  const partner = userMap[partnerID];
+  if (!partner) return;
+  msgSearchResults.hidden = true;
+
+  await openChat(partner);
+  try {
+    const res  = await authFetch(`${API_BASE}/api/messages/context?id=${encodeURIComponent(msgID)}&n=15`);
+    const data = await res.json();
+    if (!res.ok || !Array.isArray(data.messages)) return;
+
+    const me = JSON.parse(sessionStorage.getItem('user'));
+    chatMessagesArea.querySelectorAll('.chat-msg').forEach(el => el.remove());
+    data.messages.forEach(m => chatMessagesArea.appendChild(buildMessage(m, me.id)));
+
+    // Scrolling up continues from the top of the window, scrolling down from the bottom
+    oldestMsgID       = data.messages[0].id;
+    newestMsgID       = data.messages[data.messages.length - 1].id;
+    noMoreMsgs        = !data.has_more_before;
+    chatNoMore.hidden = !noMoreMsgs;
+    viewingLatest     = !data.has_more_after;
+
+    const target = chatMessagesArea.querySelector(`.chat-msg[data-msg-id="${msgID}"]`);
+    if (target) {
+      target.classList.add('chat-msg--highlight');
+      target.scrollIntoView({ block: 'center' });
+      setTimeout(() => target.classList.remove('chat-msg--highlight'), 2500);
+    }
+  } catch (err) {
+    console.error('[Chat] jumpToMessage error:', err);
+  }
+}
 
 function openLightbox(src) {
   let lb = document.getElementById('img-lightbox');
@@ -371,63 +814,19 @@ function closeLightbox() {
 }
 
 
-chatImageBtn.addEventListener('click', () => {
-  chatImageInput.value = '';
-  chatImageInput.click();
+const chatAttachments = createAttachmentPicker({
+  input : chatImageInput,
+  button: chatImageBtn,
+  list  : document.getElementById('chat-attachments'),
 });
 
-chatImageInput.addEventListener('change', async () => {
-  const file = chatImageInput.files[0];
-  if (!file) return;
-
-  chatImageBtn.disabled = true;
-  chatImageBtn.classList.add('uploading');
-
-  try {
-    const url = await uploadImage(file);
-    if (!url) return;
-    pendingImageURL = url;
-    chatImagePreviewImg.src = url;
-    chatImagePreview.hidden = false;
-  } finally {
-    chatImageBtn.disabled = false;
-    chatImageBtn.classList.remove('uploading');
-  }
-});
-
-chatImageRemoveBtn.addEventListener('click', clearChatImagePreview);
-
-function clearChatImagePreview() {
-  pendingImageURL = null;
-  chatImagePreview.hidden = true;
-  chatImagePreviewImg.src = '';
-  chatImageInput.value = '';
-}
-
-async function uploadImage(file) {
-  const form = new FormData();
-  form.append('image', file);
-  try {
-    const res = await authFetch(`${API_BASE}/api/upload`, { method: 'POST', body: form });
-    const data = await res.json();
-    if (!res.ok) {
-      alert(data.error || 'Upload failed');
-      return null;
-    }
-    return data.url;
-  } catch {
-    alert('Upload failed. Please try again.');
-    return null;
-  }
-}
-
 function handleNewPost(post) {
   const postsPage = document.getElementById('posts-page');
   if (!postsPage || postsPage.style.display === 'none') return;
 
+  // Only arrives for the topics the feed subscribed to, so it matches the filter
   const me = JSON.parse(sessionStorage.getItem('user') || '{}');
   if (String(post.user_id) === String(me.id)) return;
-  if (activeSpecialFilter !== 'all' || activeCategories.size > 0) return;
 
   const feed = document.getElementById('posts-feed');
   const empty = document.getElementById('posts-feed-empty');
@@ -436,10 +835,17 @@ function handleNewPost(post) {
   const card = buildPostCard(post);
   feed.prepend(card);
   if (empty) empty.hidden = true;
+  feedTopics.push(`post:${post.id}`);
+  addTopics([`post:${post.id}`]);
 }
 
 function handleVoteUpdate(data) {
-  const card = document.querySelector(`.post-card[data-post-id="${data.post_id}"]`);
+  let card = document.querySelector(`.post-card[data-post-id="${data.post_id}"]`);
+  if (typeof activePost !== 'undefined' && activePost && activePost.id === data.post_id) {
+    activePost.upvotes   = data.upvotes;
+    activePost.downvotes = data.downvotes;
+    card = document.getElementById('post-detail-content');
+  }
   if (!card) return;
 
   const upBtn   = card.querySelector('.vote-btn--up');
@@ -448,8 +854,48 @@ function handleVoteUpdate(data) {
   if (downBtn) downBtn.querySelector('.vote-count').textContent = data.downvotes;
 }
 
-function handleIncomingMessage(msg) {
+function handleNewComment(c) {
+  if (typeof activePost === 'undefined' || !activePost || activePost.id !== c.post_id) return;
+  appendComment(c);
+}
+
+function handleCommentDeleted(data) {
+  if (typeof activePost === 'undefined' || !activePost || activePost.id !== data.post_id) return;
+  const div = document.querySelector(`.comment[data-comment-id="${data.comment_id}"]`);
+  if (div) div.remove();
+  const list = document.getElementById('comments-list');
+  const empty = document.getElementById('comments-empty');
+  if (list && empty && !list.querySelector('.comment')) empty.hidden = false;
+}
+
+function handlePostDeleted(data) {
+  const card = document.querySelector(`.post-card[data-post-id="${data.post_id}"]`);
+  if (card) card.remove();
+  feedTopics = feedTopics.filter(t => t !== `post:${data.post_id}`);
+
+  const feed = document.getElementById('posts-feed');
+  const empty = document.getElementById('posts-feed-empty');
+  if (feed && empty && !feed.querySelector('.post-card')) empty.hidden = false;
+
+  // The open post is gone; fall back to the feed
+  if (typeof activePost !== 'undefined' && activePost && activePost.id === data.post_id) {
+    document.getElementById('back-to-posts-btn').click();
+  }
+}
+
+function handleIncomingMessage(msg, silent = false) {
+  // Replayed events can repeat a message we already rendered
+  if (chatMessagesArea.querySelector(`.chat-msg[data-msg-id="${msg.id}"]`)) return;
+
   const me = JSON.parse(sessionStorage.getItem('user') || '{}');
+
+  // Move the conversation to the top of the sidebar
+  const partnerID = String(msg.sender_id) === String(me.id) ? msg.receiver_id : msg.sender_id;
+  if (userMap[partnerID]) {
+    userMap[partnerID].last_msg = msg.created_at;
+    drawUserList();
+  }
+
   const chatVisible = chatPanel.style.display === 'flex';
 
   if (
@@ -458,8 +904,13 @@ function handleIncomingMessage(msg) {
     (String(msg.sender_id) === String(activePartner.id) ||
      String(msg.receiver_id) === String(activePartner.id))
   ) {
-    chatMessagesArea.appendChild(buildMessage(msg, me.id));
-    chatMessagesArea.scrollTop = chatMessagesArea.scrollHeight;
+    if (String(msg.sender_id) === String(activePartner.id)) chatPartnerTyping.hidden = true;
+    // While browsing older history the message is picked up by loadNewerMessages
+    if (viewingLatest) {
+      chatMessagesArea.appendChild(buildMessage(msg, me.id));
+      chatMessagesArea.scrollTop = chatMessagesArea.scrollHeight;
+      newestMsgID = msg.id;
+    }
     // Mark as read immediately since we're looking at the conversation
     if (ws && ws.readyState === 1 && String(msg.sender_id) !== String(me.id)) {
       ws.send(JSON.stringify({ type: 'mark_read', payload: { sender_id: msg.sender_id } }));
@@ -469,6 +920,7 @@ function handleIncomingMessage(msg) {
     unread[sid] = (unread[sid] || 0) + 1;
     updateBadge(sid);
     updateNavBadge();
+    if (!silent) showMessageToast(msg);
   }
 }
 
@@ -496,31 +948,45 @@ function updateBadge(userID) {
 chatInputForm.addEventListener('submit', (e) => {
   e.preventDefault();
   const text = chatInput.value.trim();
-  if (!text && !pendingImageURL) return;
+  if (!text && !chatAttachments.hasItems()) return;
   if (!activePartner || !ws || ws.readyState !== 1) return;
 
   // Block sending to offline users
   if (!activePartner.online) return;
 
-  ws.send(JSON.stringify({
-    type   : 'send_message',
-    payload: {
-      receiver_id: activePartner.id,
-      content    : text,
-      image_url  : pendingImageURL || '',
-    },
-  }));
+  const payload = {
+    client_id  : newClientID(),
+    receiver_id: activePartner.id,
+    content    : text,
+    attachments: chatAttachments.refs(),
+  };
+  outbox.set(payload.client_id, payload);
+  ws.send(JSON.stringify({ type: 'send_message', payload }));
+
+  // The server clears the typing indicator when the message is stored
+  typingActive = false;
+  clearTimeout(typingStopTimer);
 
   chatInput.value        = '';
   chatInput.style.height = '';
-  clearChatImagePreview();
+  chatAttachments.clear();
 });
 
 chatInput.addEventListener('input', () => {
   chatInput.style.height = 'auto';
   chatInput.style.height = Math.min(chatInput.scrollHeight, 120) + 'px';
+
+  if (!chatInput.value.trim()) {
+    sendTypingStop();
+    return;
+  }
+  sendTypingStart();
+  clearTimeout(typingStopTimer);
+  typingStopTimer = setTimeout(sendTypingStop, 4000);
 });
 
+chatInput.addEventListener('blur', sendTypingStop);
+
 chatInput.addEventListener('keydown', (e) => {
   if (e.key === 'Enter' && !e.shiftKey) {
     e.preventDefault();
@@ -529,21 +995,23 @@ chatInput.addEventListener('keydown', (e) => {
 });
 
 chatBackBtn.addEventListener('click', () => {
+  sendTypingStop();
   if (topSentinelObserver) topSentinelObserver.disconnect();
   chatConversation.style.display = 'none';
   chatPlaceholder.style.display  = '';
   activePartner = null;
-  clearChatImagePreview();
+  chatAttachments.clear();
   document.querySelectorAll('.user-item').forEach(el => el.classList.remove('active'));
   showAppSection('posts');
 });
 
 navHome.addEventListener('click', (e) => {
   e.preventDefault();
+  sendTypingStop();
   activePartner = null;
   chatConversation.style.display = 'none';
   chatPlaceholder.style.display  = '';
-  clearChatImagePreview();
+  chatAttachments.clear();
   document.querySelectorAll('.user-item').forEach(el => el.classList.remove('active'));
   showAppSection('posts');
   if (typeof loadPosts === 'function') loadPosts('all');
@@ -567,11 +1035,71 @@ function initChat() {
 
 // Called by logout handler — closes WS immediately so the server removes us
 // from hub.clients and broadcasts our offline status to all other users.
+const WS_FALLBACK_AFTER = 2;
+
+// Real-time protocol version and features this client understands; the
+// server's schema is at /api/ws/schema.
+const PROTOCOL_VERSION    = 1;
+const CLIENT_CAPABILITIES = ['resume', 'receipts', 'typing', 'presence', 'status', 'edit', 'topics'];
+
+// setTopics replaces the view's subscriptions (feed, post:<id>, category:<name>,
+// user:<id>) so the server only sends events for what is on screen.
+function setTopics(wanted) {
+  const next = new Set(wanted);
+  sendTopics('unsubscribe', [...topics].filter(t => !next.has(t)));
+  sendTopics('subscribe',   [...next].filter(t => !topics.has(t)));
+  topics = next;
+}
+
+function addTopics(extra) {
+  setTopics([...topics, ...extra]);
+}
+
+function sendTopics(type, list) {
+  if (list.length === 0 || !ws || ws.readyState !== 1) return;
+  ws.send(JSON.stringify({ type, payload: { topics: list } }));
+}
+
+// EventStreamSocket looks enough like a WebSocket for connectWS and every
+// ws.send() call: events arrive over /api/events (SSE) and requests are
+// POSTed to /api/events/send, with acks coming back on the stream.
+class EventStreamSocket {
+  constructor(url) {
+    this.readyState = 0;
+    this.source = new EventSource(url);
+    this.source.onopen = () => {
+      this.readyState = 1;
+      if (this.onopen) this.onopen();
+    };
+    this.source.onmessage = (e) => { if (this.onmessage) this.onmessage(e); };
+    // Reconnect through connectWS so the resume uses our lastSeq
+    this.source.onerror = () => this.close();
+  }
+
+  send(data) {
+    authFetch(`${API_BASE}/api/events/send`, {
+      method : 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body   : data,
+    }).catch(() => {});
+  }
+
+  close() {
+    if (this.readyState === 3) return;
+    this.readyState = 3;
+    this.source.close();
+    if (this.onclose) this.onclose();
+  }
+}
+
 function disconnectWS() {
+  wsFailures = 0;
   if (ws) {
     const dying = ws;
     ws = null;            // prevent onclose from reconnecting
     chatInitialized = false;
+    lastSeq = null;
+    outbox.clear();
     dying.close();
   }
 }
@@ -0,0 +1,166 @@
+const draftsToggleBtn = document.getElementById('drafts-toggle-btn');
+const draftsPanel     = document.getElementById('drafts-panel');
+const draftStatus     = document.getElementById('draft-status');
+
+let currentDraftID = null; // the draft the post form is saving into
+let draftTimer     = null;
+
+const DRAFT_AUTOSAVE_DELAY = 1500;
+
+// Autosave a little while after the author stops typing
+createPostForm.addEventListener('input', (e) => {
+  if (e.target === postImageInput) return; // saved once the upload is done
+  scheduleDraftAutosave();
+});
+
+function scheduleDraftAutosave() {
+  cancelDraftAutosave();
+  draftTimer = setTimeout(saveDraft, DRAFT_AUTOSAVE_DELAY);
+}
+
+function cancelDraftAutosave() {
+  clearTimeout(draftTimer);
+  draftTimer = null;
+}
+
+async function saveDraft() {
+  draftTimer = null;
+  const draft = {
+    id         : currentDraftID || '',
+    title      : postTitleInput.value,
+    content    : postContentInput.value,
+    categories : getSelectedCategories(),
+    attachments: postAttachments.refs(),
+    poll       : getPollSpec(),
+  };
+  if (!draft.id && !draft.title.trim() && !draft.content.trim() && draft.attachments.length === 0) return;
+
+  try {
+    const res  = await authFetch(`${API_BASE}/api/drafts`, {
+      method : 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body   : JSON.stringify(draft),
+    });
+    const data = await res.json();
+    if (!res.ok) {
+      // The draft was published or deleted elsewhere; start a new one
+      if (res.status === 404) currentDraftID = null;
+      return;
+    }
+    currentDraftID = data.id;
+    setDraftStatus(`Draft saved ${new Date(data.updated_at).toLocaleTimeString(undefined, { hour: '2-digit', minute: '2-digit' })}`);
+    if (!draftsPanel.hidden) loadDrafts();
+  } catch { /* silent */ }
+}
+
+function setDraftStatus(text) {
+  draftStatus.textContent = text;
+}
+
+// resetPostForm empties the post form and stops it saving into the draft it
+// was editing.
+function resetPostForm() {
+  cancelDraftAutosave();
+  createPostForm.reset();
+  postAttachments.clear();
+  resetPollEditor();
+  currentDraftID = null;
+  setDraftStatus('');
+}
+
+async function loadDrafts() {
+  try {
+    const res    = await authFetch(`${API_BASE}/api/drafts`);
+    const drafts = await res.json();
+    if (!res.ok) return;
+
+    draftsPanel.innerHTML = '';
+    if (drafts.length === 0) {
+      draftsPanel.innerHTML = '<p class="drafts-empty">No drafts.</p>';
+      return;
+    }
+    drafts.forEach(d => draftsPanel.appendChild(buildDraftRow(d)));
+  } catch { /* silent */ }
+}
+
+function buildDraftRow(d) {
+  const row = document.createElement('div');
+  row.className = 'draft-row';
+  row.classList.toggle('draft-row--current', d.id === currentDraftID);
+  row.innerHTML = `
+    <div class="draft-row__info">
+      <span class="draft-row__title">${escapeHTML(d.title || 'Untitled')}</span>
+      <span class="draft-row__meta">${d.publish_at
+        ? `Scheduled for ${formatDate(d.publish_at)}`
+        : `Edited ${timeAgo(d.updated_at)}`}</span>
+    </div>
+    <button type="button" data-action="open">Edit</button>
+    ${d.publish_at ? '<button type="button" data-action="cancel">Unschedule</button>' : ''}
+    <button type="button" data-action="delete">Delete</button>`;
+
+  row.querySelector('[data-action="open"]').addEventListener('click', () => openDraft(d));
+  row.querySelector('[data-action="cancel"]')?.addEventListener('click', () =>
+    draftAction('/api/posts/scheduled/cancel', d.id));
+  row.querySelector('[data-action="delete"]').addEventListener('click', async () => {
+    if (!confirm('Delete this draft?')) return;
+    if (await draftAction('/api/drafts/delete', d.id) && d.id === currentDraftID) resetPostForm();
+  });
+  return row;
+}
+
+// handleDraftUnscheduled tells the author a scheduled post couldn't be
+// published. The draft is kept, no longer scheduled, for them to fix.
+function handleDraftUnscheduled(data) {
+  const toast = document.createElement('div');
+  toast.className   = 'message-toast';
+  toast.textContent = `"${data.title || 'Untitled'}" wasn't published: ${data.reason}`;
+  toast.addEventListener('click', () => toast.remove());
+  document.body.appendChild(toast);
+  setTimeout(() => toast.remove(), 8000);
+  if (!draftsPanel.hidden) loadDrafts();
+}
+
+async function draftAction(path, id) {
+  try {
+    const res = await authFetch(`${API_BASE}${path}`, {
+      method : 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body   : JSON.stringify({ id }),
+    });
+    if (res.ok) loadDrafts();
+    return res.ok;
+  } catch {
+    return false;
+  }
+}
+
+// openDraft loads a draft into the post form; edits keep saving into it.
+function openDraft(d) {
+  resetPostForm();
+  currentDraftID         = d.id;
+  postTitleInput.value   = d.title;
+  postContentInput.value = d.content;
+  const categories = d.category ? d.category.split(',') : [];
+  document.querySelectorAll('#post-categories-options input').forEach(el => {
+    el.checked = categories.includes(el.value);
+  });
+  postAttachments.set(d.attachments);
+  setPollSpec(d.poll);
+  if (d.publish_at) postPublishAtInput.value = toLocalInputValue(d.publish_at);
+  setDraftStatus(d.publish_at ? `Scheduled for ${formatDate(d.publish_at)}` : 'Editing draft');
+  loadDrafts();
+  postTitleInput.focus();
+}
+
+// toLocalInputValue formats a timestamp for a datetime-local input, which
+// takes local time without a zone.
+function toLocalInputValue(iso) {
+  const d = new Date(iso);
+  d.setMinutes(d.getMinutes() - d.getTimezoneOffset());
+  return d.toISOString().slice(0, 16);
+}
+
+draftsToggleBtn.addEventListener('click', () => {
+  draftsPanel.hidden = !draftsPanel.hidden;
+  if (!draftsPanel.hidden) loadDrafts();
+});
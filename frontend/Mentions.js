@@ -0,0 +1,153 @@
+// renderMentions escapes text and links the @mentions the server resolved.
+// Mention offsets count code points, so slice an array of them, not the string.
+function renderMentions(text, mentions) {
+  if (!mentions || mentions.length === 0) return escapeHTML(text);
+  const chars = Array.from(text);
+  let html = '';
+  let pos  = 0;
+  mentions.forEach(m => {
+    if (m.start < pos || m.end > chars.length) return;
+    html += escapeHTML(chars.slice(pos, m.start).join(''));
+    html += `<a href="#" class="mention" data-user-id="${escapeHTML(m.user_id)}">${escapeHTML(chars.slice(m.start, m.end).join(''))}</a>`;
+    pos = m.end;
+  });
+  return html + escapeHTML(chars.slice(pos).join(''));
+}
+
+// renderContent returns the HTML for a post, comment or message: the Markdown
+// the server rendered and sanitized, with the @mentions in it linked. Items
+// that haven't been through the server yet fall back to their plain text.
+function renderContent(item) {
+  if (!item.content_html) return renderMentions(item.content, item.mentions).replace(/\n/g, '<br>');
+  if (!item.mentions || item.mentions.length === 0) return item.content_html;
+
+  const ids = {};
+  item.mentions.forEach(m => { ids[m.nickname.toLowerCase()] = m.user_id; });
+  const names = Object.keys(ids).map(n => n.replace(/[.*+?^${}()|[\]\\]/g, '\\$&'));
+  const pattern = new RegExp(`(^|[^\\p{L}\\p{N}_@])@(${names.join('|')})(?![\\p{L}\\p{N}_.\\-]*[\\p{L}\\p{N}_])`, 'giu');
+
+  const tpl = document.createElement('template');
+  tpl.innerHTML = item.content_html;
+  const walker = document.createTreeWalker(tpl.content, NodeFilter.SHOW_TEXT);
+  const nodes = [];
+  while (walker.nextNode()) {
+    if (!walker.currentNode.parentElement?.closest('a, code, pre')) nodes.push(walker.currentNode);
+  }
+  nodes.forEach(node => {
+    const text = node.textContent;
+    const frag = document.createDocumentFragment();
+    let pos = 0;
+    for (const m of text.matchAll(pattern)) {
+      const at = m.index + m[1].length;
+      frag.append(text.slice(pos, at));
+      const link = document.createElement('a');
+      link.href = '#';
+      link.className = 'mention';
+      link.dataset.userId = ids[m[2].toLowerCase()];
+      link.textContent = '@' + m[2];
+      frag.append(link);
+      pos = at + 1 + m[2].length;
+    }
+    if (pos === 0) return;
+    frag.append(text.slice(pos));
+    node.replaceWith(frag);
+  });
+  return tpl.innerHTML;
+}
+
+// Clicking a mention opens a chat with that user instead of whatever the
+// surrounding card or message would do.
+document.addEventListener('click', (e) => {
+  const link = e.target.closest('.mention');
+  if (!link) return;
+  e.preventDefault();
+  e.stopPropagation();
+  const user = userMap[link.dataset.userId];
+  if (user) openChat(user);
+}, true);
+
+// attachMentionAutocomplete suggests nicknames while an "@word" is being typed
+// in input, and completes the one that is clicked or picked with Enter/Tab.
+function attachMentionAutocomplete(input) {
+  if (!input) return;
+  const list = document.createElement('ul');
+  list.className = 'mention-suggestions';
+  list.hidden = true;
+  input.parentNode.insertBefore(list, input.nextSibling);
+
+  let active  = 0;
+  let reqSeq  = 0;
+  let matches = [];
+
+  const close = () => { list.hidden = true; matches = []; };
+
+  // The "@prefix" just before the caret, if any
+  const currentQuery = () => {
+    const before = input.value.slice(0, input.selectionStart);
+    const m = before.match(/(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_.\-]*)$/u);
+    return m ? { prefix: m[1], start: input.selectionStart - m[1].length - 1 } : null;
+  };
+
+  const pick = (match) => {
+    const q = currentQuery();
+    if (!q) return close();
+    const after = input.value.slice(input.selectionStart);
+    const insert = `@${match.nickname} `;
+    input.value = input.value.slice(0, q.start) + insert + after;
+    const caret = q.start + insert.length;
+    input.setSelectionRange(caret, caret);
+    input.focus();
+    close();
+  };
+
+  const draw = () => {
+    list.innerHTML = '';
+    matches.forEach((match, i) => {
+      const li = document.createElement('li');
+      li.textContent = '@' + match.nickname;
+      li.classList.toggle('active', i === active);
+      li.addEventListener('mousedown', (e) => {
+        e.preventDefault(); // keep focus in the input
+        pick(match);
+      });
+      list.appendChild(li);
+    });
+    list.hidden = matches.length === 0;
+  };
+
+  input.addEventListener('input', async () => {
+    const q = currentQuery();
+    if (!q || q.prefix === '') return close();
+
+    const seq = ++reqSeq;
+    try {
+      const res  = await authFetch(`${API_BASE}/api/users/mentions?q=${encodeURIComponent(q.prefix)}`);
+      const data = await res.json();
+      if (seq !== reqSeq || !res.ok) return;
+      matches = data;
+      active  = 0;
+      draw();
+    } catch { /* silent */ }
+  });
+
+  input.addEventListener('keydown', (e) => {
+    if (list.hidden) return;
+    if (e.key === 'ArrowDown' || e.key === 'ArrowUp') {
+      e.preventDefault();
+      active = (active + (e.key === 'ArrowDown' ? 1 : matches.length - 1)) % matches.length;
+      draw();
+    } else if (e.key === 'Enter' || e.key === 'Tab') {
+      e.preventDefault();
+      e.stopImmediatePropagation(); // don't submit the form
+      pick(matches[active]);
+    } else if (e.key === 'Escape') {
+      close();
+    }
+  }, true); // capture, so this runs before the input's own Enter handling
+
+  input.addEventListener('blur', close);
+}
+
+attachMentionAutocomplete(document.getElementById('post-content'));
+attachMentionAutocomplete(document.getElementById('comment-input'));
+attachMentionAutocomplete(document.getElementById('chat-input'));
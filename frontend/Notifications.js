@@ -0,0 +1,205 @@
+const notifBtn      = document.getElementById('nav-notif-btn');
+const notifBadge    = document.getElementById('nav-notif-badge');
+const notifPanel    = document.getElementById('notif-panel');
+const notifList     = document.getElementById('notif-list');
+const notifEmpty    = document.getElementById('notif-empty');
+const notifMoreBtn  = document.getElementById('notif-more-btn');
+const notifReadAll  = document.getElementById('notif-read-all-btn');
+const notifPrefsBtn = document.getElementById('notif-prefs-btn');
+const notifPrefs    = document.getElementById('notif-prefs');
+
+let notifUnread = 0;
+let notifOldest = null; // cursor for /api/notifications?before=
+
+const NOTIF_TYPE_LABELS = {
+  comment : 'Comments on my posts',
+  reply   : 'Comments on posts I watch',
+  mention : 'Mentions',
+  vote    : 'Upvotes on my posts',
+  post    : 'New posts from people I follow',
+  category: 'New posts in categories I follow',
+};
+
+async function loadNotifications(more = false) {
+  let url = `${API_BASE}/api/notifications?limit=20`;
+  if (more && notifOldest) url += `&before=${encodeURIComponent(notifOldest)}`;
+
+  try {
+    const res  = await authFetch(url);
+    const data = await res.json();
+    if (!res.ok) return;
+
+    if (!more) notifList.innerHTML = '';
+    data.notifications.forEach(n => notifList.appendChild(buildNotification(n)));
+    if (data.notifications.length > 0) notifOldest = data.notifications[data.notifications.length - 1].id;
+    notifMoreBtn.hidden = !data.has_more;
+    notifEmpty.hidden   = notifList.children.length > 0;
+    setNotifUnread(data.unread);
+  } catch { /* silent */ }
+}
+
+function buildNotification(n) {
+  const div = document.createElement('div');
+  div.className = 'notif-item';
+  div.dataset.notifId = n.id;
+  div.classList.toggle('notif-item--unread', !n.read);
+  div.innerHTML = `
+    <span class="notif-item__text">${escapeHTML(n.text)}</span>
+    ${n.post_title ? `<span class="notif-item__post">${escapeHTML(n.post_title)}</span>` : ''}
+    <span class="notif-item__date">${timeAgo(n.updated_at)}</span>`;
+  div.addEventListener('click', () => {
+    if (div.classList.contains('notif-item--unread')) markNotificationsRead([n.id]);
+    notifPanel.hidden = true;
+    openNotificationPost(n.post_id);
+  });
+  return div;
+}
+
+// Live notifications replace the row they were folded into and move it to the top
+function handleNotification(data, silent) {
+  const existing = notifList.querySelector(`.notif-item[data-notif-id="${data.notification.id}"]`);
+  if (existing) existing.remove();
+  notifList.prepend(buildNotification(data.notification));
+  notifEmpty.hidden = true;
+  setNotifUnread(data.unread);
+
+  if (!silent) showNotificationToast(data.notification);
+}
+
+function showNotificationToast(n) {
+  const toast = document.createElement('div');
+  toast.className   = 'message-toast';
+  toast.textContent = n.post_title ? `${n.text}: ${n.post_title}` : n.text;
+  toast.addEventListener('click', () => {
+    toast.remove();
+    markNotificationsRead([n.id]);
+    openNotificationPost(n.post_id);
+  });
+  document.body.appendChild(toast);
+  setTimeout(() => toast.remove(), 4000);
+}
+
+function setNotifUnread(count) {
+  notifUnread = count;
+  notifBadge.textContent = count > 99 ? '99+' : count;
+  notifBadge.hidden = count === 0;
+}
+
+async function markNotificationsRead(ids) {
+  ids.forEach(id => {
+    const div = notifList.querySelector(`.notif-item[data-notif-id="${id}"]`);
+    if (div) div.classList.remove('notif-item--unread');
+  });
+  try {
+    const res = await authFetch(`${API_BASE}/api/notifications/read`, {
+      method : 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body   : JSON.stringify({ ids }),
+    });
+    if (res.ok) setNotifUnread((await res.json()).unread);
+  } catch { /* silent */ }
+}
+
+// Posts have no single-post endpoint, so use the card on screen when there is one
+async function openNotificationPost(postID) {
+  const card = document.querySelector(`.post-card[data-post-id="${postID}"]`);
+  if (card) {
+    card.click();
+    return;
+  }
+  try {
+    const res   = await authFetch(`${API_BASE}/api/posts`);
+    const posts = await res.json();
+    const post  = res.ok && posts ? posts.find(p => p.id === postID) : null;
+    if (post) openPostDetail(post);
+  } catch { /* silent */ }
+}
+
+async function loadNotificationPrefs() {
+  try {
+    const [prefsRes, subsRes] = await Promise.all([
+      authFetch(`${API_BASE}/api/notifications/preferences`),
+      authFetch(`${API_BASE}/api/subscriptions`),
+    ]);
+    const prefs = await prefsRes.json();
+    const subs  = await subsRes.json();
+    if (!prefsRes.ok || !subsRes.ok) return;
+
+    notifPrefs.innerHTML = '';
+    Object.entries(NOTIF_TYPE_LABELS).forEach(([type, label]) => {
+      notifPrefs.appendChild(buildPrefRow(label, prefs[type], (checked) =>
+        authFetch(`${API_BASE}/api/notifications/preferences`, {
+          method : 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body   : JSON.stringify({ [type]: checked }),
+        })));
+    });
+
+    // The categories to subscribe to are the ones the feed can be filtered by
+    const heading = document.createElement('span');
+    heading.className   = 'notif-prefs__heading';
+    heading.textContent = 'Categories I follow';
+    notifPrefs.appendChild(heading);
+    categoryFilterBtns.forEach(btn => {
+      const category = btn.dataset.category;
+      notifPrefs.appendChild(buildPrefRow(btn.textContent, subs.categories.includes(category), (checked) =>
+        authFetch(`${API_BASE}/api/categories/${checked ? 'subscribe' : 'unsubscribe'}`, {
+          method : 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body   : JSON.stringify({ category }),
+        })));
+    });
+
+    const unsubAll = document.createElement('button');
+    unsubAll.type        = 'button';
+    unsubAll.className   = 'notif-prefs__unsubscribe';
+    unsubAll.textContent = `Unsubscribe from everything (${subs.posts.length} posts, ${subs.categories.length} categories)`;
+    unsubAll.addEventListener('click', async () => {
+      if (!confirm('Stop watching every post and unfollow every category?')) return;
+      try {
+        const res = await authFetch(`${API_BASE}/api/subscriptions/unsubscribe-all`, { method: 'POST' });
+        if (!res.ok) return;
+        if (activePost) activePost.is_watching = false;
+        document.querySelectorAll('.watch-btn').forEach(b => {
+          b.textContent = 'Watch';
+          b.classList.remove('follow-btn--active');
+        });
+        loadNotificationPrefs();
+      } catch { /* silent */ }
+    });
+    notifPrefs.appendChild(unsubAll);
+  } catch { /* silent */ }
+}
+
+function buildPrefRow(label, checked, onChange) {
+  const row = document.createElement('label');
+  row.className = 'notif-pref';
+  row.innerHTML = `<input type="checkbox" ${checked ? 'checked' : ''}/> ${escapeHTML(label)}`;
+  row.querySelector('input').addEventListener('change', (e) => onChange(e.target.checked));
+  return row;
+}
+
+notifBtn.addEventListener('click', (e) => {
+  e.stopPropagation();
+  notifPanel.hidden = !notifPanel.hidden;
+  if (!notifPanel.hidden) loadNotifications();
+});
+
+notifPanel.addEventListener('click', (e) => e.stopPropagation());
+document.addEventListener('click', () => { notifPanel.hidden = true; });
+
+notifMoreBtn.addEventListener('click', () => loadNotifications(true));
+
+notifReadAll.addEventListener('click', async () => {
+  try {
+    const res = await authFetch(`${API_BASE}/api/notifications/read-all`, { method: 'POST' });
+    if (!res.ok) return;
+    notifList.querySelectorAll('.notif-item--unread').forEach(div => div.classList.remove('notif-item--unread'));
+    setNotifUnread(0);
+  } catch { /* silent */ }
+});
+
+notifPrefsBtn.addEventListener('click', () => {
+  notifPrefs.hidden = !notifPrefs.hidden;
+  if (!notifPrefs.hidden) loadNotificationPrefs();
+});
@@ -0,0 +1,164 @@
+const pollToggle       = document.getElementById('post-poll-toggle');
+const pollEditor       = document.getElementById('post-poll-editor');
+const pollOptionsList  = document.getElementById('post-poll-options');
+const pollAddBtn       = document.getElementById('post-poll-add-btn');
+const pollMultiple     = document.getElementById('post-poll-multiple');
+const pollAnonymous    = document.getElementById('post-poll-anonymous');
+const pollClosesAt     = document.getElementById('post-poll-closes-at');
+const pollError        = document.getElementById('post-poll-error');
+
+const MAX_POLL_OPTIONS = 10;
+
+// pollStates holds the latest results for each poll on screen, by post. The
+// feed card and the detail view share the object, so both stay current.
+const pollStates = {};
+
+// ── Poll editor in the post form ──
+
+function addPollOptionInput(value = '') {
+  const input = document.createElement('input');
+  input.type        = 'text';
+  input.className   = 'poll-option-input';
+  input.maxLength   = 100;
+  input.placeholder = `Option ${pollOptionsList.children.length + 1}`;
+  input.value       = value;
+  pollOptionsList.appendChild(input);
+  pollAddBtn.hidden = pollOptionsList.children.length >= MAX_POLL_OPTIONS;
+}
+
+function resetPollEditor() {
+  pollOptionsList.innerHTML = '';
+  addPollOptionInput();
+  addPollOptionInput();
+  pollToggle.checked = false;
+  pollEditor.hidden  = true;
+  pollError.textContent = '';
+}
+
+// getPollSpec returns the poll being written, or null when there is none.
+function getPollSpec() {
+  if (!pollToggle.checked) return null;
+  return {
+    options  : [...pollOptionsList.querySelectorAll('input')].map(el => el.value),
+    multiple : pollMultiple.checked,
+    anonymous: pollAnonymous.checked,
+    closes_at: pollClosesAt.value ? new Date(pollClosesAt.value).toISOString() : '',
+  };
+}
+
+function setPollSpec(spec) {
+  resetPollEditor();
+  if (!spec) return;
+  pollToggle.checked = true;
+  pollEditor.hidden  = false;
+  pollOptionsList.innerHTML = '';
+  spec.options.forEach(o => addPollOptionInput(o));
+  while (pollOptionsList.children.length < 2) addPollOptionInput();
+  pollMultiple.checked  = spec.multiple;
+  pollAnonymous.checked = spec.anonymous;
+  if (spec.closes_at) pollClosesAt.value = toLocalInputValue(spec.closes_at);
+}
+
+// validatePollSpec returns what is wrong with spec, or '' if nothing is.
+function validatePollSpec(spec) {
+  if (!spec) return '';
+  const options = spec.options.map(o => o.trim()).filter(Boolean);
+  if (options.length < 2) return 'A poll needs at least two options.';
+  if (new Set(options.map(o => o.toLowerCase())).size !== options.length) return 'Poll options must all be different.';
+  return '';
+}
+
+pollToggle.addEventListener('change', () => { pollEditor.hidden = !pollToggle.checked; });
+pollAddBtn.addEventListener('click', () => {
+  addPollOptionInput();
+  pollOptionsList.lastElementChild.focus();
+});
+
+resetPollEditor();
+
+// ── Poll on a post ──
+
+function buildPoll(poll) {
+  pollStates[poll.post_id] = poll;
+  const div = document.createElement('div');
+  div.className = 'poll';
+  div.dataset.postId = poll.post_id;
+  // Voting shouldn't also open the post
+  div.addEventListener('click', (e) => e.stopPropagation());
+  renderPoll(div, poll);
+  return div;
+}
+
+function isPollClosed(poll) {
+  return poll.closed || (poll.closes_at !== '' && new Date(poll.closes_at) <= new Date());
+}
+
+function renderPoll(div, poll) {
+  const closed = isPollClosed(poll);
+  const mine   = poll.my_votes || [];
+  const total  = poll.options.reduce((sum, o) => sum + o.votes, 0);
+
+  const meta = [
+    poll.multiple ? 'Choose any' : 'Choose one',
+    poll.anonymous ? 'anonymous' : 'public',
+    closed ? 'closed' : poll.closes_at ? `closes ${formatDate(poll.closes_at)}` : '',
+  ].filter(Boolean).join(' · ');
+
+  div.classList.toggle('poll--closed', closed);
+  div.innerHTML = `<div class="poll__meta">${escapeHTML(meta)}</div>`;
+  poll.options.forEach(o => {
+    const pct = total ? Math.round(o.votes * 100 / total) : 0;
+    const btn = document.createElement('button');
+    btn.type      = 'button';
+    btn.className = 'poll__option';
+    btn.disabled  = closed;
+    btn.classList.toggle('poll__option--mine', mine.includes(o.id));
+    if (o.voters) btn.title = o.voters.map(v => v.nickname).join(', ');
+    btn.innerHTML = `
+      <span class="poll__bar" style="width:${pct}%"></span>
+      <span class="poll__text">${escapeHTML(o.text)}</span>
+      <span class="poll__count">${o.votes} · ${pct}%</span>`;
+    btn.addEventListener('click', () => {
+      let next;
+      if (poll.multiple) {
+        next = mine.includes(o.id) ? mine.filter(id => id !== o.id) : [...mine, o.id];
+      } else {
+        next = mine.includes(o.id) ? [] : [o.id];
+      }
+      submitPollVote(poll.post_id, next);
+    });
+    div.appendChild(btn);
+  });
+
+  const foot = document.createElement('div');
+  foot.className   = 'poll__meta';
+  foot.textContent = `${poll.total_voters} ${poll.total_voters === 1 ? 'voter' : 'voters'}`;
+  div.appendChild(foot);
+}
+
+function rerenderPolls(postID) {
+  const poll = pollStates[postID];
+  document.querySelectorAll(`.poll[data-post-id="${postID}"]`).forEach(div => renderPoll(div, poll));
+}
+
+async function submitPollVote(postID, optionIDs) {
+  try {
+    const res  = await authFetch(`${API_BASE}/api/polls/vote`, {
+      method : 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body   : JSON.stringify({ post_id: postID, option_ids: optionIDs }),
+    });
+    const data = await res.json();
+    if (!res.ok) return;
+    Object.assign(pollStates[postID], data, { my_votes: data.my_votes || [] });
+    rerenderPolls(postID);
+  } catch { /* silent */ }
+}
+
+// poll_update carries everyone's results but not the viewer's own votes
+function handlePollUpdate(data) {
+  const state = pollStates[data.post_id];
+  if (!state) return;
+  Object.assign(state, data, { my_votes: state.my_votes || [] });
+  rerenderPolls(data.post_id);
+}
@@ -3,6 +3,11 @@ const backToPostsBtn     = document.getElementById('back-to-posts-btn');
 const addCommentForm     = document.getElementById('add-comment-form');
 const commentInput       = document.getElementById('comment-input');
 const commentInputError  = document.getElementById('comment-input-error');
+const commentAttachments = createAttachmentPicker({
+  input : document.getElementById('comment-image-input'),
+  button: document.getElementById('comment-image-btn'),
+  list  : document.getElementById('comment-attachments'),
+});
 const commentsList       = document.getElementById('comments-list');
 const commentsEmpty      = document.getElementById('comments-empty');
 
@@ -10,22 +15,20 @@ let activePost = null;
 
 function openPostDetail(post) {
   activePost = post;
-
-  const imgHTML = post.image_url
-    ? `<div class="post-detail__img-wrap" onclick="openLightbox('${escapeAttr(post.image_url)}')">
-         <img src="${escapeAttr(post.image_url)}" class="post-detail__img" alt="post image" loading="lazy"/>
-       </div>`
-    : '';
+  setTopics([`post:${post.id}`]);
 
   postDetailContent.innerHTML = `
     <div class="post-detail__header">
       <span class="post-card__author">@${escapeHTML(post.nickname)}</span>
+      <button type="button" class="follow-btn" hidden></button>
+      <button type="button" class="follow-btn watch-btn"></button>
       <div class="post-card__categories">${buildCategoryBadges(post.category)}</div>
       <span class="post-card__date">${formatDate(post.created_at)}</span>
     </div>
     <h2 class="post-detail__title">${escapeHTML(post.title)}</h2>
-    ${post.content ? `<p class="post-detail__body">${escapeHTML(post.content)}</p>` : ''}
-    ${imgHTML}
+    ${post.content ? `<div class="post-detail__body rich-text">${renderContent(post)}</div>` : ''}
+    <span class="attachments-slot"></span>
+    <span class="poll-slot"></span>
     <div class="post-detail__votes">
       <button class="vote-btn vote-btn--up ${post.user_vote === 1 ? 'vote-btn--active' : ''}" data-value="1">
         <svg width="14" height="14" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2.5" stroke-linecap="round" stroke-linejoin="round"><polyline points="18 15 12 9 6 15"/></svg>
@@ -55,16 +58,79 @@ function openPostDetail(post) {
     });
   });
 
+  setupFollowButton(postDetailContent.querySelector('.follow-btn'), post.user_id);
+  setupWatchButton(postDetailContent.querySelector('.watch-btn'), post);
+  postDetailContent.querySelector('.post-detail__votes').appendChild(buildBookmarkButton(post));
+  fillAttachmentSlot(postDetailContent, post);
+  const pollSlot = postDetailContent.querySelector('.poll-slot');
+  if (post.poll) pollSlot.replaceWith(buildPoll(post.poll));
+  else pollSlot.remove();
+
   commentsList.innerHTML = '';
   commentsEmpty.hidden = true;
   commentInput.value = '';
   commentInputError.textContent = '';
+  commentAttachments.clear();
   loadComments(post.id);
 
   document.getElementById('posts-page').style.display    = 'none';
   document.getElementById('post-detail-page').style.display = 'block';
 }
 
+// Follow toggle for the post's author, with their follower count
+async function setupFollowButton(btn, userID) {
+  const me = JSON.parse(sessionStorage.getItem('user') || '{}');
+  if (String(userID) === String(me.id)) return;
+
+  const render = (p) => {
+    btn.textContent = `${p.is_following ? 'Following' : 'Follow'} · ${p.followers}`;
+    btn.classList.toggle('follow-btn--active', p.is_following);
+    btn.dataset.following = p.is_following ? '1' : '';
+    btn.hidden = false;
+  };
+
+  try {
+    const res = await authFetch(`${API_BASE}/api/users/profile?id=${encodeURIComponent(userID)}`);
+    if (!res.ok) return;
+    render(await res.json());
+  } catch { return; }
+
+  btn.addEventListener('click', async () => {
+    const action = btn.dataset.following ? 'unfollow' : 'follow';
+    try {
+      const res = await authFetch(`${API_BASE}/api/users/${action}`, {
+        method : 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body   : JSON.stringify({ user_id: userID }),
+      });
+      if (res.ok) render(await res.json());
+    } catch { /* silent */ }
+  });
+}
+
+// Watching a post notifies about every new comment on it
+function setupWatchButton(btn, post) {
+  const render = () => {
+    btn.textContent = post.is_watching ? 'Watching' : 'Watch';
+    btn.classList.toggle('follow-btn--active', post.is_watching);
+  };
+  render();
+
+  btn.addEventListener('click', async () => {
+    const action = post.is_watching ? 'unwatch' : 'watch';
+    try {
+      const res = await authFetch(`${API_BASE}/api/posts/${action}`, {
+        method : 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body   : JSON.stringify({ post_id: post.id }),
+      });
+      if (!res.ok) return;
+      post.is_watching = (await res.json()).watching;
+      render();
+    } catch { /* silent */ }
+  });
+}
+
 async function loadComments(postID) {
   try {
     const res  = await authFetch(`${API_BASE}/api/comments?post_id=${encodeURIComponent(postID)}`);
@@ -83,6 +149,13 @@ async function loadComments(postID) {
   } catch { /* silent */ }
 }
 
+// The new_comment event for our own comment can beat the POST response
+function appendComment(c) {
+  if (commentsList.querySelector(`.comment[data-comment-id="${c.id}"]`)) return;
+  commentsEmpty.hidden = true;
+  commentsList.appendChild(buildComment(c));
+}
+
 function buildComment(c) {
   const div = document.createElement('div');
   div.className = 'comment';
@@ -92,7 +165,9 @@ function buildComment(c) {
       <strong class="comment__author">@${escapeHTML(c.nickname)}</strong>
       <span class="comment__date">${formatDate(c.created_at)}</span>
     </div>
-    <p class="comment__text">${escapeHTML(c.content)}</p>`;
+    ${c.content ? `<div class="comment__text rich-text">${renderContent(c)}</div>` : ''}
+    <span class="attachments-slot"></span>`;
+  fillAttachmentSlot(div, c);
   return div;
 }
 
@@ -100,7 +175,7 @@ addCommentForm.addEventListener('submit', async (e) => {
   e.preventDefault();
   commentInputError.textContent = '';
 
-  if (!commentInput.value.trim()) {
+  if (!commentInput.value.trim() && !commentAttachments.hasItems()) {
     commentInputError.textContent = 'Comment cannot be empty.';
     return;
   }
@@ -111,8 +186,9 @@ addCommentForm.addEventListener('submit', async (e) => {
       method : 'POST',
       headers: { 'Content-Type': 'application/json' },
       body   : JSON.stringify({
-        post_id: activePost.id,
-        content: commentInput.value.trim(),
+        post_id    : activePost.id,
+        content    : commentInput.value.trim(),
+        attachments: commentAttachments.refs(),
       }),
     });
 
@@ -122,9 +198,9 @@ addCommentForm.addEventListener('submit', async (e) => {
       return;
     }
 
-    commentsEmpty.hidden = true;
-    commentsList.appendChild(buildComment(data));
+    appendComment(data);
     commentInput.value = '';
+    commentAttachments.clear();
 
   } catch {
     commentInputError.textContent = 'Network error. Please try again.';
@@ -140,14 +216,7 @@ commentInput.addEventListener('keydown', (e) => {
 
 backToPostsBtn.addEventListener('click', () => {
   activePost = null;
+  setTopics(feedTopics);
   document.getElementById('post-detail-page').style.display = 'none';
   document.getElementById('posts-page').style.display       = 'block';
 });
-
-
-function escapeAttr(str) {
-  return String(str)
-    .replace(/&/g, '&amp;')
-    .replace(/"/g, '&quot;')
-    .replace(/'/g, '&#39;');
-}
\ No newline at end of file
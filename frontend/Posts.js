@@ -11,67 +11,19 @@ const specialFilterBtns  = document.querySelectorAll('.filter-btn[data-filter]')
 const categoryFilterBtns = document.querySelectorAll('.filter-btn[data-category]');
 const postImageBtn       = document.getElementById('post-image-btn');
 const postImageInput     = document.getElementById('post-image-input');
-const postImagePreview   = document.getElementById('post-image-preview');
-const postImagePreviewImg= document.getElementById('post-image-preview-img');
-const postImageRemoveBtn = document.getElementById('post-image-remove-btn');
+const postPublishAtInput = document.getElementById('post-publish-at');
 
 let activeSpecialFilter = 'all';
 let activeCategories    = new Set();
-let pendingPostImageURL = null;
 
 
-postImageBtn.addEventListener('click', () => {
-  postImageInput.value = '';
-  postImageInput.click();
+const postAttachments = createAttachmentPicker({
+  input   : postImageInput,
+  button  : postImageBtn,
+  list    : document.getElementById('post-attachments'),
+  onChange: () => scheduleDraftAutosave(),
 });
 
-postImageInput.addEventListener('change', async () => {
-  const file = postImageInput.files[0];
-  if (!file) return;
-
-  postImageBtn.disabled = true;
-  postImageBtn.classList.add('uploading');
-
-  try {
-    const url = await uploadImage(file);
-    if (!url) return;
-    pendingPostImageURL = url;
-    postImagePreviewImg.src = url;
-    postImagePreview.hidden = false;
-  } finally {
-    postImageBtn.disabled = false;
-    postImageBtn.classList.remove('uploading');
-  }
-});
-
-postImageRemoveBtn.addEventListener('click', clearPostImagePreview);
-
-function clearPostImagePreview() {
-  pendingPostImageURL = null;
-  postImagePreview.hidden = true;
-  postImagePreviewImg.src = '';
-  postImageInput.value = '';
-}
-
-
-
-async function uploadImage(file) {
-  const form = new FormData();
-  form.append('image', file);
-  try {
-    const res = await authFetch(`${API_BASE}/api/upload`, { method: 'POST', body: form });
-    const data = await res.json();
-    if (!res.ok) {
-      alert(data.error || 'Upload failed');
-      return null;
-    }
-    return data.url;
-  } catch {
-    alert('Upload failed. Please try again.');
-    return null;
-  }
-}
-
 postContentInput.addEventListener('keydown', (e) => {
   if (e.key === 'Enter' && (e.ctrlKey || e.metaKey)) {
     e.preventDefault();
@@ -84,12 +36,15 @@ function getSelectedCategories() {
     .map(el => el.value);
 }
 
+// feedTopics are the live-event topics for the feed currently on screen.
+let feedTopics = [];
+
 async function loadPosts() {
   postsFeed.innerHTML   = '';
   postsFeedEmpty.hidden = true;
 
   let url = `${API_BASE}/api/posts`;
-  if (activeSpecialFilter === 'mine' || activeSpecialFilter === 'liked') {
+  if (['mine', 'liked', 'following', 'saved'].includes(activeSpecialFilter)) {
     url += `?filter=${activeSpecialFilter}`;
   } else if (activeCategories.size > 0) {
     url += `?categories=${[...activeCategories].join(',')}`;
@@ -104,6 +59,7 @@ async function loadPosts() {
       return;
     }
 
+    await setFeedTopics(data || []);
     if (!data || data.length === 0) {
       postsFeedEmpty.hidden = false;
       return;
@@ -116,6 +72,35 @@ async function loadPosts() {
   }
 }
 
+// New posts matching the filter, plus votes on every post shown
+async function setFeedTopics(posts) {
+  const me = JSON.parse(sessionStorage.getItem('user') || '{}');
+  if (activeSpecialFilter === 'mine') {
+    feedTopics = [`user:${me.id}`];
+  } else if (activeSpecialFilter === 'following') {
+    const following = await loadFollowing();
+    feedTopics = following.map(u => `user:${u.id}`);
+  } else if (activeSpecialFilter === 'liked' || activeSpecialFilter === 'saved') {
+    feedTopics = [];
+  } else if (activeCategories.size > 0) {
+    feedTopics = [...activeCategories].map(c => `category:${c}`);
+  } else {
+    feedTopics = ['feed'];
+  }
+  feedTopics.push(...posts.map(p => `post:${p.id}`));
+  setTopics(feedTopics);
+}
+
+async function loadFollowing() {
+  try {
+    const res  = await authFetch(`${API_BASE}/api/users/following`);
+    const data = await res.json();
+    return res.ok ? data : [];
+  } catch {
+    return [];
+  }
+}
+
 function buildCategoryBadges(categoryStr) {
   if (!categoryStr) return '';
   return categoryStr.split(',')
@@ -131,12 +116,6 @@ function buildPostCard(post) {
   const upActive   = post.user_vote ===  1 ? 'vote-btn--active' : '';
   const downActive = post.user_vote === -1 ? 'vote-btn--active' : '';
 
-  const imgHTML = post.image_url
-    ? `<div class="post-card__img-wrap">
-         <img src="${escapeHTML(post.image_url)}" class="post-card__img" alt="post image" loading="lazy"/>
-       </div>`
-    : '';
-
   article.innerHTML = `
     <div class="post-card__header">
       <span class="post-card__author">@${escapeHTML(post.nickname)}</span>
@@ -145,8 +124,9 @@ function buildPostCard(post) {
     </div>
     <div class="post-card__body">
       <h3 class="post-card__title">${escapeHTML(post.title)}</h3>
-      ${post.content ? `<p class="post-card__content">${escapeHTML(post.content)}</p>` : ''}
-      ${imgHTML}
+      ${post.content ? `<div class="post-card__content rich-text">${renderContent(post)}</div>` : ''}
+      <span class="attachments-slot"></span>
+      <span class="poll-slot"></span>
     </div>
     <div class="post-card__footer">
       <button class="vote-btn vote-btn--up ${upActive}" data-value="1">
@@ -157,6 +137,7 @@ function buildPostCard(post) {
         <svg width="14" height="14" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2.5" stroke-linecap="round" stroke-linejoin="round"><polyline points="6 9 12 15 18 9"/></svg>
         <span class="vote-count">${post.downvotes}</span>
       </button>
+      <span class="bookmark-slot"></span>
       <span class="post-card__comments-hint">
         <svg width="13" height="13" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M21 15a2 2 0 0 1-2 2H7l-4 4V5a2 2 0 0 1 2-2h14a2 2 0 0 1 2 2z"/></svg>
         click to comment
@@ -164,16 +145,10 @@ function buildPostCard(post) {
     </div>`;
 
   
-  const imgEl = article.querySelector('.post-card__img');
-  if (imgEl) {
-    imgEl.addEventListener('click', (e) => {
-      e.stopPropagation();
-      openLightbox(post.image_url);
-    });
-  }
+  fillAttachmentSlot(article, post);
 
   article.addEventListener('click', (e) => {
-    if (!e.target.closest('.vote-btn') && !e.target.closest('.post-card__img-wrap')) {
+    if (!e.target.closest('.vote-btn') && !e.target.closest('.bookmark-btn')) {
       openPostDetail(post);
     }
   });
@@ -196,9 +171,52 @@ function buildPostCard(post) {
     });
   });
 
+  article.querySelector('.bookmark-slot').replaceWith(buildBookmarkButton(post));
+  const pollSlot = article.querySelector('.poll-slot');
+  if (post.poll) pollSlot.replaceWith(buildPoll(post.poll));
+  else pollSlot.remove();
+  // Links in the post open in a new tab without also opening the post
+  article.querySelectorAll('.rich-text a:not(.mention)').forEach(a =>
+    a.addEventListener('click', (e) => e.stopPropagation()));
   return article;
 }
 
+// Private bookmark toggle. Every button for the same post (feed card and
+// detail view) is kept in sync.
+function buildBookmarkButton(post) {
+  const btn = document.createElement('button');
+  btn.type = 'button';
+  btn.className = 'bookmark-btn';
+  const render = () => {
+    btn.classList.toggle('bookmark-btn--active', !!post.is_bookmarked);
+    btn.title = post.is_bookmarked ? 'Remove from saved' : 'Save';
+    btn.innerHTML = `<svg width="14" height="14" viewBox="0 0 24 24" fill="${post.is_bookmarked ? 'currentColor' : 'none'}" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M19 21l-7-5-7 5V5a2 2 0 0 1 2-2h10a2 2 0 0 1 2 2z"/></svg>`;
+  };
+  render();
+  btn.dataset.postId = post.id;
+  btn.addEventListener('bookmark-sync', (e) => {
+    post.is_bookmarked = e.detail;
+    render();
+  });
+
+  btn.addEventListener('click', async (e) => {
+    e.stopPropagation();
+    const path = post.is_bookmarked ? '/api/bookmarks/delete' : '/api/bookmarks';
+    try {
+      const res = await authFetch(`${API_BASE}${path}`, {
+        method : 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body   : JSON.stringify({ target_type: 'post', target_id: post.id }),
+      });
+      if (!res.ok) return;
+      const saved = (await res.json()).is_bookmarked;
+      document.querySelectorAll(`.bookmark-btn[data-post-id="${post.id}"]`)
+        .forEach(b => b.dispatchEvent(new CustomEvent('bookmark-sync', { detail: saved })));
+    } catch { /* silent */ }
+  });
+  return btn;
+}
+
 async function submitVote(postID, value) {
   try {
     const res  = await authFetch(`${API_BASE}/api/votes`, {
@@ -229,20 +247,30 @@ createPostForm.addEventListener('submit', async (e) => {
   if (categories.length === 0) {
     postCategoryError.textContent = 'Select at least one category.'; valid = false;
   }
-  if (!postContentInput.value.trim() && !pendingPostImageURL) {
+  if (!postContentInput.value.trim() && !postAttachments.hasItems()) {
     postContentError.textContent = 'Add content or an image.'; valid = false;
   }
+  const poll = getPollSpec();
+  pollError.textContent = validatePollSpec(poll);
+  if (pollError.textContent) valid = false;
   if (!valid) return;
 
+  // datetime-local is in the browser's time zone; the server wants an instant
+  const publishAt = postPublishAtInput.value ? new Date(postPublishAtInput.value).toISOString() : '';
+
+  cancelDraftAutosave();
   try {
     const res = await authFetch(`${API_BASE}/api/posts`, {
       method : 'POST',
       headers: { 'Content-Type': 'application/json' },
       body   : JSON.stringify({
-        title     : postTitleInput.value.trim(),
-        categories: categories,
-        content   : postContentInput.value.trim(),
-        image_url : pendingPostImageURL || '',
+        title      : postTitleInput.value.trim(),
+        categories : categories,
+        content    : postContentInput.value.trim(),
+        attachments: postAttachments.refs(),
+        draft_id   : currentDraftID || '',
+        publish_at : publishAt,
+        poll       : poll,
       }),
     });
     const data = await res.json();
@@ -250,10 +278,16 @@ createPostForm.addEventListener('submit', async (e) => {
       createPostError.textContent = data.error || 'Failed to create post.';
       return;
     }
+    resetPostForm();
+    if (res.status === 202) { // scheduled, not published yet
+      setDraftStatus(`Scheduled for ${formatDate(data.publish_at)}`);
+      if (!draftsPanel.hidden) loadDrafts();
+      return;
+    }
     postsFeed.prepend(buildPostCard(data));
+    feedTopics.push(`post:${data.id}`);
+    addTopics([`post:${data.id}`]);
     postsFeedEmpty.hidden = true;
-    createPostForm.reset();
-    clearPostImagePreview();
   } catch {
     createPostError.textContent = 'Network error. Please try again.';
   }
@@ -38,9 +38,39 @@ document.getElementById('main-navbar').innerHTML = `
     </svg>
     <span id="nav-messages-badge" hidden></span>
   </button>
+  <div id="nav-notif">
+    <button type="button" id="nav-notif-btn" title="Notifications" aria-label="Notifications">
+      <svg xmlns="http://www.w3.org/2000/svg" width="20" height="20" viewBox="0 0 24 24"
+           fill="none" stroke="currentColor" stroke-width="2"
+           stroke-linecap="round" stroke-linejoin="round">
+        <path d="M18 8A6 6 0 0 0 6 8c0 7-3 9-3 9h18s-3-2-3-9"/>
+        <path d="M13.73 21a2 2 0 0 1-3.46 0"/>
+      </svg>
+      <span id="nav-notif-badge" hidden></span>
+    </button>
+    <div id="notif-panel" hidden>
+      <div id="notif-panel-header">
+        <strong>Notifications</strong>
+        <button type="button" id="notif-read-all-btn">Mark all read</button>
+        <button type="button" id="notif-prefs-btn" title="Settings" aria-label="Notification settings">&#9881;</button>
+      </div>
+      <div id="notif-prefs" hidden></div>
+      <div id="notif-list"></div>
+      <p id="notif-empty" hidden>You're all caught up.</p>
+      <button type="button" id="notif-more-btn" hidden>Load more</button>
+    </div>
+  </div>
   <span id="nav-online-count" title="Users online" hidden></span>
   <div id="navbar-user">
     <span id="navbar-username"></span>
+    <select id="navbar-status" title="Your status" aria-label="Your status">
+      <option value="online">Online</option>
+      <option value="away">Away</option>
+      <option value="dnd">Do not disturb</option>
+      <option value="invisible">Invisible</option>
+    </select>
+    <input type="text" id="navbar-status-text" maxlength="80"
+           placeholder="What's up?" aria-label="Status message">
     <button type="button" id="logout-btn">Log Out</button>
   </div>
 `;
@@ -143,6 +173,10 @@ document.getElementById('online-users-sidebar').innerHTML = `
       </svg>
     </button>
   </div>
+  <div id="message-search">
+    <input type="search" id="message-search-input" placeholder="Search messages&#8230;" autocomplete="off"/>
+    <ul id="message-search-results" hidden></ul>
+  </div>
   <ul id="online-users-list"></ul>
 `;
 
@@ -176,37 +210,51 @@ document.getElementById('posts-page').innerHTML = `
                   placeholder="What's on your mind?" rows="4" maxlength="2000"></textarea>
         <span class="field-error" id="post-content-error"></span>
       </div>
+      <div class="form-group">
+        <label class="check-label"><input type="checkbox" id="post-poll-toggle"/> Add a poll</label>
+        <div id="post-poll-editor" hidden>
+          <div id="post-poll-options"></div>
+          <button type="button" id="post-poll-add-btn">Add option</button>
+          <label class="check-label"><input type="checkbox" id="post-poll-multiple"/> Allow several choices</label>
+          <label class="check-label"><input type="checkbox" id="post-poll-anonymous"/> Hide who voted for what</label>
+          <label for="post-poll-closes-at">Voting closes (optional)</label>
+          <input type="datetime-local" id="post-poll-closes-at"/>
+        </div>
+        <span class="field-error" id="post-poll-error"></span>
+      </div>
       <div class="attach-row">
-        <input type="file" id="post-image-input" accept="image/jpeg,image/png,image/gif,image/webp" hidden/>
-        <button type="button" id="post-image-btn" class="attach-btn" title="Attach image">
+        <input type="file" id="post-image-input" accept="image/jpeg,image/png,image/gif,image/webp" multiple hidden/>
+        <button type="button" id="post-image-btn" class="attach-btn" title="Attach images">
           <svg width="18" height="18" viewBox="0 0 24 24" fill="none" stroke="currentColor"
                stroke-width="1.8" stroke-linecap="round" stroke-linejoin="round">
             <rect x="3" y="3" width="18" height="18" rx="2" ry="2"/>
             <circle cx="8.5" cy="8.5" r="1.5"/>
             <polyline points="21 15 16 10 5 21"/>
           </svg>
-          <span>Add photo</span>
+          <span>Add photos</span>
         </button>
       </div>
-      <div id="post-image-preview" class="img-preview-wrap" hidden>
-        <img id="post-image-preview-img" src="" alt="preview" class="img-preview"/>
-        <button type="button" id="post-image-remove-btn" class="img-preview-remove"
-                title="Remove image" aria-label="Remove image">
-          <svg width="14" height="14" viewBox="0 0 24 24" fill="none" stroke="currentColor"
-               stroke-width="2.5" stroke-linecap="round" stroke-linejoin="round">
-            <line x1="18" y1="6" x2="6" y2="18"/><line x1="6" y1="6" x2="18" y2="18"/>
-          </svg>
-        </button>
+      <div id="post-attachments" class="attachment-previews" hidden></div>
+      <div class="form-group">
+        <label for="post-publish-at">Publish later (optional)</label>
+        <input type="datetime-local" id="post-publish-at" name="publish_at"/>
       </div>
       <span class="form-error" id="create-post-error"></span>
       <button type="submit" id="create-post-submit" class="btn btn--primary">Publish Post</button>
+      <div class="drafts-bar">
+        <span id="draft-status"></span>
+        <button type="button" id="drafts-toggle-btn">Drafts</button>
+      </div>
     </form>
+    <div id="drafts-panel" hidden></div>
   </div>
 
   <nav id="posts-filter-bar" aria-label="Filter posts">
     <button class="filter-btn filter-btn--active" data-filter="all">All</button>
     <button class="filter-btn" data-filter="mine">My Posts</button>
     <button class="filter-btn" data-filter="liked">Liked</button>
+    <button class="filter-btn" data-filter="following">Following</button>
+    <button class="filter-btn" data-filter="saved">Saved</button>
     <span class="filter-sep"></span>
     <button class="filter-btn" data-category="general">General</button>
     <button class="filter-btn" data-category="technology">Technology</button>
@@ -235,6 +283,19 @@ document.getElementById('post-detail-page').innerHTML = `
                   placeholder="Share your thoughts..." rows="3"></textarea>
         <span class="field-error" id="comment-input-error"></span>
       </div>
+      <div class="attach-row">
+        <input type="file" id="comment-image-input" accept="image/jpeg,image/png,image/gif,image/webp" multiple hidden/>
+        <button type="button" id="comment-image-btn" class="attach-btn" title="Attach images">
+          <svg width="18" height="18" viewBox="0 0 24 24" fill="none" stroke="currentColor"
+               stroke-width="1.8" stroke-linecap="round" stroke-linejoin="round">
+            <rect x="3" y="3" width="18" height="18" rx="2" ry="2"/>
+            <circle cx="8.5" cy="8.5" r="1.5"/>
+            <polyline points="21 15 16 10 5 21"/>
+          </svg>
+          <span>Add photos</span>
+        </button>
+      </div>
+      <div id="comment-attachments" class="attachment-previews" hidden></div>
       <button type="submit" class="comment-submit-btn">
         <svg width="15" height="15" viewBox="0 0 24 24" fill="none" stroke="currentColor"
              stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
@@ -266,6 +327,8 @@ document.getElementById('chat-panel').innerHTML = `
       <button type="button" id="chat-back-btn">&larr;</button>
       <span id="chat-partner-status" class="status-dot"></span>
       <strong id="chat-partner-name"></strong>
+      <span id="chat-partner-typing" hidden>typing&#8230;</span>
+      <span id="chat-partner-seen"></span>
     </header>
 
     <div id="chat-messages-area">
@@ -279,7 +342,7 @@ document.getElementById('chat-panel').innerHTML = `
 
     <form id="chat-input-form" novalidate onsubmit="event.preventDefault()">
       <input type="file" id="chat-image-input"
-             accept="image/jpeg,image/png,image/gif,image/webp" hidden/>
+             accept="image/jpeg,image/png,image/gif,image/webp" multiple hidden/>
       <button type="button" id="chat-image-btn" class="chat-attach-btn"
               title="Attach image" aria-label="Attach image">
         <svg width="20" height="20" viewBox="0 0 24 24" fill="none" stroke="currentColor"
@@ -290,16 +353,7 @@ document.getElementById('chat-panel').innerHTML = `
         </svg>
       </button>
       <div class="chat-input-col">
-        <div id="chat-image-preview" class="chat-img-preview-wrap" hidden>
-          <img id="chat-image-preview-img" src="" alt="preview" class="chat-img-preview"/>
-          <button type="button" id="chat-image-remove-btn" class="chat-img-remove"
-                  title="Remove" aria-label="Remove image">
-            <svg width="12" height="12" viewBox="0 0 24 24" fill="none" stroke="currentColor"
-                 stroke-width="2.5" stroke-linecap="round" stroke-linejoin="round">
-              <line x1="18" y1="6" x2="6" y2="18"/><line x1="6" y1="6" x2="18" y2="18"/>
-            </svg>
-          </button>
-        </div>
+        <div id="chat-attachments" class="attachment-previews attachment-previews--compact" hidden></div>
         <textarea id="chat-input" name="message"
                   placeholder="Type a message&#8230;" rows="1" maxlength="500"></textarea>
       </div>
@@ -461,6 +515,7 @@ function showPage(name) {
     if (postsPage)  postsPage.style.display  = '';
     if (typeof loadPosts === 'function') loadPosts();
     if (typeof initChat  === 'function') initChat();
+    if (typeof loadNotifications === 'function') loadNotifications();
   }
 }
 